@@ -1,18 +1,37 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	urlshortener "github.com/kuhlman-labs/url-shortener/url-shortener"
 	"gopkg.in/yaml.v3"
 )
 
+// shutdownTimeout bounds how long in-flight requests may take to finish once the server is stopped
+const shutdownTimeout = 10 * time.Second
+
 type Config struct {
 	TemplatePath string `yaml:"template_path"`
 	Port         string `yaml:"port"`
-	Domain       string `yaml:"domain"`
+	// AdminAddr is the address the metrics, export and import are served on, such as 127.0.0.1:9090,
+	// empty to not serve them
+	AdminAddr string                      `yaml:"admin_addr"`
+	Database  urlshortener.DatabaseConfig `yaml:"database"`
+
+	urlshortener.ServiceConfig `yaml:",inline"`
 }
 
 func main() {
@@ -28,15 +47,281 @@ func main() {
 		log.Fatalf("Error unmarshalling config.yaml: %v", err)
 	}
 
-	// Create a new SQL URL repository
-	db, err := urlshortener.NewSQLURLRepository()
+	// The environment overrides the public domain, so one config file serves every deployment
+	if domain := os.Getenv("URL_SHORTENER_DOMAIN"); domain != "" {
+		config.Domain = domain
+	}
+	if config.Domain == "" {
+		config.Domain = "http://localhost:" + config.Port + "/"
+	}
+
+	// Run the migrate command instead of the server if it was requested
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = migrate(config.Database, os.Args[2:])
+		if err != nil {
+			log.Fatalf("Error migrating database: %v", err)
+		}
+		return
+	}
+
+	// Export or import links instead of running the server if it was requested
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := exportLinks(config, os.Args[2:]); err != nil {
+			log.Fatalf("Error exporting links: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := importLinks(config, os.Args[2:]); err != nil {
+			log.Fatalf("Error importing links: %v", err)
+		}
+		return
+	}
+
+	// Create the URL repository selected in the config
+	db, err := urlshortener.NewURLRepository(config.Database)
 	if err != nil {
-		log.Fatalf("Error creating SQL URL repository: %v", err)
+		log.Fatalf("Error creating URL repository: %v", err)
 	}
 
-	// Start the URL handler
-	err = http.ListenAndServe(":"+config.Port, urlshortener.URLHandler(db, config.TemplatePath))
+	// Create the URL service
+	svc, err := urlshortener.NewService(db, config.ServiceConfig)
 	if err != nil {
+		log.Fatalf("Error creating URL service: %v", err)
+	}
+
+	// Stop on Ctrl-C or when the process manager asks
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Sweep expired links until the server shuts down
+	var sweeper sync.WaitGroup
+	sweeper.Add(1)
+	go func() {
+		defer sweeper.Done()
+		svc.RunSweeper(ctx)
+	}()
+
+	// Record clicks until the server has finished its last requests, which may still click
+	recorderCtx, stopRecorder := context.WithCancel(context.Background())
+	var recorder sync.WaitGroup
+	recorder.Add(1)
+	go func() {
+		defer recorder.Done()
+		svc.RunClickRecorder(recorderCtx)
+	}()
+
+	// Start the URL handler
+	server := &http.Server{
+		Addr:    ":" + config.Port,
+		Handler: urlshortener.URLHandler(svc, config.TemplatePath),
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// Serve the metrics, export and import on their own listener, away from the public one
+	var admin *http.Server
+	adminErr := make(chan error, 1)
+	if config.AdminAddr != "" {
+		admin = &http.Server{
+			Addr:    config.AdminAddr,
+			Handler: urlshortener.AdminHandler(svc),
+		}
+		go func() {
+			adminErr <- admin.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
 		log.Fatalf("Error starting URL handler: %v", err)
+	case err := <-adminErr:
+		log.Fatalf("Error starting admin handler on %s: %v", config.AdminAddr, err)
+	case <-ctx.Done():
+	}
+
+	// Let in-flight requests finish, then record their clicks and wait for the sweeper, which stops with ctx
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down URL handler: %v", err)
+	}
+	if admin != nil {
+		if err := admin.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down admin handler: %v", err)
+		}
+	}
+	stopRecorder()
+	recorder.Wait()
+	sweeper.Wait()
+}
+
+// migrate applies or reverts schema migrations: migrate up | migrate down [steps] | migrate status
+func migrate(config urlshortener.DatabaseConfig, args []string) error {
+	if config.Driver == urlshortener.DriverMemory {
+		return errors.New("the memory driver has no schema to migrate")
+	}
+
+	repo, err := urlshortener.NewSQLURLRepository(config.Driver, config.DSN)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	ctx := context.Background()
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		err = repo.MigrateUp(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+		err = repo.MigrateDown(ctx, steps)
+	case "status":
+		status, err := repo.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range status {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", st.Version, st.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down [steps] or status", command)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := repo.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Database is at schema version %d", version)
+	return nil
+}
+
+// newService opens the repository selected by config and returns the service for the export and
+// import commands, with a func that closes the repository once the command is done
+func newService(config Config) (*urlshortener.Service, func() error, error) {
+	if config.Database.Driver == urlshortener.DriverMemory {
+		return nil, nil, errors.New("the memory driver has no links to export or import")
+	}
+
+	repo, err := urlshortener.NewURLRepository(config.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeRepo := func() error { return nil }
+	if closer, ok := repo.(io.Closer); ok {
+		closeRepo = closer.Close
+	}
+
+	svc, err := urlshortener.NewService(repo, config.ServiceConfig)
+	if err != nil {
+		closeRepo()
+		return nil, nil, err
+	}
+	return svc, closeRepo, nil
+}
+
+// exportLinks writes every link to a file or stdout: export [-format csv|ndjson] [-timestamps] [-deleted] [-o file]
+func exportLinks(config Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", urlshortener.FormatCSV, "csv or ndjson")
+	timestamps := flags.Bool("timestamps", false, "include when links were created and updated")
+	deleted := flags.Bool("deleted", false, "include soft-deleted links")
+	output := flags.String("o", "", "file to write to instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	svc, closeRepo, err := newService(config)
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	opts := urlshortener.ExportOptions{Format: *format, Timestamps: *timestamps, Deleted: *deleted}
+	n, err := svc.ExportLinks(context.Background(), w, opts)
+	if err != nil {
+		return err
+	}
+	log.Printf("Exported %d links", n)
+	return nil
+}
+
+// importLinks imports the links of a file, or stdin for -: import [-format csv|ndjson] file.
+// The format defaults to ndjson for .ndjson and .jsonl files and to csv otherwise.
+func importLinks(config Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "csv or ndjson")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected the file to import, or - for stdin")
+	}
+
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = urlshortener.FormatCSV
+		if ext := filepath.Ext(path); ext == ".ndjson" || ext == ".jsonl" {
+			*format = urlshortener.FormatNDJSON
+		}
+	}
+
+	svc, closeRepo, err := newService(config)
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	report, err := svc.ImportLinks(context.Background(), r, *format)
+	if err != nil {
+		return err
+	}
+	for _, failure := range report.Failed {
+		fmt.Fprintf(os.Stderr, "row %d (%s): %v\n", failure.Row, failure.Slug, failure.Err)
+	}
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d of %d links were not imported", len(report.Failed), report.Imported+len(report.Failed))
 	}
+	return nil
 }
@@ -0,0 +1,663 @@
+package urlshortener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiRoot is where the versioned API lives
+const apiRoot = "/api/v1"
+
+// apiPrefix is where the links resource of the versioned API lives
+const apiPrefix = apiRoot + "/links"
+
+// Link is a struct that represents a short link in the v1 API
+type Link struct {
+	Slug     string `json:"slug"`
+	ShortURL string `json:"short_url"`
+	// URL is the destination the link redirects to. The API is not authenticated, so it is left out
+	// for links that do not reveal their destination to everyone: password-protected links, links
+	// that are not live yet and click-limited links.
+	URL string `json:"url,omitempty"`
+	// Domain is the host of the domain the link was minted under
+	Domain         string     `json:"domain"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	ClicksLeft     *int64     `json:"clicks_left,omitempty"`
+	Window         *Window    `json:"window,omitempty"`
+	RedirectStatus int        `json:"redirect_status,omitempty"`
+	Protected      bool       `json:"password_protected,omitempty"`
+	Tags           []string   `json:"tags,omitempty"`
+	// Clicks is how often the link was clicked; recent clicks are counted within seconds
+	Clicks int64 `json:"clicks"`
+}
+
+// LinkPatch is the body of a PATCH request. Fields left out are not changed; an empty window
+// removes the link's window and an empty list its tags.
+type LinkPatch struct {
+	URL    *string   `json:"url,omitempty"`
+	Window *Window   `json:"window,omitempty"`
+	Tags   *[]string `json:"tags,omitempty"`
+}
+
+// LinkList is the body of a list response, one page of links
+type LinkList struct {
+	Links []Link `json:"links"`
+	// NextCursor is passed as cursor, with the same query, to get the next page. It is left out on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// newLink returns the API representation of link, minted under domain
+func newLink(domain ShortDomain, link *URLSchema) Link {
+	l := Link{
+		Slug:           link.Slug,
+		ShortURL:       link.ShortUrl,
+		Domain:         domain.Host,
+		CreatedAt:      link.CreatedAt,
+		ExpiresAt:      link.ExpiresAt,
+		ClicksLeft:     link.ClicksLeft,
+		RedirectStatus: link.RedirectStatus,
+		Protected:      link.PasswordHash != "",
+		Tags:           link.Tags,
+		Clicks:         link.Clicks,
+	}
+	if link.NotBefore != nil || link.NotAfter != nil {
+		l.Window = &Window{NotBefore: link.NotBefore, NotAfter: link.NotAfter}
+	}
+	if link.PasswordHash == "" && link.ClicksLeft == nil && !link.Pending(time.Now()) {
+		l.URL = link.LongUrl
+	}
+	return l
+}
+
+// linkPath returns the path of link in the v1 API, selecting its domain unless it is the primary one
+func linkPath(domain ShortDomain, slug string) string {
+	path := apiPrefix + "/" + slug
+	if domain.key != "" {
+		path += "?domain=" + url.QueryEscape(domain.Host)
+	}
+	return path
+}
+
+// registerAPI adds the routes of the v1 API to mux. Links are addressed by slug, with the domain
+// query parameter selecting a branded domain.
+func registerAPI(mux *http.ServeMux, svc *Service) {
+	api := http.NewServeMux()
+	api.HandleFunc("GET "+apiPrefix, listLinksHandler(svc))
+	api.HandleFunc("POST "+apiPrefix, createLinkHandler(svc))
+	api.HandleFunc("POST "+apiPrefix+"/bulk", bulkCreateHandler(svc))
+	api.HandleFunc("GET "+apiPrefix+"/{slug}", getLinkHandler(svc))
+	api.HandleFunc("PATCH "+apiPrefix+"/{slug}", patchLinkHandler(svc))
+	api.HandleFunc("DELETE "+apiPrefix+"/{slug}", deleteLinkHandler(svc))
+	api.HandleFunc("GET "+apiPrefix+"/{slug}/stats", statsHandler(svc))
+
+	mux.Handle(apiRoot+"/", apiRoutes(api))
+}
+
+// apiMethods are the methods the Allow header of a 405 response is made of
+var apiMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// apiRoutes serves the requests that api has a route for. Everything else under the API gets the
+// JSON error response rather than the mux's plain text: 405 with an Allow header when the path is
+// served with other methods, 404 otherwise.
+func apiRoutes(api *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := api.Handler(r); pattern != "" {
+			api.ServeHTTP(w, r)
+			return
+		}
+
+		var allowed []string
+		for _, method := range apiMethods {
+			probe := r.WithContext(r.Context())
+			probe.Method = method
+			if _, pattern := api.Handler(probe); pattern != "" {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) == 0 {
+			writeError(w, r, fmt.Errorf("%w: %s", errNoEndpoint, r.URL.Path))
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, r, fmt.Errorf("%w: %s %s", errMethodNotAllowed, r.Method, r.URL.Path))
+	})
+}
+
+func createLinkHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req URLRequest
+		if err := decodeJSON(r, &req); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if err := checkCreate(req); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		domain, err := svc.resolveDomain(req.Domain)
+		if err != nil {
+			writeError(w, r, fieldError("domain", err))
+			return
+		}
+
+		link, err := svc.Shorten(r.Context(), req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		log.Printf("Created link %s for %s", link.ShortUrl, link.LongUrl)
+		w.Header().Set("Location", linkPath(domain, link.Slug))
+		writeJSON(w, http.StatusCreated, newLink(domain, link))
+	}
+}
+
+// listLinksHandler serves a page of the links on the domain selected by the domain query parameter.
+// The links are filtered by destination host, a substring q of their destination or slug, a tag and
+// an RFC 3339 creation range, and sorted by created or clicks in desc or asc order.
+func listLinksHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		domain, err := svc.resolveDomain(query.Get("domain"))
+		if err != nil {
+			writeError(w, r, fieldError("domain", err))
+			return
+		}
+
+		filter := URLFilter{
+			Domain:   domain.key,
+			Host:     query.Get("host"),
+			Contains: query.Get("q"),
+			Tag:      query.Get("tag"),
+			Sort:     query.Get("sort"),
+		}
+		switch query.Get("order") {
+		case "", "desc":
+		case "asc":
+			filter.Ascending = true
+		default:
+			writeError(w, r, fieldError("order", fmt.Errorf("%w: order must be asc or desc", ErrInvalidRequest)))
+			return
+		}
+		if v := query.Get("limit"); v != "" {
+			if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit == 0 {
+				writeError(w, r, fieldError("limit", fmt.Errorf("%w: limit must be a positive number", ErrInvalidRequest)))
+				return
+			}
+		}
+		if filter.CreatedSince, err = timeParam(query, "created_since"); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if filter.CreatedBefore, err = timeParam(query, "created_before"); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		links, next, err := svc.ListLinks(r.Context(), filter, query.Get("cursor"))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		list := LinkList{Links: make([]Link, len(links)), NextCursor: next}
+		for i := range links {
+			list.Links[i] = newLink(domain, &links[i])
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// BulkResult is a struct that describes the outcome of one link of a bulk request
+type BulkResult struct {
+	// Index is the position of the link in the request, counting from 0
+	Index int `json:"index"`
+	// Status is the status a single create of the link would have answered with
+	Status int       `json:"status"`
+	Link   *Link     `json:"link,omitempty"`
+	Error  *APIError `json:"error,omitempty"`
+}
+
+// BulkResponse is the body of a bulk response, with one result per link in request order
+type BulkResponse struct {
+	Created int          `json:"created"`
+	Failed  int          `json:"failed"`
+	Results []BulkResult `json:"results"`
+}
+
+// ImportResponse is the body of an import response
+type ImportResponse struct {
+	Imported int `json:"imported"`
+	Failed   int `json:"failed"`
+	// Errors describes the links that were not imported, in the order of the import
+	Errors []ImportError `json:"errors"`
+}
+
+// ImportError is a struct that describes why a link of an import was not imported
+type ImportError struct {
+	// Row counts the links of the import from 1, without the CSV header
+	Row   int      `json:"row"`
+	Slug  string   `json:"slug,omitempty"`
+	Error APIError `json:"error"`
+}
+
+// bulkCreateHandler creates the links of a JSON array or NDJSON body and answers with the result
+// of each. Links are stored in transactions of chunk_size links, or all in one with atomic=true.
+func bulkCreateHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		atomic, err := boolParam(query, "atomic")
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		chunkSize := DefaultBulkChunkSize
+		if v := query.Get("chunk_size"); v != "" {
+			if chunkSize, err = strconv.Atoi(v); err != nil || chunkSize < 1 || chunkSize > maxBulkChunkSize {
+				writeError(w, r, fieldError("chunk_size", fmt.Errorf("%w: chunk_size must be between 1 and %d", ErrInvalidRequest, maxBulkChunkSize)))
+				return
+			}
+		}
+
+		reqs, errs, err := decodeBulk(http.MaxBytesReader(w, r.Body, maxBulkBytes), r.Header.Get("Content-Type"))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Links that could not be decoded keep their error, the others are created
+		var decoded []int
+		for i := range reqs {
+			if errs[i] == nil {
+				decoded = append(decoded, i)
+			}
+		}
+		if atomic && len(decoded) < len(reqs) {
+			for _, i := range decoded {
+				errs[i] = ErrBatchAborted
+			}
+			decoded = nil
+		}
+
+		batch := make([]URLRequest, len(decoded))
+		for k, i := range decoded {
+			batch[k] = reqs[i]
+		}
+		links, batchErrs := svc.ShortenAll(r.Context(), batch, atomic, chunkSize)
+
+		response := BulkResponse{Results: make([]BulkResult, len(reqs))}
+		for i := range response.Results {
+			response.Results[i].Index = i
+		}
+		for k, i := range decoded {
+			if batchErrs[k] == nil {
+				// The domain was resolved when the link was created
+				domain, _ := svc.resolveDomain(reqs[i].Domain)
+				link := newLink(domain, links[k])
+				response.Results[i].Status, response.Results[i].Link = http.StatusCreated, &link
+				response.Created++
+			}
+			errs[i] = batchErrs[k]
+		}
+		for i, err := range errs {
+			if err != nil {
+				status, apiErr := newAPIError(err)
+				if status >= http.StatusInternalServerError {
+					log.Printf("Request %s: link %d of bulk create failed: %v", RequestID(r.Context()), i, err)
+				}
+				response.Results[i].Status, response.Results[i].Error = status, &apiErr
+				response.Failed++
+			}
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// exportHandler writes every link as CSV or NDJSON, selected by the format query parameter, with
+// their timestamps if timestamps=true and the soft-deleted links if deleted=true
+func exportHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		opts := ExportOptions{Format: query.Get("format")}
+		if opts.Format == "" {
+			opts.Format = FormatCSV
+		}
+		var err error
+		if opts.Timestamps, err = boolParam(query, "timestamps"); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if opts.Deleted, err = boolParam(query, "deleted"); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if err := checkFormat(opts.Format); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		contentType := "application/x-ndjson"
+		if opts.Format == FormatCSV {
+			contentType = "text/csv; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="links.%s"`, opts.Format))
+
+		n, err := svc.ExportLinks(r.Context(), w, opts)
+		if err != nil {
+			log.Printf("Request %s: export failed after %d links: %v", RequestID(r.Context()), n, err)
+			// Nothing has been sent before the first page was read, later failures can only cut the body short
+			if n == 0 {
+				w.Header().Del("Content-Disposition")
+				writeError(w, r, err)
+			}
+			return
+		}
+		log.Printf("Exported %d links", n)
+	}
+}
+
+// importHandler imports the links of a CSV or NDJSON body, selected by the format query parameter
+// or else the Content-Type, and answers with how many were imported and why the others were not
+func importHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = FormatCSV
+			if isNDJSON(r.Header.Get("Content-Type")) {
+				format = FormatNDJSON
+			}
+		}
+
+		report, err := svc.ImportLinks(r.Context(), http.MaxBytesReader(w, r.Body, maxImportBytes), format)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = fmt.Errorf("%w: the body is larger than %d bytes", ErrRequestTooLarge, tooLarge.Limit)
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		response := ImportResponse{Imported: report.Imported, Failed: len(report.Failed), Errors: []ImportError{}}
+		for _, failure := range report.Failed {
+			_, apiErr := newAPIError(failure.Err)
+			response.Errors = append(response.Errors, ImportError{Row: failure.Row, Slug: failure.Slug, Error: apiErr})
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+func getLinkHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		domain, link, ok := lookupLink(w, r, svc)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, newLink(domain, link))
+	}
+}
+
+func patchLinkHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var patch LinkPatch
+		if err := decodeJSON(r, &patch); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		domain, link, ok := lookupLink(w, r, svc)
+		if !ok {
+			return
+		}
+
+		if err := svc.UpdateLink(r.Context(), domain, link, patch); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newLink(domain, link))
+	}
+}
+
+func deleteLinkHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		domain, link, ok := lookupLink(w, r, svc)
+		if !ok {
+			return
+		}
+
+		if err := svc.repo.DeleteURLBySlug(r.Context(), domain.key, link.Slug); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// LinkStatsResponse is the body of a stats response, the clicks on one link
+type LinkStatsResponse struct {
+	Slug     string `json:"slug"`
+	ShortURL string `json:"short_url"`
+	// Bucket is the size of the buckets, hour or day
+	Bucket string    `json:"bucket"`
+	Since  time.Time `json:"since"`
+	ClickStats
+}
+
+// statsHandler serves the clicks on the link named by the request path, on the domain selected by
+// the domain query parameter. The clicks are counted in hour or day buckets, selected by bucket, since the RFC 3339 time since.
+func statsHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		domain, err := svc.resolveDomain(query.Get("domain"))
+		if err != nil {
+			writeError(w, r, fieldError("domain", err))
+			return
+		}
+
+		bucketName := query.Get("bucket")
+		if bucketName == "" {
+			bucketName = "day"
+		}
+		bucket, ok := statsBuckets[bucketName]
+		if !ok {
+			writeError(w, r, fieldError("bucket", fmt.Errorf("%w: bucket must be hour or day", ErrInvalidRequest)))
+			return
+		}
+
+		now := time.Now()
+		since := now.Add(-bucket.defaultRange)
+		if v := query.Get("since"); v != "" {
+			since, err = time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, r, fieldError("since", fmt.Errorf("%w: since must be an RFC 3339 time", ErrInvalidRequest)))
+				return
+			}
+		}
+		since = since.UTC().Truncate(bucket.size)
+		if since.After(now) || now.Sub(since)/bucket.size >= maxStatsBuckets {
+			writeError(w, r, fieldError("since", fmt.Errorf("%w: since must be in the past and at most %d buckets ago", ErrInvalidRequest, maxStatsBuckets)))
+			return
+		}
+
+		link, stats, err := svc.LinkStats(r.Context(), domain, r.PathValue("slug"), since, bucket.size)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if link == nil {
+			writeError(w, r, ErrNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, LinkStatsResponse{
+			Slug:       link.Slug,
+			ShortURL:   link.ShortUrl,
+			Bucket:     bucketName,
+			Since:      since,
+			ClickStats: *stats,
+		})
+	}
+}
+
+// checkCreate rejects the fields of a URLRequest that only apply to updates
+func checkCreate(req URLRequest) error {
+	if req.NewURL != "" {
+		return fieldError("new_url", fmt.Errorf("%w: new_url is not accepted on create, use PATCH to change a link", ErrInvalidRequest))
+	}
+	return nil
+}
+
+// decodeBulk reads the links of a bulk request body, a JSON array of links or NDJSON with one link
+// per line as selected by contentType. A link that cannot be decoded is reported by its error, at its
+// index; only a body that cannot be split into links fails as a whole.
+func decodeBulk(body io.Reader, contentType string) ([]URLRequest, []error, error) {
+	var items [][]byte
+	if isNDJSON(contentType) {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), maxBulkLineBytes)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				items = append(items, append([]byte(nil), line...))
+			}
+			if len(items) > maxBulkItems {
+				break
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, bodyError(err)
+		}
+	} else {
+		var raw []json.RawMessage
+		if err := json.NewDecoder(body).Decode(&raw); err != nil {
+			return nil, nil, bodyError(err)
+		}
+		for _, item := range raw {
+			items = append(items, item)
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, nil, fmt.Errorf("%w: the request has no links", ErrInvalidRequest)
+	}
+	if len(items) > maxBulkItems {
+		return nil, nil, fmt.Errorf("%w: a request can create at most %d links", ErrRequestTooLarge, maxBulkItems)
+	}
+
+	reqs := make([]URLRequest, len(items))
+	errs := make([]error, len(items))
+	for i, item := range items {
+		decoder := json.NewDecoder(bytes.NewReader(item))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&reqs[i]); err != nil {
+			errs[i] = fmt.Errorf("%w: error decoding link: %v", ErrInvalidRequest, err)
+		} else {
+			errs[i] = checkCreate(reqs[i])
+		}
+	}
+	return reqs, errs, nil
+}
+
+// isNDJSON reports whether contentType is one of the media types of NDJSON
+func isNDJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return true
+	}
+	return false
+}
+
+// bodyError returns the error of a bulk request body that could not be read
+func bodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: the body is larger than %d bytes", ErrRequestTooLarge, tooLarge.Limit)
+	}
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("%w: a line is longer than %d bytes", ErrRequestTooLarge, maxBulkLineBytes)
+	}
+	return fmt.Errorf("%w: the body must be a JSON array of links or NDJSON: %v", ErrInvalidRequest, err)
+}
+
+// boolParam returns the boolean in the query parameter name, false if it is not set
+func boolParam(query url.Values, name string) (bool, error) {
+	v := query.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fieldError(name, fmt.Errorf("%w: %s must be true or false", ErrInvalidRequest, name))
+	}
+	return b, nil
+}
+
+// timeParam returns the RFC 3339 time in the query parameter name, or nil if it is not set
+func timeParam(query url.Values, name string) (*time.Time, error) {
+	v := query.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fieldError(name, fmt.Errorf("%w: %s must be an RFC 3339 time", ErrInvalidRequest, name))
+	}
+	return &t, nil
+}
+
+// lookupLink reads the link named by the request path on the domain selected by the domain query
+// parameter. If there is none it writes the error response and reports false.
+func lookupLink(w http.ResponseWriter, r *http.Request, svc *Service) (ShortDomain, *URLSchema, bool) {
+	domain, err := svc.resolveDomain(r.URL.Query().Get("domain"))
+	if err != nil {
+		writeError(w, r, fieldError("domain", err))
+		return ShortDomain{}, nil, false
+	}
+
+	link, err := svc.repo.ReadURLBySlug(r.Context(), domain.key, r.PathValue("slug"))
+	if err != nil {
+		writeError(w, r, err)
+		return ShortDomain{}, nil, false
+	}
+	if link == nil {
+		writeError(w, r, ErrNotFound)
+		return ShortDomain{}, nil, false
+	}
+	return domain, link, true
+}
+
+// decodeJSON decodes the request body into v, rejecting unknown fields so misspelled ones are not
+// silently ignored
+func decodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: error decoding request body: %v", ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+// writeJSON writes v as the JSON body of a response with status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
@@ -0,0 +1,411 @@
+package urlshortener
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveAPI sends a request with an optional JSON body through the full router
+func serveAPI(t *testing.T, handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, target, nil)
+	} else {
+		req = httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func decodeLink(t *testing.T, rr *httptest.ResponseRecorder) Link {
+	t.Helper()
+	var link Link
+	if err := json.NewDecoder(rr.Body).Decode(&link); err != nil {
+		t.Fatalf("an error '%s' was not expected when decoding the response", err)
+	}
+	return link
+}
+
+func TestLinksAPI(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{Domains: []string{"https://brand.example/"}})
+	handler := URLHandler(svc, "../templates/")
+
+	// Create
+	rr := serveAPI(t, handler, "POST", "/api/v1/links", `{"url": "http://example.com/page", "slug": "page"}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Equal(t, "/api/v1/links/page", rr.Header().Get("Location"))
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	created := decodeLink(t, rr)
+	assert.Equal(t, "page", created.Slug)
+	assert.Equal(t, "http://localhost:8080/page", created.ShortURL)
+	assert.Equal(t, "http://example.com/page", created.URL)
+	assert.Equal(t, "localhost:8080", created.Domain)
+
+	// Read
+	rr = serveAPI(t, handler, "GET", "/api/v1/links/page", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, created, decodeLink(t, rr))
+
+	// Update the destination only, then the window only
+	rr = serveAPI(t, handler, "PATCH", "/api/v1/links/page", `{"url": "http://example.com/moved"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	patched := decodeLink(t, rr)
+	assert.Equal(t, "http://example.com/moved", patched.URL)
+	assert.Nil(t, patched.Window)
+
+	notBefore := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	rr = serveAPI(t, handler, "PATCH", "/api/v1/links/page", `{"window": {"not_before": "`+notBefore.Format(time.RFC3339)+`"}}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	patched = decodeLink(t, rr)
+	assert.Empty(t, patched.URL, "a link that is not live yet should not reveal its destination")
+	require.NotNil(t, patched.Window)
+	assert.True(t, notBefore.Equal(*patched.Window.NotBefore))
+
+	// Tags are replaced as a whole, an empty list removes them
+	rr = serveAPI(t, handler, "PATCH", "/api/v1/links/page", `{"tags": ["News", "docs"]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	patched = decodeLink(t, rr)
+	assert.Equal(t, []string{"docs", "news"}, patched.Tags)
+	require.NotNil(t, patched.Window, "a tags update should keep the window")
+
+	rr = serveAPI(t, handler, "GET", "/api/v1/links/page", "")
+	assert.Equal(t, patched, decodeLink(t, rr))
+
+	rr = serveAPI(t, handler, "PATCH", "/api/v1/links/page", `{"tags": []}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	patched = decodeLink(t, rr)
+	assert.Empty(t, patched.Tags)
+
+	// Delete
+	rr = serveAPI(t, handler, "DELETE", "/api/v1/links/page", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = serveAPI(t, handler, "GET", "/api/v1/links/page", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Branded links are selected with the domain parameter
+	rr = serveAPI(t, handler, "POST", "/api/v1/links", `{"url": "http://example.com/brand", "domain": "brand.example"}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	branded := decodeLink(t, rr)
+	assert.Equal(t, "brand.example", branded.Domain)
+	assert.Equal(t, "/api/v1/links/"+branded.Slug+"?domain=brand.example", rr.Header().Get("Location"))
+
+	rr = serveAPI(t, handler, "GET", "/api/v1/links/"+branded.Slug, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code, "a branded link should not be found on the primary domain")
+	rr = serveAPI(t, handler, "GET", "/api/v1/links/"+branded.Slug+"?domain=brand.example", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestLinksAPIHidesDestinations(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{})
+	handler := URLHandler(svc, "../templates/")
+
+	notBefore := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for _, body := range []string{
+		`{"url": "http://example.com/public", "slug": "public"}`,
+		`{"url": "http://example.com/limited", "slug": "limited", "max_clicks": 5}`,
+		`{"url": "http://example.com/protected", "slug": "protected", "password": "s3cret"}`,
+		`{"url": "http://example.com/pending", "slug": "pending", "window": {"not_before": "` + notBefore + `"}}`,
+	} {
+		rr := serveAPI(t, handler, "POST", "/api/v1/links", body)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Only the public link shows where it goes, the others would give away a click, the password
+	// or the launch
+	rr := serveAPI(t, handler, "GET", "/api/v1/links/public", "")
+	assert.Equal(t, "http://example.com/public", decodeLink(t, rr).URL)
+
+	rr = serveAPI(t, handler, "GET", "/api/v1/links/limited", "")
+	limited := decodeLink(t, rr)
+	assert.Empty(t, limited.URL)
+	require.NotNil(t, limited.ClicksLeft)
+	assert.Equal(t, int64(5), *limited.ClicksLeft)
+
+	rr = serveAPI(t, handler, "GET", "/api/v1/links?sort=created&order=asc", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var list LinkList
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&list))
+	require.Len(t, list.Links, 4)
+	for _, link := range list.Links {
+		if link.Slug == "public" {
+			assert.NotEmpty(t, link.URL)
+		} else {
+			assert.Empty(t, link.URL, link.Slug)
+		}
+	}
+	assert.NotContains(t, rr.Body.String(), "example.com/protected")
+}
+
+func TestLinksAPIErrors(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{})
+	handler := URLHandler(svc, "../templates/")
+
+	rr := serveAPI(t, handler, "POST", "/api/v1/links", `{"url": "http://example.com", "slug": "taken"}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{"Create invalid URL", "POST", "/api/v1/links", `{"url": "ftp://example.com"}`, http.StatusBadRequest, "invalid_url", "url"},
+		{"Create blocked URL", "POST", "/api/v1/links", `{"url": "http://10.0.0.1/admin"}`, http.StatusBadRequest, "destination_blocked", "url"},
+		{"Create unknown field", "POST", "/api/v1/links", `{"url": "http://example.com/a", "ttl_hours": 3}`, http.StatusBadRequest, "invalid_request", ""},
+		{"Create with new_url", "POST", "/api/v1/links", `{"url": "http://example.com/a", "new_url": "http://example.com/b"}`, http.StatusBadRequest, "invalid_request", "new_url"},
+		{"Create unknown domain", "POST", "/api/v1/links", `{"url": "http://example.com/a", "domain": "unknown.example"}`, http.StatusBadRequest, "unknown_domain", "domain"},
+		{"Create invalid slug", "POST", "/api/v1/links", `{"url": "http://example.com/a", "slug": "a/b"}`, http.StatusBadRequest, "invalid_slug", "slug"},
+		{"Create reserved slug", "POST", "/api/v1/links", `{"url": "http://example.com/a", "slug": "api"}`, http.StatusConflict, "slug_reserved", "slug"},
+		{"Create taken slug", "POST", "/api/v1/links", `{"url": "http://example.com/a", "slug": "taken"}`, http.StatusConflict, "slug_taken", "slug"},
+		{"Create duplicate", "POST", "/api/v1/links", `{"url": "http://example.com"}`, http.StatusConflict, "duplicate_url", "url"},
+		{"Create invalid TTL", "POST", "/api/v1/links", `{"url": "http://example.com/a", "ttl": "1 day"}`, http.StatusBadRequest, "invalid_expiry", "ttl"},
+		{"Create invalid max clicks", "POST", "/api/v1/links", `{"url": "http://example.com/a", "max_clicks": 0}`, http.StatusBadRequest, "invalid_expiry", "max_clicks"},
+		{"Create invalid redirect status", "POST", "/api/v1/links", `{"url": "http://example.com/a", "redirect_status": 303}`, http.StatusBadRequest, "invalid_redirect_status", "redirect_status"},
+		{"Create without body", "POST", "/api/v1/links", "", http.StatusBadRequest, "invalid_request", ""},
+		{"Create invalid tag", "POST", "/api/v1/links", `{"url": "http://example.com/a", "tags": ["two words"]}`, http.StatusBadRequest, "invalid_tag", "tags"},
+		{"List invalid sort", "GET", "/api/v1/links?sort=slug", "", http.StatusBadRequest, "invalid_request", "sort"},
+		{"List invalid order", "GET", "/api/v1/links?order=up", "", http.StatusBadRequest, "invalid_request", "order"},
+		{"List invalid limit", "GET", "/api/v1/links?limit=1000", "", http.StatusBadRequest, "invalid_request", "limit"},
+		{"List invalid cursor", "GET", "/api/v1/links?cursor=abc", "", http.StatusBadRequest, "invalid_request", "cursor"},
+		{"List invalid date", "GET", "/api/v1/links?created_since=yesterday", "", http.StatusBadRequest, "invalid_request", "created_since"},
+		{"List unknown domain", "GET", "/api/v1/links?domain=unknown.example", "", http.StatusBadRequest, "unknown_domain", "domain"},
+		{"Patch invalid tag", "PATCH", "/api/v1/links/taken", `{"tags": ["-"]}`, http.StatusBadRequest, "invalid_tag", "tags"},
+		{"Read missing", "GET", "/api/v1/links/missing", "", http.StatusNotFound, "not_found", ""},
+		{"Read unknown domain", "GET", "/api/v1/links/taken?domain=unknown.example", "", http.StatusBadRequest, "unknown_domain", "domain"},
+		{"Patch missing", "PATCH", "/api/v1/links/missing", `{"url": "http://example.com/b"}`, http.StatusNotFound, "not_found", ""},
+		{"Patch invalid URL", "PATCH", "/api/v1/links/taken", `{"url": "not a url"}`, http.StatusBadRequest, "invalid_url", "url"},
+		{"Patch invalid window", "PATCH", "/api/v1/links/taken", `{"window": {"not_after": "2000-01-01T00:00:00Z"}}`, http.StatusBadRequest, "invalid_window", "window"},
+		{"Patch unsupported field", "PATCH", "/api/v1/links/taken", `{"slug": "other"}`, http.StatusBadRequest, "invalid_request", ""},
+		{"Delete missing", "DELETE", "/api/v1/links/missing", "", http.StatusNotFound, "not_found", ""},
+		{"Stats invalid bucket", "GET", "/api/v1/links/taken/stats?bucket=week", "", http.StatusBadRequest, "invalid_request", "bucket"},
+		{"Legacy missing", "GET", "/api", `{"url": "http://example.com/missing"}`, http.StatusNotFound, "not_found", ""},
+		{"Legacy invalid new URL", "PUT", "/api", `{"url": "http://example.com", "new_url": "not a url"}`, http.StatusBadRequest, "invalid_url", "new_url"},
+		{"Legacy method", "PATCH", "/api", `{"url": "http://example.com"}`, http.StatusMethodNotAllowed, "method_not_allowed", ""},
+		{"Unknown endpoint", "GET", "/api/v1/nothing", "", http.StatusNotFound, "not_found", ""},
+		{"Unknown link endpoint", "GET", "/api/v1/links/taken/clicks", "", http.StatusNotFound, "not_found", ""},
+		{"Wrong method", "PUT", "/api/v1/links/taken", `{"url": "http://example.com/b"}`, http.StatusMethodNotAllowed, "method_not_allowed", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAPI(t, handler, tt.method, tt.target, tt.body)
+			assert.Equal(t, tt.status, rr.Code, rr.Body.String())
+
+			var response ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("an error '%s' was not expected when decoding the error", err)
+			}
+			assert.Equal(t, tt.code, response.Error.Code)
+			assert.NotEmpty(t, response.Error.Message)
+			assert.Equal(t, rr.Header().Get("X-Request-ID"), response.Error.RequestID)
+			if tt.field == "" {
+				assert.Empty(t, response.Error.Details)
+			} else if assert.Len(t, response.Error.Details, 1) {
+				assert.Equal(t, tt.field, response.Error.Details[0].Field)
+			}
+		})
+	}
+
+	// A rejected update leaves the link as it was
+	rr = serveAPI(t, handler, "GET", "/api/v1/links/taken", "")
+	assert.Equal(t, "http://example.com/", decodeLink(t, rr).URL)
+
+	// Wrong methods are told which ones the path serves
+	rr = serveAPI(t, handler, "PUT", "/api/v1/links/taken", "")
+	assert.Equal(t, "GET, HEAD, PATCH, DELETE", rr.Header().Get("Allow"))
+	rr = serveAPI(t, handler, "DELETE", "/api/v1/links", "")
+	assert.Equal(t, "GET, HEAD, POST", rr.Header().Get("Allow"))
+}
+
+func TestLegacyAPI(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{})
+	handler := URLHandler(svc, "../templates/")
+
+	// The original endpoint keeps working, decoding the body once, but points to its successor
+	rr := serveAPI(t, handler, "POST", "/api", `{"url": "http://example.com"}`)
+	assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Equal(t, "true", rr.Header().Get("Deprecation"))
+	assert.Equal(t, `</api/v1/links>; rel="successor-version"`, rr.Header().Get("Link"))
+
+	rr = serveAPI(t, handler, "GET", "/api", `{"url": "http://example.com"}`)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var link URLSchema
+	if err := json.NewDecoder(rr.Body).Decode(&link); err != nil {
+		t.Fatalf("an error '%s' was not expected when decoding the response", err)
+	}
+	assert.Equal(t, "http://example.com/", link.LongUrl)
+
+	rr = serveAPI(t, handler, "PUT", "/api", `{"url": "http://example.com", "new_url": "http://example.com/new"}`)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = serveAPI(t, handler, "DELETE", "/api", `{"url": "http://example.com/new"}`)
+	assert.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+}
+
+func TestLinkStatsAPI(t *testing.T) {
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{})
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com"})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when shortening the URL", err)
+	}
+
+	now := time.Now().UTC()
+	if err := repo.RecordClicks(context.Background(), []ClickEvent{
+		{URLID: url.ID, ClickedAt: now.Add(-72 * time.Hour)},
+		{URLID: url.ID, ClickedAt: now},
+	}); err != nil {
+		t.Fatalf("an error '%s' was not expected when recording clicks", err)
+	}
+
+	handler := URLHandler(svc, "../templates/")
+	stats := "/api/v1/links/" + url.Slug + "/stats"
+
+	// Hour buckets cover the last two days by default
+	rr := serveAPI(t, handler, "GET", stats+"?bucket=hour", "")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var response LinkStatsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("an error '%s' was not expected when decoding the response", err)
+	}
+	assert.Equal(t, url.Slug, response.Slug)
+	assert.Equal(t, url.ShortUrl, response.ShortURL)
+	assert.Equal(t, "hour", response.Bucket)
+	assert.Equal(t, int64(2), response.Total)
+	assert.Len(t, response.Buckets, 49)
+	assert.Equal(t, int64(1), response.Buckets[len(response.Buckets)-1].Clicks)
+
+	tests := []struct {
+		name   string
+		target string
+		code   int
+	}{
+		{"Day buckets", stats + "?since=" + now.Add(-96*time.Hour).Format(time.RFC3339), http.StatusOK},
+		{"Unknown slug", "/api/v1/links/missing/stats", http.StatusNotFound},
+		{"Unknown domain", stats + "?domain=unknown.example", http.StatusBadRequest},
+		{"Unknown bucket", stats + "?bucket=week", http.StatusBadRequest},
+		{"Malformed since", stats + "?since=yesterday", http.StatusBadRequest},
+		{"Future since", stats + "?since=" + now.Add(48*time.Hour).Format(time.RFC3339), http.StatusBadRequest},
+		{"Too many buckets", stats + "?bucket=hour&since=2000-01-01T00:00:00Z", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAPI(t, handler, "GET", tt.target, "")
+			assert.Equal(t, tt.code, rr.Code, rr.Body.String())
+		})
+	}
+}
+
+func TestUpdateLink(t *testing.T) {
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{})
+	link, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com"})
+	require.NoError(t, err)
+
+	// An invalid window is reported before the destination is changed
+	newURL := "http://example.com/new"
+	past := time.Now().Add(-time.Hour)
+	err = svc.UpdateLink(context.Background(), svc.Domains()[0], link, LinkPatch{URL: &newURL, Window: &Window{NotAfter: &past}})
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+
+	stored, err := repo.ReadURLBySlug(context.Background(), "", link.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/", stored.LongUrl)
+
+	// Both are updated together, the window on the new destination
+	future := time.Now().Add(time.Hour)
+	err = svc.UpdateLink(context.Background(), svc.Domains()[0], link, LinkPatch{URL: &newURL, Window: &Window{NotAfter: &future}})
+	require.NoError(t, err)
+	assert.Equal(t, newURL, link.LongUrl)
+
+	stored, err = repo.ReadURLBySlug(context.Background(), "", link.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, newURL, stored.LongUrl)
+	require.NotNil(t, stored.NotAfter)
+	assert.WithinDuration(t, future, *stored.NotAfter, time.Second)
+
+	// The destination is stored canonical, next to the form it was submitted in
+	submitted := "HTTP://Example.com/other"
+	err = svc.UpdateLink(context.Background(), svc.Domains()[0], link, LinkPatch{URL: &submitted})
+	require.NoError(t, err)
+
+	stored, err = repo.ReadURLBySlug(context.Background(), "", link.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/other", stored.LongUrl)
+	assert.Equal(t, submitted, stored.OriginalUrl)
+	assert.Equal(t, submitted, link.OriginalUrl)
+}
+
+func TestListLinksAPI(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{Domains: []string{"https://brand.example/"}})
+	handler := URLHandler(svc, "../templates/")
+
+	for _, body := range []string{
+		`{"url": "https://docs.example.net/guide", "slug": "guide", "tags": ["docs"]}`,
+		`{"url": "https://example.org/about", "slug": "about"}`,
+		`{"url": "https://docs.example.net/news", "slug": "news", "tags": ["docs", "news"]}`,
+		`{"url": "https://docs.example.net/brand", "slug": "brand", "domain": "brand.example"}`,
+	} {
+		rr := serveAPI(t, handler, "POST", "/api/v1/links", body)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	list := func(target string) LinkList {
+		t.Helper()
+		rr := serveAPI(t, handler, "GET", target, "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var list LinkList
+		if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+			t.Fatalf("an error '%s' was not expected when decoding the response", err)
+		}
+		return list
+	}
+	slugs := func(list LinkList) []string {
+		var slugs []string
+		for _, link := range list.Links {
+			slugs = append(slugs, link.Slug)
+		}
+		return slugs
+	}
+
+	// Pages follow each other by cursor until the last one
+	page := list("/api/v1/links?limit=2&order=asc")
+	assert.Equal(t, []string{"guide", "about"}, slugs(page))
+	require.NotEmpty(t, page.NextCursor)
+	page = list("/api/v1/links?limit=2&order=asc&cursor=" + page.NextCursor)
+	assert.Equal(t, []string{"news"}, slugs(page))
+	assert.Empty(t, page.NextCursor)
+
+	// A cursor is only valid for the order it was made for
+	page = list("/api/v1/links?limit=1")
+	rr := serveAPI(t, handler, "GET", "/api/v1/links?limit=1&order=asc&cursor="+page.NextCursor, "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Filters combine
+	assert.Equal(t, []string{"news", "guide"}, slugs(list("/api/v1/links?host=docs.example.net")))
+	assert.Equal(t, []string{"news"}, slugs(list("/api/v1/links?host=docs.example.net&tag=NEWS")))
+	assert.Equal(t, []string{"about"}, slugs(list("/api/v1/links?q=ABOUT")))
+	assert.Equal(t, []string{"brand"}, slugs(list("/api/v1/links?domain=brand.example")))
+	assert.Empty(t, list("/api/v1/links?created_before="+url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339))).Links)
+
+	page = list("/api/v1/links?tag=news")
+	require.Len(t, page.Links, 1)
+	assert.Equal(t, []string{"docs", "news"}, page.Links[0].Tags)
+}
@@ -0,0 +1,179 @@
+package urlshortener
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+const (
+	// DefaultBulkChunkSize is how many links of a bulk request are stored per transaction when the request does not say
+	DefaultBulkChunkSize = 100
+	// maxBulkChunkSize bounds the links stored in one transaction
+	maxBulkChunkSize = 1000
+	// maxBulkItems bounds the links of one bulk request
+	maxBulkItems = 10000
+	// maxBulkBytes bounds the body of a bulk request, which is read before any link is stored
+	maxBulkBytes = 32 << 20
+	// maxBulkLineBytes bounds one line of an NDJSON bulk request
+	maxBulkLineBytes = 1 << 20
+)
+
+// ErrBatchAborted is reported for the valid links of an atomic bulk request in which another link failed
+var ErrBatchAborted = errors.New("not created because another link of the batch failed")
+
+// bulkBatch is a struct that holds the links of a bulk request while they are stored, by request index
+type bulkBatch struct {
+	reqs    []URLRequest
+	links   []*URLSchema
+	domains []ShortDomain
+	errs    []error
+	// lengths is the length of the next slug generated for each link
+	lengths []int
+}
+
+// ShortenAll shortens every request like Shorten and returns the link or the error of each.
+// Requests are validated first, then stored in transactions of chunkSize links, so one bad
+// request does not fail the others. If atomic is set, the links are stored in a single
+// transaction and none is stored when any request fails.
+func (s *Service) ShortenAll(ctx context.Context, reqs []URLRequest, atomic bool, chunkSize int) ([]*URLSchema, []error) {
+	b := &bulkBatch{
+		reqs:    reqs,
+		links:   make([]*URLSchema, len(reqs)),
+		domains: make([]ShortDomain, len(reqs)),
+		errs:    make([]error, len(reqs)),
+		lengths: make([]int, len(reqs)),
+	}
+
+	var valid []int
+	for i, req := range reqs {
+		b.links[i], b.domains[i], b.errs[i] = s.prepareLink(ctx, req)
+		b.lengths[i] = s.config.Slug.Length
+		if b.errs[i] == nil {
+			valid = append(valid, i)
+		}
+	}
+
+	switch {
+	case atomic && len(valid) < len(reqs):
+		b.abort(valid)
+	case atomic:
+		s.storeAtomic(ctx, b, valid)
+	default:
+		for start := 0; start < len(valid); start += chunkSize {
+			s.storeChunk(ctx, b, valid[start:min(start+chunkSize, len(valid))])
+		}
+	}
+
+	var created int
+	for i, err := range b.errs {
+		if err != nil {
+			b.links[i] = nil
+		} else {
+			created++
+		}
+	}
+	log.Printf("Bulk created %d of %d links", created, len(reqs))
+	return b.links, b.errs
+}
+
+// storeChunk stores the links at the indexes of chunk in one transaction. Links whose generated
+// slug is taken are retried with fresh slugs, like in Shorten, while the others stay stored.
+func (s *Service) storeChunk(ctx context.Context, b *bulkBatch, chunk []int) {
+	todo := chunk
+	for attempt := 1; len(todo) > 0; attempt++ {
+		todo = s.assignSlugs(ctx, b, todo)
+		results, err := s.repo.CreateURLs(ctx, b.urls(todo), false)
+		if err != nil {
+			b.fail(todo, err)
+			return
+		}
+		todo = s.collisions(b, todo, results, attempt)
+	}
+}
+
+// storeAtomic stores the links at the indexes of valid in one transaction, or none of them. When
+// generated slugs are taken the transaction is retried with fresh slugs for them.
+func (s *Service) storeAtomic(ctx context.Context, b *bulkBatch, valid []int) {
+	retry := valid
+	for attempt := 1; len(retry) > 0; attempt++ {
+		if len(s.assignSlugs(ctx, b, retry)) < len(retry) {
+			b.abort(b.pending(valid))
+			return
+		}
+
+		results, err := s.repo.CreateURLs(ctx, b.urls(valid), true)
+		if err != nil {
+			b.fail(valid, err)
+			return
+		}
+		retry = s.collisions(b, valid, results, attempt)
+		if len(b.pending(valid)) < len(valid) {
+			b.abort(b.pending(valid))
+			return
+		}
+	}
+}
+
+// assignSlugs generates slugs for the links at the indexes of todo that did not ask for a custom
+// one and returns the indexes that are ready to be stored
+func (s *Service) assignSlugs(ctx context.Context, b *bulkBatch, todo []int) []int {
+	for _, i := range todo {
+		if b.reqs[i].Slug == "" {
+			b.errs[i] = s.assignSlug(ctx, b.links[i], b.domains[i], b.lengths[i])
+		}
+	}
+	return b.pending(todo)
+}
+
+// collisions records the results of storing the links at the indexes of todo and returns the
+// indexes of the links whose generated slug was taken and that may be retried
+func (s *Service) collisions(b *bulkBatch, todo []int, results []error, attempt int) []int {
+	var retry []int
+	for k, i := range todo {
+		err := results[k]
+		if err == nil {
+			continue
+		}
+		if b.reqs[i].Slug == "" && errors.Is(err, ErrSlugTaken) {
+			if b.lengths[i], err = s.slugCollision(b.links[i], attempt, b.lengths[i]); err == nil {
+				retry = append(retry, i)
+				continue
+			}
+		}
+		b.errs[i] = createError(err)
+	}
+	return retry
+}
+
+// urls returns the links at the indexes of todo
+func (b *bulkBatch) urls(todo []int) []*URLSchema {
+	urls := make([]*URLSchema, len(todo))
+	for k, i := range todo {
+		urls[k] = b.links[i]
+	}
+	return urls
+}
+
+// pending returns the indexes of todo that have not failed
+func (b *bulkBatch) pending(todo []int) []int {
+	var ok []int
+	for _, i := range todo {
+		if b.errs[i] == nil {
+			ok = append(ok, i)
+		}
+	}
+	return ok
+}
+
+// fail reports err for the links at the indexes of todo
+func (b *bulkBatch) fail(todo []int, err error) {
+	for _, i := range todo {
+		b.errs[i] = err
+	}
+}
+
+// abort reports the links at the indexes of valid as not created, because another link of their batch failed
+func (b *bulkBatch) abort(valid []int) {
+	b.fail(valid, ErrBatchAborted)
+}
@@ -0,0 +1,208 @@
+package urlshortener
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShortenAll(t *testing.T) {
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{})
+	ctx := context.Background()
+
+	_, err := svc.Shorten(ctx, URLRequest{URL: "http://example.com/stored"})
+	require.NoError(t, err)
+
+	// Invalid and conflicting links fail on their own, across chunks of two
+	links, errs := svc.ShortenAll(ctx, []URLRequest{
+		{URL: "http://example.com/a", Slug: "first", Tags: []string{"Docs"}},
+		{URL: "not a url"},
+		{URL: "http://example.com/stored"},
+		{URL: "http://example.com/b"},
+		{URL: "http://example.com/c", Slug: "first"},
+	}, false, 2)
+	require.Len(t, links, 5)
+	require.Len(t, errs, 5)
+
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], ErrInvalidURL)
+	assert.ErrorIs(t, errs[2], ErrDuplicateURL)
+	assert.NoError(t, errs[3])
+	assert.ErrorIs(t, errs[4], ErrSlugTaken)
+
+	require.NotNil(t, links[0])
+	assert.Equal(t, "first", links[0].Slug)
+	assert.Equal(t, []string{"docs"}, links[0].Tags)
+	require.NotNil(t, links[3])
+	assert.NotEmpty(t, links[3].Slug, "a link without a custom slug should get a generated one")
+	assert.Nil(t, links[1], "a failed link should not be returned")
+
+	got, err := repo.ReadURLBySlug(ctx, "", links[3].Slug)
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+}
+
+func TestShortenAllAtomic(t *testing.T) {
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{})
+	ctx := context.Background()
+
+	// An invalid link aborts the others before anything is stored
+	links, errs := svc.ShortenAll(ctx, []URLRequest{
+		{URL: "http://example.com/a", Slug: "first"},
+		{URL: "not a url"},
+	}, true, DefaultBulkChunkSize)
+	assert.ErrorIs(t, errs[0], ErrBatchAborted)
+	assert.ErrorIs(t, errs[1], ErrInvalidURL)
+	assert.Equal(t, []*URLSchema{nil, nil}, links)
+
+	// So does a conflict found while storing
+	_, err := svc.Shorten(ctx, URLRequest{URL: "http://example.com/stored"})
+	require.NoError(t, err)
+	_, errs = svc.ShortenAll(ctx, []URLRequest{
+		{URL: "http://example.com/a", Slug: "first"},
+		{URL: "http://example.com/stored"},
+	}, true, DefaultBulkChunkSize)
+	assert.ErrorIs(t, errs[0], ErrBatchAborted)
+	assert.ErrorIs(t, errs[1], ErrDuplicateURL)
+
+	got, err := repo.ReadURLBySlug(ctx, "", "first")
+	require.NoError(t, err)
+	assert.Nil(t, got, "an aborted link should not be stored")
+
+	links, errs = svc.ShortenAll(ctx, []URLRequest{
+		{URL: "http://example.com/a", Slug: "first"},
+		{URL: "http://example.com/b"},
+	}, true, DefaultBulkChunkSize)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.NotNil(t, links[0])
+	assert.NotNil(t, links[1])
+}
+
+func TestShortenAllRetriesSlugCollisions(t *testing.T) {
+	// Create a new mock URL repository where the first generated slug is taken
+	repo := new(MockURLRepository)
+	repo.On("CreateURLs", mock.Anything, false).Return([]error{nil, ErrSlugTaken}, nil).Once()
+	repo.On("CreateURLs", mock.Anything, false).Return([]error{nil}, nil).Once()
+
+	svc := newTestService(t, repo, ServiceConfig{})
+	before := slugCollisions()
+
+	links, errs := svc.ShortenAll(context.Background(), []URLRequest{
+		{URL: "http://example.com/a", Slug: "first"},
+		{URL: "http://example.com/b"},
+	}, false, DefaultBulkChunkSize)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.NotNil(t, links[1])
+	assert.Equal(t, before+1, slugCollisions())
+
+	// Only the colliding link is stored again
+	require.Len(t, repo.Calls, 2)
+	assert.Len(t, repo.Calls[1].Arguments.Get(0), 1)
+	assert.Equal(t, "http://example.com/b", repo.Calls[1].Arguments.Get(0).([]*URLSchema)[0].LongUrl)
+
+	repo.AssertExpectations(t)
+}
+
+// serveBulk posts body to the bulk endpoint with the given content type
+func serveBulk(t *testing.T, handler http.Handler, target, contentType, body string) BulkResponse {
+	t.Helper()
+	req := httptest.NewRequest("POST", target, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var response BulkResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("an error '%s' was not expected when decoding the response", err)
+	}
+	return response
+}
+
+func TestBulkCreateAPI(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{Domains: []string{"https://brand.example/"}})
+	handler := URLHandler(svc, "../templates/")
+
+	response := serveBulk(t, handler, "/api/v1/links/bulk", "application/json", `[
+		{"url": "http://example.com/a", "slug": "first"},
+		{"url": "http://example.com/b", "domain": "brand.example"},
+		{"url": "http://example.com/a"},
+		{"url": "http://example.com/c", "new_url": "http://example.com/d"}
+	]`)
+	assert.Equal(t, 2, response.Created)
+	assert.Equal(t, 2, response.Failed)
+	require.Len(t, response.Results, 4)
+
+	first := response.Results[0]
+	assert.Equal(t, 0, first.Index)
+	assert.Equal(t, http.StatusCreated, first.Status)
+	require.NotNil(t, first.Link)
+	assert.Equal(t, "http://localhost:8080/first", first.Link.ShortURL)
+	assert.Nil(t, first.Error)
+
+	require.NotNil(t, response.Results[1].Link)
+	assert.Equal(t, "brand.example", response.Results[1].Link.Domain)
+
+	assert.Equal(t, http.StatusConflict, response.Results[2].Status)
+	require.NotNil(t, response.Results[2].Error)
+	assert.Equal(t, "duplicate_url", response.Results[2].Error.Code)
+	assert.Nil(t, response.Results[2].Link)
+
+	assert.Equal(t, http.StatusBadRequest, response.Results[3].Status)
+	require.NotNil(t, response.Results[3].Error)
+	assert.Equal(t, "invalid_request", response.Results[3].Error.Code)
+
+	// A malformed NDJSON line fails on its own, blank lines are skipped
+	response = serveBulk(t, handler, "/api/v1/links/bulk", "application/x-ndjson",
+		"{\"url\": \"http://example.com/e\"}\n\n{\"url\": \n{\"url\": \"http://example.com/f\"}\n")
+	assert.Equal(t, 2, response.Created)
+	require.Len(t, response.Results, 3)
+	assert.Equal(t, "invalid_request", response.Results[1].Error.Code)
+	assert.Equal(t, 2, response.Results[2].Index)
+
+	// With atomic=true it aborts the others
+	response = serveBulk(t, handler, "/api/v1/links/bulk?atomic=true", "application/x-ndjson",
+		"{\"url\": \"http://example.com/g\"}\n{\"url\": \n")
+	assert.Equal(t, 0, response.Created)
+	assert.Equal(t, "batch_aborted", response.Results[0].Error.Code)
+	assert.Equal(t, http.StatusFailedDependency, response.Results[0].Status)
+}
+
+func TestBulkCreateAPIErrors(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{})
+	handler := URLHandler(svc, "../templates/")
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+		code   string
+	}{
+		{"malformed array", "/api/v1/links/bulk", `{"url": "http://example.com"}`, http.StatusBadRequest, "invalid_request"},
+		{"empty", "/api/v1/links/bulk", `[]`, http.StatusBadRequest, "invalid_request"},
+		{"too many", "/api/v1/links/bulk", "[" + strings.Repeat(`{},`, maxBulkItems) + "{}]", http.StatusRequestEntityTooLarge, "request_too_large"},
+		{"bad atomic", "/api/v1/links/bulk?atomic=maybe", `[{"url": "http://example.com"}]`, http.StatusBadRequest, "invalid_request"},
+		{"bad chunk size", "/api/v1/links/bulk?chunk_size=0", `[{"url": "http://example.com"}]`, http.StatusBadRequest, "invalid_request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAPI(t, handler, "POST", tt.target, tt.body)
+			assert.Equal(t, tt.status, rr.Code, rr.Body.String())
+
+			var response ErrorResponse
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+			assert.Equal(t, tt.code, response.Error.Code)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package urlshortener
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// CanonicalConfig is a struct that represents the optional steps of URL canonicalization
+type CanonicalConfig struct {
+	// StripParams lists query parameters removed from destinations, such as utm_source.
+	// A trailing * matches every parameter with that prefix.
+	StripParams []string `yaml:"strip_params"`
+}
+
+// idnaProfile converts international host names for lookup, but unlike idna.Lookup it accepts
+// ASCII names with underscores, which browsers resolve
+var idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+// defaultPorts are removed from canonical URLs
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// canonicalizeURL returns the canonical form of a destination URL, so that URLs a browser treats
+// as the same address are stored and deduplicated once. The scheme and host are lowercased,
+// international host names are converted to punycode, default ports are dropped, an empty path
+// becomes "/", percent-encoding is normalized and the query parameters in strip are removed.
+func canonicalizeURL(raw string, strip []string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if !u.IsAbs() || u.Opaque != "" {
+		// Leave anything that is not an absolute hierarchical URL to validateURL
+		return raw, nil
+	}
+
+	// url.Parse already lowercases the scheme
+	host, err := canonicalHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	path := normalizePercentEncoding(u.EscapedPath())
+	if path == "" {
+		path = "/"
+	}
+	if u.Path, err = url.PathUnescape(path); err != nil {
+		return "", err
+	}
+	u.RawPath = path
+
+	// An empty query is the same as none
+	u.RawQuery = canonicalQuery(u.RawQuery, strip)
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+// canonicalHost lowercases a host name and converts international names to punycode.
+// IP addresses are returned in their standard form.
+func canonicalHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	for i := 0; i < len(host); i++ {
+		if host[i] >= 0x80 {
+			ascii, err := idnaProfile.ToASCII(host)
+			if err != nil {
+				return "", fmt.Errorf("invalid host %q: %w", host, err)
+			}
+			return ascii, nil
+		}
+	}
+	return strings.ToLower(host), nil
+}
+
+// canonicalQuery normalizes the percent-encoding of a raw query and drops the stripped parameters.
+// The remaining parameters keep their order, which some destinations depend on.
+func canonicalQuery(rawQuery string, strip []string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	var params []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err == nil && stripParam(strip, name) {
+			continue
+		}
+		params = append(params, normalizePercentEncoding(param))
+	}
+	return strings.Join(params, "&")
+}
+
+// stripParam reports whether a query parameter is one of strip, comparing case-insensitively
+func stripParam(strip []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range strip {
+		s = strings.ToLower(s)
+		if prefix, ok := strings.CutSuffix(s, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == s {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePercentEncoding decodes percent-encoded unreserved characters and uppercases the
+// hex digits of every other escape, as described in RFC 3986 section 6.2.2
+func normalizePercentEncoding(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
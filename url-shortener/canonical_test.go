@@ -0,0 +1,56 @@
+package urlshortener
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalizeURL(t *testing.T) {
+	strip := []string{"utm_*", "fbclid"}
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{name: "Canonical URL", url: "http://example.com/", want: "http://example.com/"},
+		{name: "Uppercase scheme and host", url: "HTTP://Example.COM", want: "http://example.com/"},
+		{name: "Default HTTP port", url: "http://example.com:80", want: "http://example.com/"},
+		{name: "Default HTTPS port", url: "https://example.com:443/a", want: "https://example.com/a"},
+		{name: "Other port", url: "https://example.com:8443/a", want: "https://example.com:8443/a"},
+		{name: "Path case is kept", url: "http://example.com/A/b", want: "http://example.com/A/b"},
+		{name: "Unreserved escapes are decoded", url: "http://example.com/%7euser/%41", want: "http://example.com/~user/A"},
+		{name: "Reserved escapes are uppercased", url: "http://example.com/a%2fb?q=%3d", want: "http://example.com/a%2Fb?q=%3D"},
+		{name: "International host", url: "http://Bücher.de/", want: "http://xn--bcher-kva.de/"},
+		{name: "IPv6 host", url: "http://[2001:DB8:0:0::1]:80/", want: "http://[2001:db8::1]/"},
+		{name: "Empty query", url: "http://example.com/?", want: "http://example.com/"},
+		{name: "Tracking parameters are stripped", url: "http://example.com/?b=2&utm_source=x&a=1&FBCLID=y&utm_medium=z", want: "http://example.com/?b=2&a=1"},
+		{name: "Only tracking parameters", url: "http://example.com/?utm_source=x", want: "http://example.com/"},
+		{name: "Fragment is kept", url: "http://example.com/#Top", want: "http://example.com/#Top"},
+		{name: "Relative URL is unchanged", url: "example.com", want: "example.com"},
+		{name: "Opaque URL is unchanged", url: "mailto:Someone@Example.com", want: "mailto:Someone@Example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := canonicalizeURL(tt.url, strip)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			// Canonicalizing again changes nothing
+			again, err := canonicalizeURL(got, strip)
+			assert.NoError(t, err)
+			assert.Equal(t, got, again)
+		})
+	}
+
+	// Parameters are only stripped when configured
+	got, err := canonicalizeURL("http://example.com/?utm_source=x", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/?utm_source=x", got)
+
+	// Host names that cannot be converted are rejected
+	_, err = canonicalizeURL("http://exa\u200fmple.com/", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,213 @@
+package urlshortener
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultClickBuffer is how many clicks may wait to be recorded when no buffer size is configured
+	DefaultClickBuffer = 1024
+	// DefaultClickBatchSize is the most clicks recorded in one insert when no batch size is configured
+	DefaultClickBatchSize = 100
+	// DefaultClickFlushInterval is how long a click may wait for its batch when no interval is configured
+	DefaultClickFlushInterval = time.Second
+)
+
+// maxStatsBuckets bounds the buckets of one stats request, such as a year of days
+const maxStatsBuckets = 1000
+
+// statsBuckets are the bucket sizes stats can be requested in, with the range they cover by default
+var statsBuckets = map[string]struct {
+	size, defaultRange time.Duration
+}{
+	"hour": {time.Hour, 48 * time.Hour},
+	"day":  {24 * time.Hour, 30 * 24 * time.Hour},
+}
+
+// clickFlushTimeout bounds recording the last batch when the recorder stops
+const clickFlushTimeout = 5 * time.Second
+
+// ClickConfig is a struct that represents how clicks are recorded
+type ClickConfig struct {
+	// Buffer is how many clicks may wait to be recorded. Clicks beyond it are dropped, so a slow
+	// database never slows down redirects.
+	Buffer        int           `yaml:"buffer"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// CountryHeader is the header a CDN puts the visitor's country code in, such as CF-IPCountry
+	CountryHeader string `yaml:"country_header"`
+}
+
+// ClickEvent is a struct that represents one redirect of a link
+type ClickEvent struct {
+	ID        uint `gorm:"primary_key"`
+	URLID     uint `gorm:"column:url_id"`
+	ClickedAt time.Time
+	Referrer  string
+	UserAgent string
+	// Country is the ISO 3166 code of the visitor's country, empty if unknown
+	Country string
+	// IP is the visitor's address with the host part zeroed, see anonymizeIP
+	IP string
+}
+
+// ClickStats is a struct that holds the clicks of a link, in total and per time bucket
+type ClickStats struct {
+	Total   int64         `json:"total"`
+	Buckets []ClickBucket `json:"buckets"`
+}
+
+// ClickBucket is a struct that holds the clicks in the time bucket starting at Start
+type ClickBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int64     `json:"clicks"`
+}
+
+// bucketClicks counts click times into buckets of the given size, in UTC and in order
+func bucketClicks(times []time.Time, bucket time.Duration) []ClickBucket {
+	counts := make(map[time.Time]int64)
+	for _, t := range times {
+		counts[t.UTC().Truncate(bucket)]++
+	}
+
+	buckets := make([]ClickBucket, 0, len(counts))
+	for start, n := range counts {
+		buckets = append(buckets, ClickBucket{Start: start, Clicks: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+	return buckets
+}
+
+// anonymizeIP zeroes the host part of an address, keeping the /24 of IPv4 and the /48 of IPv6
+// addresses, which is enough to tell networks apart but not visitors
+func anonymizeIP(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// clientIP returns the address of the client that sent r. Behind a reverse proxy it is read from
+// the configured header, taking the last address, which is the one the proxy added.
+func (s *Service) clientIP(r *http.Request) string {
+	if s.config.ClientIPHeader != "" {
+		if v := r.Header.Get(s.config.ClientIPHeader); v != "" {
+			addrs := strings.Split(v, ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recordClick queues a click on link for recording. It never blocks; when the buffer is full the
+// click is dropped.
+func (s *Service) recordClick(r *http.Request, link *URLSchema) {
+	event := ClickEvent{
+		URLID:     link.ID,
+		ClickedAt: time.Now().UTC(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        anonymizeIP(s.clientIP(r)),
+	}
+	if s.config.Clicks.CountryHeader != "" {
+		country := strings.ToUpper(r.Header.Get(s.config.Clicks.CountryHeader))
+		if len(country) == 2 {
+			event.Country = country
+		}
+	}
+
+	select {
+	case s.clicks <- event:
+	default:
+		metrics.Add("clicks_dropped", 1)
+	}
+}
+
+// RunClickRecorder records queued clicks in batches until ctx is done, then records the clicks
+// still queued. Stop it after the server has finished its last requests, or their clicks are lost.
+func (s *Service) RunClickRecorder(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Clicks.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]ClickEvent, 0, s.config.Clicks.BatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.repo.RecordClicks(ctx, batch); err != nil {
+			metrics.Add("clicks_dropped", int64(len(batch)))
+			log.Printf("Error recording %d clicks: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case event := <-s.clicks:
+			batch = append(batch, event)
+			if len(batch) >= s.config.Clicks.BatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			// ctx is done, so the last clicks get a context of their own
+			flushCtx, cancel := context.WithTimeout(context.Background(), clickFlushTimeout)
+			defer cancel()
+			for {
+				select {
+				case event := <-s.clicks:
+					batch = append(batch, event)
+					if len(batch) >= s.config.Clicks.BatchSize {
+						flush(flushCtx)
+					}
+				default:
+					flush(flushCtx)
+					return
+				}
+			}
+		}
+	}
+}
+
+// LinkStats returns the clicks on the link with slug on domain, with a bucket for every interval
+// since since, including empty ones. It returns nil if there is no such link.
+func (s *Service) LinkStats(ctx context.Context, domain ShortDomain, slug string, since time.Time, bucket time.Duration) (*URLSchema, *ClickStats, error) {
+	link, err := s.repo.ReadURLBySlug(ctx, domain.key, slug)
+	if err != nil || link == nil {
+		return nil, nil, err
+	}
+
+	stats, err := s.repo.ClickStats(ctx, link.ID, since, bucket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Fill in the buckets without clicks, so they can be charted as they are
+	counts := make(map[time.Time]int64, len(stats.Buckets))
+	for _, b := range stats.Buckets {
+		counts[b.Start] = b.Clicks
+	}
+	stats.Buckets = stats.Buckets[:0]
+	for start := since.UTC().Truncate(bucket); !start.After(time.Now()); start = start.Add(bucket) {
+		stats.Buckets = append(stats.Buckets, ClickBucket{Start: start, Clicks: counts[start]})
+	}
+	return link, stats, nil
+}
@@ -0,0 +1,164 @@
+package urlshortener
+
+import (
+	"context"
+	"expvar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"192.0.2.123", "192.0.2.0"},
+		{"::ffff:192.0.2.123", "192.0.2.0"},
+		{"2001:db8:1234:5678::1", "2001:db8:1234::"},
+		{"not an address", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equal(t, tt.want, anonymizeIP(tt.addr))
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/abc", nil)
+	r.RemoteAddr = "198.51.100.7:4321"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 192.0.2.44")
+
+	// The forwarding header is ignored unless a proxy is trusted to set it
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{})
+	assert.Equal(t, "198.51.100.7", svc.clientIP(r))
+
+	// The last address is the one the trusted proxy added, the others may be forged
+	svc = newTestService(t, NewMemoryURLRepository(), ServiceConfig{ClientIPHeader: "X-Forwarded-For"})
+	assert.Equal(t, "192.0.2.44", svc.clientIP(r))
+
+	r.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "198.51.100.7", svc.clientIP(r))
+}
+
+func TestRecordClick(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{
+		Clicks:         ClickConfig{Buffer: 1, CountryHeader: "CF-IPCountry"},
+		ClientIPHeader: "X-Forwarded-For",
+	})
+	link := &URLSchema{Slug: "abc"}
+	link.ID = 7
+
+	r := httptest.NewRequest("GET", "/abc", nil)
+	r.Header.Set("Referer", "https://example.org/post")
+	r.Header.Set("User-Agent", "test-agent")
+	r.Header.Set("CF-IPCountry", "nl")
+	r.Header.Set("X-Forwarded-For", "192.0.2.44")
+	svc.recordClick(r, link)
+
+	event := <-svc.clicks
+	assert.Equal(t, uint(7), event.URLID)
+	assert.Equal(t, "https://example.org/post", event.Referrer)
+	assert.Equal(t, "test-agent", event.UserAgent)
+	assert.Equal(t, "NL", event.Country)
+	assert.Equal(t, "192.0.2.0", event.IP)
+	assert.WithinDuration(t, time.Now(), event.ClickedAt, time.Second)
+
+	// Anything but a two-letter code is not a country
+	r.Header.Set("CF-IPCountry", "unknown")
+	svc.recordClick(r, link)
+	event = <-svc.clicks
+	assert.Empty(t, event.Country)
+
+	// A full buffer drops the click instead of blocking the redirect
+	before := clicksDropped()
+	svc.recordClick(r, link)
+	svc.recordClick(r, link)
+	assert.Equal(t, before+1, clicksDropped())
+	assert.Len(t, svc.clicks, 1)
+}
+
+func TestRunClickRecorder(t *testing.T) {
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{Clicks: ClickConfig{BatchSize: 2, FlushInterval: time.Hour}})
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com"})
+	require.NoError(t, err)
+	link, err := repo.ReadURLBySlug(context.Background(), "", url.Slug)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		svc.RunClickRecorder(ctx)
+		close(done)
+	}()
+
+	total := func() int64 {
+		stats, err := repo.ClickStats(context.Background(), link.ID, time.Time{}, time.Hour)
+		require.NoError(t, err)
+		return stats.Total
+	}
+
+	// A full batch is recorded at once
+	for i := 0; i < 3; i++ {
+		svc.recordClick(httptest.NewRequest("GET", "/"+url.Slug, nil), link)
+	}
+	assert.Eventually(t, func() bool {
+		return total() == 2
+	}, time.Second, time.Millisecond)
+
+	// The rest is recorded when the recorder stops, long before the flush interval
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("click recorder did not stop")
+	}
+	assert.Equal(t, int64(3), total())
+}
+
+func TestLinkStats(t *testing.T) {
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{})
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com"})
+	require.NoError(t, err)
+	link, err := repo.ReadURLBySlug(context.Background(), "", url.Slug)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	require.NoError(t, repo.RecordClicks(context.Background(), []ClickEvent{
+		{URLID: link.ID, ClickedAt: now.Add(-3 * time.Hour)},
+		{URLID: link.ID, ClickedAt: now.Add(-time.Hour)},
+		{URLID: link.ID, ClickedAt: now.Add(-time.Hour)},
+	}))
+
+	// Every bucket since since is returned, with or without clicks
+	got, stats, err := svc.LinkStats(context.Background(), svc.Domains()[0], url.Slug, now.Add(-2*time.Hour), time.Hour)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, int64(3), stats.Total)
+	assert.Equal(t, []ClickBucket{
+		{Start: now.Add(-2 * time.Hour), Clicks: 0},
+		{Start: now.Add(-time.Hour), Clicks: 2},
+		{Start: now, Clicks: 0},
+	}, stats.Buckets)
+
+	got, stats, err = svc.LinkStats(context.Background(), svc.Domains()[0], "missing", now, time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	assert.Nil(t, stats)
+}
+
+// clicksDropped returns the dropped click count published on /debug/vars
+func clicksDropped() int64 {
+	if v, ok := metrics.Get("clicks_dropped").(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
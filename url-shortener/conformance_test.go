@@ -0,0 +1,49 @@
+package urlshortener_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	urlshortener "github.com/kuhlman-labs/url-shortener/url-shortener"
+	"github.com/kuhlman-labs/url-shortener/url-shortener/repotest"
+)
+
+func TestMemoryURLRepositoryConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) urlshortener.URLRepository {
+		return urlshortener.NewMemoryURLRepository()
+	})
+}
+
+func TestSQLiteURLRepositoryConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) urlshortener.URLRepository {
+		repo, err := urlshortener.NewSQLURLRepository(urlshortener.DriverSQLite, t.TempDir()+"/url.db")
+		if err != nil {
+			t.Fatalf("Failed to create repository: %v", err)
+		}
+		t.Cleanup(func() { repo.Close() })
+		if err := repo.MigrateUp(context.Background()); err != nil {
+			t.Fatalf("Failed to migrate database: %v", err)
+		}
+		return repo
+	})
+}
+
+func TestPostgresURLRepositoryConformance(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN is not set")
+	}
+
+	repotest.Run(t, func(t *testing.T) urlshortener.URLRepository {
+		repo, err := urlshortener.NewSQLURLRepository(urlshortener.DriverPostgres, dsn)
+		if err != nil {
+			t.Fatalf("Failed to create repository: %v", err)
+		}
+		t.Cleanup(func() { repo.Close() })
+		if err := repo.MigrateUp(context.Background()); err != nil {
+			t.Fatalf("Failed to migrate database: %v", err)
+		}
+		return repo
+	})
+}
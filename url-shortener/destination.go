@@ -0,0 +1,294 @@
+package urlshortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrDestinationBlocked is returned when a destination URL points at an address or host the policy does not allow
+var ErrDestinationBlocked = errors.New("destination is not allowed")
+
+// resolveTimeout bounds the DNS lookup of a destination host
+const resolveTimeout = 2 * time.Second
+
+// blockedRanges are special-purpose ranges that netip.Addr has no predicate for
+var blockedRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, including broadcast
+	netip.MustParsePrefix("::/96"),           // IPv4-compatible
+	netip.MustParsePrefix("100::/64"),        // discard
+	netip.MustParsePrefix("2001::/32"),       // Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4
+}
+
+// nat64Prefix is the well-known NAT64 prefix, whose addresses embed an IPv4 address in the last four bytes
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// Resolver is an interface that represents a DNS resolver. *net.Resolver implements it.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// DestinationConfig is a struct that represents which destinations short URLs may point to
+type DestinationConfig struct {
+	// Resolve looks up host names and rejects those with an address in a blocked range
+	Resolve bool `yaml:"resolve"`
+	// Allow lists hosts, which include their subdomains, and IP ranges that are always accepted
+	Allow []string `yaml:"allow"`
+	// Deny lists hosts, which include their subdomains, and IP ranges that are always rejected
+	Deny []string `yaml:"deny"`
+	// Resolver replaces the system resolver used when Resolve is set
+	Resolver Resolver `yaml:"-"`
+}
+
+// DestinationPolicy is a struct that decides whether a destination host may be shortened.
+// Private, loopback, link-local and other special-purpose addresses are rejected, whether they
+// are written as IP literals in any notation or, when resolution is enabled, reached through DNS.
+type DestinationPolicy struct {
+	resolver   Resolver
+	allowHosts []string
+	allowIPs   []netip.Prefix
+	denyHosts  []string
+	denyIPs    []netip.Prefix
+}
+
+// NewDestinationPolicy returns the DestinationPolicy described by the config
+func NewDestinationPolicy(config DestinationConfig) (*DestinationPolicy, error) {
+	p := &DestinationPolicy{}
+	if config.Resolve {
+		p.resolver = config.Resolver
+		if p.resolver == nil {
+			p.resolver = net.DefaultResolver
+		}
+	}
+
+	var err error
+	p.allowHosts, p.allowIPs, err = parseHostList(config.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination allow list: %w", err)
+	}
+	p.denyHosts, p.denyIPs, err = parseHostList(config.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination deny list: %w", err)
+	}
+	return p, nil
+}
+
+// Check returns an error wrapping ErrDestinationBlocked if links to host are not allowed.
+// The host is a URL host name without port, as returned by url.URL.Hostname.
+func (p *DestinationPolicy) Check(ctx context.Context, host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrDestinationBlocked)
+	}
+
+	addr, isIP, err := parseIPHost(host)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDestinationBlocked, err)
+	}
+
+	if isIP {
+		if err := p.checkAddr(addr); err != nil {
+			return fmt.Errorf("%w: %v", ErrDestinationBlocked, err)
+		}
+		return nil
+	}
+
+	if matchHost(p.denyHosts, host) {
+		return fmt.Errorf("%w: %s is on the deny list", ErrDestinationBlocked, host)
+	}
+	if matchHost(p.allowHosts, host) {
+		return nil
+	}
+
+	// Names reserved for local networks never reach a public server
+	for _, local := range []string{"localhost", "local", "internal"} {
+		if host == local || strings.HasSuffix(host, "."+local) {
+			return fmt.Errorf("%w: %s is a local host name", ErrDestinationBlocked, host)
+		}
+	}
+
+	if p.resolver == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	addrs, err := p.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("%w: cannot resolve %s: %v", ErrDestinationBlocked, host, err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("%w: %s has no addresses", ErrDestinationBlocked, host)
+	}
+
+	// Every address must be allowed, or the browser could be sent to the blocked one
+	for _, a := range addrs {
+		if err := p.checkAddr(a); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrDestinationBlocked, host, err)
+		}
+	}
+	return nil
+}
+
+// checkAddr applies the IP lists and blocked ranges to one address
+func (p *DestinationPolicy) checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap().WithZone("")
+
+	if matchPrefix(p.denyIPs, addr) {
+		return fmt.Errorf("%s is on the deny list", addr)
+	}
+	if matchPrefix(p.allowIPs, addr) {
+		return nil
+	}
+	if blockedAddr(addr) {
+		return fmt.Errorf("%s is not a public address", addr)
+	}
+	return nil
+}
+
+// blockedAddr reports whether addr is anything but a public unicast address
+func blockedAddr(addr netip.Addr) bool {
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return true
+	}
+	if matchPrefix(blockedRanges, addr) {
+		return true
+	}
+	if nat64Prefix.Contains(addr) {
+		b := addr.As16()
+		return blockedAddr(netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]}))
+	}
+	return false
+}
+
+// parseIPHost reports whether host is an IP address and returns it in canonical form. Besides the
+// usual notations it accepts the legacy IPv4 forms browsers still resolve, such as 2130706433,
+// 0x7f.1 and 0177.0.0.1, and rejects hosts that look numeric but are not valid addresses.
+func parseIPHost(host string) (netip.Addr, bool, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr, true, nil
+	}
+
+	labels := strings.Split(host, ".")
+	if !numericLabel(labels[len(labels)-1]) {
+		return netip.Addr{}, false, nil
+	}
+
+	addr, ok := parseLegacyIPv4(labels)
+	if !ok {
+		return netip.Addr{}, false, fmt.Errorf("invalid IPv4 address %s", host)
+	}
+	return addr, true, nil
+}
+
+// numericLabel reports whether a host label is a number, which makes the host an IPv4 address
+func numericLabel(label string) bool {
+	if label == "" {
+		return false
+	}
+	if rest, ok := strings.CutPrefix(strings.ToLower(label), "0x"); ok {
+		return strings.Trim(rest, "0123456789abcdef") == ""
+	}
+	return strings.Trim(label, "0123456789") == ""
+}
+
+// parseLegacyIPv4 parses one to four decimal, octal (leading 0) or hexadecimal (leading 0x)
+// parts, where the last part fills all remaining bytes, as inet_aton does
+func parseLegacyIPv4(parts []string) (netip.Addr, bool) {
+	if len(parts) > 4 {
+		return netip.Addr{}, false
+	}
+
+	var ip uint64
+	for i, part := range parts {
+		base := 10
+		switch {
+		case len(part) > 2 && (part[:2] == "0x" || part[:2] == "0X"):
+			part, base = part[2:], 16
+		case len(part) > 1 && part[0] == '0':
+			part, base = part[1:], 8
+		}
+
+		n, err := strconv.ParseUint(part, base, 32)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+
+		if i < len(parts)-1 {
+			if n > 0xff {
+				return netip.Addr{}, false
+			}
+			ip |= n << (8 * (3 - i))
+			continue
+		}
+
+		if n >= 1<<(8*(4-i)) {
+			return netip.Addr{}, false
+		}
+		ip |= n
+	}
+
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)}), true
+}
+
+// parseHostList splits allow or deny entries into host names and IP ranges
+func parseHostList(entries []string) ([]string, []netip.Prefix, error) {
+	var hosts []string
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		hosts = append(hosts, entry)
+	}
+	return hosts, prefixes, nil
+}
+
+// matchHost reports whether host is one of hosts or a subdomain of one
+func matchHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPrefix(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
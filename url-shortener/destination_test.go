@@ -0,0 +1,163 @@
+package urlshortener
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeResolver is a Resolver that answers from a map, any other host is not found
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, ok := f[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+
+	var result []netip.Addr
+	for _, a := range addrs {
+		result = append(result, netip.MustParseAddr(a))
+	}
+	return result, nil
+}
+
+func TestDestinationPolicyAddresses(t *testing.T) {
+	policy, err := NewDestinationPolicy(DestinationConfig{})
+	assert.NoError(t, err)
+
+	blocked := []string{
+		"10.0.0.1",
+		"172.16.5.4",
+		"192.168.1.1",
+		"169.254.169.254",
+		"127.0.0.1",
+		"0.0.0.0",
+		"100.64.0.1",
+		"255.255.255.255",
+		"224.0.0.1",
+		// Legacy IPv4 notations for 127.0.0.1
+		"127.1",
+		"2130706433",
+		"0x7f000001",
+		"0177.0.0.1",
+		"0x7f.0.0.1",
+		"0",
+		// IPv6
+		"::1",
+		"::",
+		"::ffff:127.0.0.1",
+		"::ffff:a9fe:a9fe",
+		"fe80::1",
+		"fe80::1%eth0",
+		"fc00::1",
+		"ff02::1",
+		"2001:db8::1",
+		"64:ff9b::a00:1",
+		// Local host names
+		"localhost",
+		"LOCALHOST.",
+		"api.localhost",
+		"printer.local",
+		"metadata.google.internal",
+		// Numeric hosts that are not valid addresses
+		"999.1.1.1",
+		"1.2.3.4.5",
+		"example.123",
+		"",
+	}
+	for _, host := range blocked {
+		err := policy.Check(context.Background(), host)
+		assert.ErrorIs(t, err, ErrDestinationBlocked, "host %q", host)
+	}
+
+	allowed := []string{
+		"example.com",
+		"93.184.216.34",
+		"1558763554",
+		"2606:2800:220:1:248:1893:25c8:1946",
+		"::ffff:93.184.216.34",
+		"64:ff9b::5db8:d822",
+		"localhost.example.com",
+	}
+	for _, host := range allowed {
+		err := policy.Check(context.Background(), host)
+		assert.NoError(t, err, "host %q", host)
+	}
+}
+
+func TestDestinationPolicyResolve(t *testing.T) {
+	resolver := fakeResolver{
+		"example.com":          {"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"},
+		"rebind.example":       {"169.254.169.254"},
+		"mixed.example":        {"93.184.216.34", "10.0.0.1"},
+		"intranet.example.com": {"10.0.0.5"},
+		"vpn.example.org":      {"10.1.2.3"},
+	}
+	policy, err := NewDestinationPolicy(DestinationConfig{
+		Resolve:  true,
+		Resolver: resolver,
+		Allow:    []string{"intranet.example.com", "10.1.0.0/16"},
+		Deny:     []string{"bad.example", "93.184.216.0/24"},
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		host    string
+		wantErr bool
+	}{
+		// Every resolved address must be public
+		{host: "rebind.example", wantErr: true},
+		{host: "mixed.example", wantErr: true},
+		{host: "missing.example", wantErr: true},
+		// Allowed hosts and ranges skip the range checks
+		{host: "intranet.example.com", wantErr: false},
+		{host: "vpn.example.org", wantErr: false},
+		{host: "10.1.200.1", wantErr: false},
+		// Denied hosts include their subdomains, denied ranges apply to resolved addresses
+		{host: "bad.example", wantErr: true},
+		{host: "www.bad.example", wantErr: true},
+		{host: "notbad.example", wantErr: true}, // not found
+		{host: "example.com", wantErr: true},
+		{host: "93.184.216.34", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := policy.Check(context.Background(), tt.host)
+		if tt.wantErr {
+			assert.ErrorIs(t, err, ErrDestinationBlocked, "host %q", tt.host)
+		} else {
+			assert.NoError(t, err, "host %q", tt.host)
+		}
+	}
+}
+
+func TestNewDestinationPolicyInvalidList(t *testing.T) {
+	_, err := NewDestinationPolicy(DestinationConfig{Allow: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+
+	_, err = NewDestinationPolicy(DestinationConfig{Deny: []string{"not-an-ip/8"}})
+	assert.Error(t, err)
+}
+
+func TestParseLegacyIPv4(t *testing.T) {
+	tests := map[string]string{
+		"2130706433":      "127.0.0.1",
+		"127.1":           "127.0.0.1",
+		"127.0.1":         "127.0.0.1",
+		"0x7f.1":          "127.0.0.1",
+		"0177.0.0.01":     "127.0.0.1",
+		"10.0x10203":      "10.1.2.3",
+		"192.168.0x101":   "192.168.1.1",
+		"0xA9.0376.43518": "169.254.169.254",
+	}
+	for host, want := range tests {
+		addr, isIP, err := parseIPHost(host)
+		assert.NoError(t, err, host)
+		assert.True(t, isIP, host)
+		assert.Equal(t, want, addr.String(), host)
+	}
+}
@@ -0,0 +1,181 @@
+package urlshortener
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// ErrNotFound is returned when a request names a link that does not exist
+var ErrNotFound = errors.New("URL not found")
+
+// ErrInvalidRequest is returned when a request cannot be understood, such as a malformed body or query parameter
+var ErrInvalidRequest = errors.New("invalid request")
+
+// ErrRequestTooLarge is returned when a request has more data than the endpoint accepts
+var ErrRequestTooLarge = errors.New("request too large")
+
+// errMethodNotAllowed is returned for requests with a method the endpoint does not serve
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// errNoEndpoint is returned for API requests to a path that no endpoint serves
+var errNoEndpoint = errors.New("no such endpoint")
+
+// FieldError is an error about one field of a request. It wraps one of the Err sentinels, so it
+// matches them with errors.Is, while errors.As finds the field.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError returns err as a FieldError about field, or nil if err is nil
+func fieldError(field string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &FieldError{Field: field, Err: err}
+}
+
+// ErrorResponse is the body of every error response of the API
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// APIError is a struct that describes why an API request failed
+type APIError struct {
+	// Code names the kind of error, such as invalid_url or slug_taken. Codes do not change, so
+	// clients can rely on them where messages may be reworded.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details lists the fields of the request the error is about
+	Details []FieldDetail `json:"details,omitempty"`
+	// RequestID is also sent as the X-Request-ID header and logged with server errors
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// FieldDetail is a struct that describes what is wrong with one field of a request
+type FieldDetail struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// errorKind is a struct that represents how the errors matching err are reported
+type errorKind struct {
+	err    error
+	status int
+	code   string
+	// message replaces the error's own message, which is only shown to clients for their own mistakes
+	message string
+}
+
+// errorKinds maps the errors of the service to responses. Errors wrapping others come first, so
+// the most specific kind is reported.
+var errorKinds = []errorKind{
+	{ErrInvalidRequest, http.StatusBadRequest, "invalid_request", ""},
+	{ErrNotFound, http.StatusNotFound, "not_found", ""},
+	{errNoEndpoint, http.StatusNotFound, "not_found", ""},
+	{errMethodNotAllowed, http.StatusMethodNotAllowed, "method_not_allowed", ""},
+	{ErrRequestTooLarge, http.StatusRequestEntityTooLarge, "request_too_large", ""},
+	{ErrRedirectCycle, http.StatusBadRequest, "redirect_cycle", ""},
+	{ErrSelfLink, http.StatusBadRequest, "self_link", ""},
+	{ErrURLTooLong, http.StatusBadRequest, "url_too_long", ""},
+	{ErrDestinationBlocked, http.StatusBadRequest, "destination_blocked", ""},
+	{ErrInvalidURL, http.StatusBadRequest, "invalid_url", ""},
+	{ErrUnknownDomain, http.StatusBadRequest, "unknown_domain", ""},
+	{ErrInvalidSlug, http.StatusBadRequest, "invalid_slug", ""},
+	{ErrSlugReserved, http.StatusConflict, "slug_reserved", ""},
+	{ErrSlugTaken, http.StatusConflict, "slug_taken", "Short URL is already taken, please choose another"},
+	{ErrDuplicateURL, http.StatusConflict, "duplicate_url", "URL is already shortened"},
+	{ErrInvalidExpiry, http.StatusBadRequest, "invalid_expiry", ""},
+	{ErrInvalidPassword, http.StatusBadRequest, "invalid_password", ""},
+	{ErrInvalidSchedule, http.StatusBadRequest, "invalid_window", ""},
+	{ErrInvalidRedirectStatus, http.StatusBadRequest, "invalid_redirect_status", ""},
+	{ErrInvalidTag, http.StatusBadRequest, "invalid_tag", ""},
+	{ErrBatchAborted, http.StatusFailedDependency, "batch_aborted", ""},
+	{ErrSlugSpaceExhausted, http.StatusServiceUnavailable, "slug_space_exhausted", "No free short URL available, please try again"},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, "timeout", "The request took too long, please try again"},
+}
+
+// classifyError returns the status, code and message err is reported with. Errors the service
+// does not know, such as a failing database, are internal errors whose details are not shown.
+func classifyError(err error) (int, string, string) {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			message := kind.message
+			if message == "" {
+				message = err.Error()
+			}
+			return kind.status, kind.code, message
+		}
+	}
+	return http.StatusInternalServerError, "internal_error", "Internal error, please try again later"
+}
+
+// writeError writes the JSON error response for err
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, apiErr := newAPIError(err)
+	apiErr.RequestID = RequestID(r.Context())
+	if status >= http.StatusInternalServerError {
+		log.Printf("Request %s: %s %s failed: %v", apiErr.RequestID, r.Method, r.URL.Path, err)
+	}
+
+	writeJSON(w, status, ErrorResponse{Error: apiErr})
+}
+
+// newAPIError returns the status err is reported with and its description, naming the field of
+// the request it is about unless it is a server error
+func newAPIError(err error) (int, APIError) {
+	status, code, message := classifyError(err)
+	apiErr := APIError{Code: code, Message: message}
+
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) && status < http.StatusInternalServerError {
+		// Kinds with a message of their own may wrap text that is not for clients, such as a driver error
+		_, _, detail := classifyError(fieldErr.Err)
+		apiErr.Details = []FieldDetail{{Field: fieldErr.Field, Message: detail}}
+	}
+	return status, apiErr
+}
+
+// requestIDHeader carries the ID of a request, from the client or a proxy in front, and back in the response
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern matches the request IDs taken over from the client, anything else is replaced
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestIDKey struct{}
+
+// withRequestID gives every request an ID, taking over a well-formed one sent by the client
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 8)
+			if _, err := rand.Read(b); err != nil {
+				log.Printf("Error generating request ID: %v", err)
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestID returns the ID withRequestID gave the request of ctx, or "" if it has none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,123 @@
+package urlshortener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{"Invalid URL", fmt.Errorf("%w: URL must have http or https scheme", ErrInvalidURL), http.StatusBadRequest, "invalid_url", "invalid URL: URL must have http or https scheme"},
+		{"Blocked destination", fmt.Errorf("%w: %w: localhost is a local host name", ErrInvalidURL, ErrDestinationBlocked), http.StatusBadRequest, "destination_blocked", ""},
+		{"Too long", fmt.Errorf("%w: %w", ErrInvalidURL, ErrURLTooLong), http.StatusBadRequest, "url_too_long", ""},
+		{"Redirect cycle", ErrRedirectCycle, http.StatusBadRequest, "redirect_cycle", ""},
+		{"Self link", ErrSelfLink, http.StatusBadRequest, "self_link", ""},
+		{"Reserved slug", fmt.Errorf("%w: %q", ErrSlugReserved, "api"), http.StatusConflict, "slug_reserved", ""},
+		{"Taken slug", fieldError("slug", ErrSlugTaken), http.StatusConflict, "slug_taken", "Short URL is already taken, please choose another"},
+		{"Duplicate", ErrDuplicateURL, http.StatusConflict, "duplicate_url", "URL is already shortened"},
+		{"Not found", ErrNotFound, http.StatusNotFound, "not_found", "URL not found"},
+		{"Exhausted", fmt.Errorf("%w after 10 attempts", ErrSlugSpaceExhausted), http.StatusServiceUnavailable, "slug_space_exhausted", ""},
+		{"Timeout", fmt.Errorf("reading URL: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "timeout", ""},
+		{"Storage outage", errors.New("database is locked"), http.StatusInternalServerError, "internal_error", "Internal error, please try again later"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, code, message := classifyError(tt.err)
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, tt.code, code)
+			if tt.message != "" {
+				assert.Equal(t, tt.message, message)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/field":
+			writeError(w, r, fieldError("ttl", fmt.Errorf("%w: 1 day is not a duration", ErrInvalidExpiry)))
+		case "/conflict":
+			writeError(w, r, fieldError("url", fmt.Errorf("%w: UNIQUE constraint failed: url_schemas.long_url_hash", ErrDuplicateURL)))
+		default:
+			writeError(w, r, fieldError("url", errors.New("connection refused by 10.0.0.5")))
+		}
+	}))
+
+	// Client mistakes name the field
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/field", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var response ErrorResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, "invalid_expiry", response.Error.Code)
+	assert.Equal(t, "invalid expiry: 1 day is not a duration", response.Error.Message)
+	assert.Equal(t, []FieldDetail{{Field: "ttl", Message: "invalid expiry: 1 day is not a duration"}}, response.Error.Details)
+	assert.Equal(t, rr.Header().Get("X-Request-ID"), response.Error.RequestID)
+	assert.NotEmpty(t, response.Error.RequestID)
+
+	// Server errors do not leak their details
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/internal", nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "10.0.0.5")
+
+	response = ErrorResponse{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, "internal_error", response.Error.Code)
+	assert.Empty(t, response.Error.Details)
+
+	// Neither do errors with a message of their own
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/conflict", nil))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "url_schemas")
+	response = ErrorResponse{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, []FieldDetail{{Field: "url", Message: "URL is already shortened"}}, response.Error.Details)
+}
+
+func TestWithRequestID(t *testing.T) {
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+	}))
+
+	// An ID from the client or a proxy is kept, so requests can be traced across services
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "trace-1234.abc")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "trace-1234.abc", seen)
+	assert.Equal(t, "trace-1234.abc", rr.Header().Get("X-Request-ID"))
+
+	// Anything that could garble the logs is replaced
+	for _, id := range []string{"", "bad id\nforged log line", strings.Repeat("a", 65)} {
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Request-ID", id)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Regexp(t, "^[0-9a-f]{16}$", seen)
+		assert.Equal(t, seen, rr.Header().Get("X-Request-ID"))
+	}
+
+	assert.Empty(t, RequestID(context.Background()))
+}
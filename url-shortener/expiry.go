@@ -0,0 +1,97 @@
+package urlshortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultSweepInterval is how often expired links are removed when no interval is configured
+const DefaultSweepInterval = time.Minute
+
+// ErrInvalidExpiry is returned when a requested expiry is malformed or not in the future,
+// or a click limit is not positive
+var ErrInvalidExpiry = errors.New("invalid expiry")
+
+// ExpiryConfig is a struct that represents how expired links are cleaned up
+type ExpiryConfig struct {
+	// SweepInterval is how often expired links are removed
+	SweepInterval time.Duration `yaml:"sweep_interval"`
+	// Purge deletes expired links for good, freeing their slugs, instead of soft-deleting them
+	Purge bool `yaml:"purge"`
+}
+
+// expiresAt returns when a link created at now with the expiry in req stops redirecting,
+// or nil if it should never expire. Either an absolute time or a TTL can be given, not both.
+func expiresAt(req URLRequest, now time.Time) (*time.Time, error) {
+	if req.ExpiresAt != nil && req.TTL != "" {
+		return nil, fmt.Errorf("%w: expires_at and ttl cannot both be set", ErrInvalidExpiry)
+	}
+
+	var at time.Time
+	switch {
+	case req.ExpiresAt != nil:
+		at = *req.ExpiresAt
+	case req.TTL != "":
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidExpiry, err)
+		}
+		at = now.Add(ttl)
+	default:
+		return nil, nil
+	}
+
+	if !at.After(now) {
+		return nil, fmt.Errorf("%w: %s is not in the future", ErrInvalidExpiry, at.Format(time.RFC3339))
+	}
+	at = at.UTC()
+	return &at, nil
+}
+
+// clicksLeft returns the click limit requested in req, or nil if the link may be followed any number of times
+func clicksLeft(req URLRequest) (*int64, error) {
+	if req.MaxClicks == nil {
+		return nil, nil
+	}
+	if *req.MaxClicks <= 0 {
+		return nil, fmt.Errorf("%w: max_clicks must be at least 1", ErrInvalidExpiry)
+	}
+	left := *req.MaxClicks
+	return &left, nil
+}
+
+// SweepExpired removes the links that have expired or used up their clicks, as configured by ExpiryConfig.Purge,
+// and returns how many were removed
+func (s *Service) SweepExpired(ctx context.Context) (int64, error) {
+	n, err := s.repo.DeleteExpiredURLs(ctx, time.Now(), s.config.Expiry.Purge)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		metrics.Add("links_expired", n)
+		log.Printf("Swept %d expired links", n)
+	}
+	return n, nil
+}
+
+// RunSweeper sweeps expired links right away and then every SweepInterval until ctx is done.
+// A failed sweep is logged and retried at the next interval.
+func (s *Service) RunSweeper(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Expiry.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.SweepExpired(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error sweeping expired links: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
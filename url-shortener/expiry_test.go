@@ -0,0 +1,120 @@
+package urlshortener
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExpiresAt(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		req     URLRequest
+		want    *time.Time
+		wantErr bool
+	}{
+		{name: "No expiry", req: URLRequest{}},
+		{name: "Absolute", req: URLRequest{ExpiresAt: &later}, want: &later},
+		{name: "TTL", req: URLRequest{TTL: "1h"}, want: &later},
+		{name: "Both", req: URLRequest{ExpiresAt: &later, TTL: "1h"}, wantErr: true},
+		{name: "Malformed TTL", req: URLRequest{TTL: "1 day"}, wantErr: true},
+		{name: "Past", req: URLRequest{ExpiresAt: &earlier}, wantErr: true},
+		{name: "Zero TTL", req: URLRequest{TTL: "0s"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expiresAt(tt.req, now)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidExpiry)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestClicksLeft(t *testing.T) {
+	clicks := func(n int64) *int64 { return &n }
+
+	got, err := clicksLeft(URLRequest{})
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	got, err = clicksLeft(URLRequest{MaxClicks: clicks(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, clicks(1), got)
+
+	for _, n := range []int64{0, -1} {
+		_, err = clicksLeft(URLRequest{MaxClicks: clicks(n)})
+		assert.ErrorIs(t, err, ErrInvalidExpiry)
+	}
+}
+
+func TestSweepExpired(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{})
+
+	expiring, err := svc.Shorten(ctx, URLRequest{URL: "http://example.com", TTL: "1h"})
+	assert.NoError(t, err)
+	forever, err := svc.Shorten(ctx, URLRequest{URL: "http://example.org"})
+	assert.NoError(t, err)
+
+	// Nothing has expired yet
+	n, err := svc.SweepExpired(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	// Once the link has expired it is swept
+	past := time.Now().Add(-time.Minute)
+	repo.urls[expiring.ID].ExpiresAt = &past
+
+	n, err = svc.SweepExpired(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	url, err := repo.ReadURLBySlug(ctx, "", expiring.Slug)
+	assert.NoError(t, err)
+	assert.Nil(t, url)
+	url, err = repo.ReadURLBySlug(ctx, "", forever.Slug)
+	assert.NoError(t, err)
+	assert.NotNil(t, url)
+}
+
+func TestRunSweeper(t *testing.T) {
+	var sweeps atomic.Int32
+	repo := new(MockURLRepository)
+	repo.On("DeleteExpiredURLs", mock.AnythingOfType("time.Time"), true).Return(int64(0), nil).Run(func(mock.Arguments) {
+		sweeps.Add(1)
+	})
+	svc := newTestService(t, repo, ServiceConfig{Expiry: ExpiryConfig{SweepInterval: time.Millisecond, Purge: true}})
+
+	// The sweeper runs until its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		svc.RunSweeper(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return sweeps.Load() >= 2
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sweeper did not stop")
+	}
+}
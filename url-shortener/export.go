@@ -0,0 +1,513 @@
+package urlshortener
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// FormatCSV is a header row followed by one link per row
+	FormatCSV = "csv"
+	// FormatNDJSON is one JSON link per line
+	FormatNDJSON = "ndjson"
+)
+
+const (
+	// exportPageSize is how many links are read from the repository at a time while exporting
+	exportPageSize = 500
+	// importChunkSize is how many links are stored per transaction while importing
+	importChunkSize = DefaultBulkChunkSize
+	// maxImportBytes bounds the body of an import request, which is read as it is imported
+	maxImportBytes = 256 << 20
+	// maxSlugLength is the length of the slug column, which also bounds imported slugs
+	maxSlugLength = 100
+)
+
+// ExportOptions is a struct that selects what an export contains
+type ExportOptions struct {
+	// Format is FormatCSV or FormatNDJSON
+	Format string
+	// Timestamps adds when each link was created and last updated
+	Timestamps bool
+	// Deleted adds the soft-deleted links and when they were deleted
+	Deleted bool
+}
+
+// LinkRecord is a struct that represents one link of an export or import, with every stored field
+type LinkRecord struct {
+	// Domain is the host of the branded domain the link was minted under, empty for the primary domain
+	Domain string `json:"domain,omitempty"`
+	Slug   string `json:"slug"`
+	// ShortURL is only exported, imported links get the short URL of their domain in this deployment
+	ShortURL string `json:"short_url,omitempty"`
+	URL      string `json:"url"`
+	// OriginalURL is the destination as it was submitted, URL is its canonical form
+	OriginalURL    string     `json:"original_url,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	ClicksLeft     *int64     `json:"clicks_left,omitempty"`
+	NotBefore      *time.Time `json:"not_before,omitempty"`
+	NotAfter       *time.Time `json:"not_after,omitempty"`
+	RedirectStatus int        `json:"redirect_status,omitempty"`
+	// PasswordHash is the bcrypt hash of the link's password, so protected links keep it when moved
+	PasswordHash string   `json:"password_hash,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	// Clicks is only exported, the clicks themselves are not
+	Clicks    int64      `json:"clicks,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// newLinkRecord returns the record of url, with the timestamps selected by opts
+func newLinkRecord(url URLSchema, opts ExportOptions) LinkRecord {
+	record := LinkRecord{
+		Domain:         url.Domain,
+		Slug:           url.Slug,
+		ShortURL:       url.ShortUrl,
+		URL:            url.LongUrl,
+		OriginalURL:    url.OriginalUrl,
+		ExpiresAt:      url.ExpiresAt,
+		ClicksLeft:     url.ClicksLeft,
+		NotBefore:      url.NotBefore,
+		NotAfter:       url.NotAfter,
+		RedirectStatus: url.RedirectStatus,
+		PasswordHash:   url.PasswordHash,
+		Tags:           url.Tags,
+		Clicks:         url.Clicks,
+	}
+	if opts.Timestamps {
+		record.CreatedAt, record.UpdatedAt = &url.CreatedAt, &url.UpdatedAt
+	}
+	if opts.Deleted {
+		record.DeletedAt = url.DeletedAt
+	}
+	return record
+}
+
+// recordColumn is a struct that represents a column of a CSV export and how it maps to a LinkRecord
+type recordColumn struct {
+	name string
+	get  func(r *LinkRecord) string
+	// set is nil for columns that are only exported
+	set func(r *LinkRecord, v string) error
+}
+
+// recordColumns are the columns of a CSV export, in order. Times are RFC 3339 and tags are
+// separated by spaces; empty cells stand for unset values.
+var recordColumns = []recordColumn{
+	{"domain", func(r *LinkRecord) string { return r.Domain }, func(r *LinkRecord, v string) error { r.Domain = v; return nil }},
+	{"slug", func(r *LinkRecord) string { return r.Slug }, func(r *LinkRecord, v string) error { r.Slug = v; return nil }},
+	{"short_url", func(r *LinkRecord) string { return r.ShortURL }, nil},
+	{"url", func(r *LinkRecord) string { return r.URL }, func(r *LinkRecord, v string) error { r.URL = v; return nil }},
+	{"original_url", func(r *LinkRecord) string { return r.OriginalURL }, func(r *LinkRecord, v string) error { r.OriginalURL = v; return nil }},
+	{"expires_at", func(r *LinkRecord) string { return formatTime(r.ExpiresAt) }, func(r *LinkRecord, v string) (err error) { r.ExpiresAt, err = parseTime(v); return }},
+	{"clicks_left", func(r *LinkRecord) string { return formatInt(r.ClicksLeft) }, func(r *LinkRecord, v string) (err error) { r.ClicksLeft, err = parseInt(v); return }},
+	{"not_before", func(r *LinkRecord) string { return formatTime(r.NotBefore) }, func(r *LinkRecord, v string) (err error) { r.NotBefore, err = parseTime(v); return }},
+	{"not_after", func(r *LinkRecord) string { return formatTime(r.NotAfter) }, func(r *LinkRecord, v string) (err error) { r.NotAfter, err = parseTime(v); return }},
+	{"redirect_status", func(r *LinkRecord) string {
+		if r.RedirectStatus == 0 {
+			return ""
+		}
+		return strconv.Itoa(r.RedirectStatus)
+	}, func(r *LinkRecord, v string) error {
+		status, err := parseInt(v)
+		if status != nil {
+			r.RedirectStatus = int(*status)
+		}
+		return err
+	}},
+	{"password_hash", func(r *LinkRecord) string { return r.PasswordHash }, func(r *LinkRecord, v string) error { r.PasswordHash = v; return nil }},
+	{"tags", func(r *LinkRecord) string { return strings.Join(r.Tags, " ") }, func(r *LinkRecord, v string) error { r.Tags = strings.Fields(v); return nil }},
+	{"clicks", func(r *LinkRecord) string { return strconv.FormatInt(r.Clicks, 10) }, nil},
+	{"created_at", func(r *LinkRecord) string { return formatTime(r.CreatedAt) }, func(r *LinkRecord, v string) (err error) { r.CreatedAt, err = parseTime(v); return }},
+	{"updated_at", func(r *LinkRecord) string { return formatTime(r.UpdatedAt) }, func(r *LinkRecord, v string) (err error) { r.UpdatedAt, err = parseTime(v); return }},
+	{"deleted_at", func(r *LinkRecord) string { return formatTime(r.DeletedAt) }, func(r *LinkRecord, v string) (err error) { r.DeletedAt, err = parseTime(v); return }},
+}
+
+// exportColumns returns the CSV columns of an export with opts
+func exportColumns(opts ExportOptions) []recordColumn {
+	var columns []recordColumn
+	for _, c := range recordColumns {
+		switch {
+		case (c.name == "created_at" || c.name == "updated_at") && !opts.Timestamps:
+		case c.name == "deleted_at" && !opts.Deleted:
+		default:
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return nil, errors.New("must be an RFC 3339 time")
+	}
+	return &t, nil
+}
+
+func formatInt(n *int64) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.FormatInt(*n, 10)
+}
+
+func parseInt(v string) (*int64, error) {
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, errors.New("must be a whole number")
+	}
+	return &n, nil
+}
+
+// checkFormat returns an error unless format is one of the export formats
+func checkFormat(format string) error {
+	if format != FormatCSV && format != FormatNDJSON {
+		return fieldError("format", fmt.Errorf("%w: format must be %s or %s", ErrInvalidRequest, FormatCSV, FormatNDJSON))
+	}
+	return nil
+}
+
+// ExportLinks writes every link of every domain to w in the format of opts, oldest first, and
+// returns how many were written. Expired links are included, soft-deleted ones only if opts asks.
+func (s *Service) ExportLinks(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	if err := checkFormat(opts.Format); err != nil {
+		return 0, err
+	}
+
+	var write func(LinkRecord) error
+	var flush func() error
+	if opts.Format == FormatCSV {
+		columns := exportColumns(opts)
+		writer := csv.NewWriter(w)
+		header := make([]string, len(columns))
+		for i, c := range columns {
+			header[i] = c.name
+		}
+		if err := writer.Write(header); err != nil {
+			return 0, err
+		}
+		write = func(record LinkRecord) error {
+			row := make([]string, len(columns))
+			for i, c := range columns {
+				row[i] = c.get(&record)
+			}
+			return writer.Write(row)
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	} else {
+		encoder := json.NewEncoder(w)
+		write = func(record LinkRecord) error { return encoder.Encode(record) }
+		flush = func() error { return nil }
+	}
+
+	var n int
+	var afterID uint
+	for {
+		urls, err := s.repo.ExportURLs(ctx, afterID, exportPageSize, opts.Deleted)
+		if err != nil {
+			return n, err
+		}
+		for _, url := range urls {
+			if err := write(newLinkRecord(url, opts)); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(urls) < exportPageSize {
+			return n, flush()
+		}
+		afterID = urls[len(urls)-1].ID
+	}
+}
+
+// ImportFailure is a struct that describes a link of an import that was not stored
+type ImportFailure struct {
+	// Row counts the links of the import from 1, without the CSV header
+	Row  int
+	Slug string
+	Err  error
+}
+
+// ImportReport is a struct that holds the outcome of an import
+type ImportReport struct {
+	Imported int
+	Failed   []ImportFailure
+}
+
+// ImportLinks reads links in format from r and stores them with their slugs, timestamps and
+// deleted state, checking each like a new link. Links that are invalid or conflict with stored
+// links are reported and skipped, the others are stored in chunks. Only input that cannot be
+// read at all, or a failing repository, stops the import.
+func (s *Service) ImportLinks(ctx context.Context, r io.Reader, format string) (*ImportReport, error) {
+	if err := checkFormat(format); err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{}
+	var chunk []*URLSchema
+	var rows []ImportFailure
+	store := func() error {
+		results, err := s.repo.CreateURLs(ctx, chunk, false)
+		if err != nil {
+			return err
+		}
+		for k, err := range results {
+			if err != nil {
+				rows[k].Err = createError(err)
+				report.Failed = append(report.Failed, rows[k])
+			} else {
+				report.Imported++
+			}
+		}
+		chunk, rows = chunk[:0], rows[:0]
+		return nil
+	}
+
+	err := readRecords(r, format, func(row int, record LinkRecord, err error) error {
+		if err == nil {
+			var url *URLSchema
+			if url, err = s.importLink(ctx, record); err == nil {
+				chunk = append(chunk, url)
+				rows = append(rows, ImportFailure{Row: row, Slug: record.Slug})
+				if len(chunk) < importChunkSize {
+					return nil
+				}
+				return store()
+			}
+		}
+		report.Failed = append(report.Failed, ImportFailure{Row: row, Slug: record.Slug, Err: err})
+		return nil
+	})
+	if err == nil && len(chunk) > 0 {
+		err = store()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Imported %d links, %d failed", report.Imported, len(report.Failed))
+	return report, nil
+}
+
+// importLink checks record like a new link and returns the link it stores. Expired links and
+// windows that have ended are kept, since they may be exported again.
+func (s *Service) importLink(ctx context.Context, record LinkRecord) (*URLSchema, error) {
+	if err := s.validateImportedSlug(record.Slug); err != nil {
+		return nil, fieldError("slug", err)
+	}
+	// The destination is stored in canonical form, and may not be a loop through one of our domains
+	longURL, err := s.prepareDestination(ctx, record.URL, nil)
+	if errors.Is(err, ErrInvalidURL) {
+		return nil, fieldError("url", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	domain, err := s.resolveDomain(record.Domain)
+	if err != nil {
+		return nil, fieldError("domain", err)
+	}
+	if record.ClicksLeft != nil && *record.ClicksLeft < 0 {
+		return nil, fieldError("clicks_left", fmt.Errorf("%w: clicks_left cannot be negative", ErrInvalidExpiry))
+	}
+	if record.NotBefore != nil && record.NotAfter != nil && !record.NotAfter.After(*record.NotBefore) {
+		return nil, fieldError("not_after", fmt.Errorf("%w: not_after must be after not_before", ErrInvalidSchedule))
+	}
+	if err := validateRedirectStatus(record.RedirectStatus); err != nil {
+		return nil, fieldError("redirect_status", err)
+	}
+	if record.PasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(record.PasswordHash)); err != nil {
+			return nil, fieldError("password_hash", fmt.Errorf("%w: password_hash is not a bcrypt hash", ErrInvalidPassword))
+		}
+	}
+	tags, err := normalizeTags(record.Tags)
+	if err != nil {
+		return nil, fieldError("tags", err)
+	}
+
+	url := &URLSchema{
+		Domain:         domain.key,
+		Slug:           record.Slug,
+		ShortUrl:       domain.ShortURL(record.Slug),
+		LongUrl:        longURL,
+		OriginalUrl:    record.OriginalURL,
+		ExpiresAt:      record.ExpiresAt,
+		ClicksLeft:     record.ClicksLeft,
+		NotBefore:      record.NotBefore,
+		NotAfter:       record.NotAfter,
+		RedirectStatus: record.RedirectStatus,
+		PasswordHash:   record.PasswordHash,
+		Tags:           tags,
+	}
+	if url.OriginalUrl == "" {
+		url.OriginalUrl = record.URL
+	}
+	if record.CreatedAt != nil {
+		url.CreatedAt = *record.CreatedAt
+	}
+	if record.UpdatedAt != nil {
+		url.UpdatedAt = *record.UpdatedAt
+	}
+	url.DeletedAt = record.DeletedAt
+	return url, nil
+}
+
+// validateImportedSlug checks a slug of an import. Generated slugs may be shorter than custom
+// slugs are allowed to be, so only the characters, the column width and the reserved words are checked.
+func (s *Service) validateImportedSlug(slug string) error {
+	if slug == "" || len(slug) > maxSlugLength {
+		return fmt.Errorf("%w: must be between 1 and %d characters", ErrInvalidSlug, maxSlugLength)
+	}
+	if !customSlugPattern.MatchString(slug) {
+		return fmt.Errorf("%w: only letters, digits, '-' and '_' are allowed", ErrInvalidSlug)
+	}
+	return s.checkReserved(slug)
+}
+
+// readRecords calls fn with each link of r in format, numbered from 1, or with the error of a link
+// that cannot be parsed. It returns an error if r cannot be read or has no valid CSV header, or
+// the first error fn returns.
+func readRecords(r io.Reader, format string, fn func(row int, record LinkRecord, err error) error) error {
+	if format == FormatNDJSON {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxBulkLineBytes)
+		row := 0
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			row++
+
+			var record LinkRecord
+			decoder := json.NewDecoder(bytes.NewReader(line))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&record); err != nil {
+				err = fmt.Errorf("%w: error decoding link: %v", ErrInvalidRequest, err)
+				if err := fn(row, record, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := fn(row, record, nil); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("%w: error reading CSV header: %v", ErrInvalidRequest, err)
+	}
+	if err != nil {
+		return err
+	}
+	columns, err := importColumns(header)
+	if err != nil {
+		return err
+	}
+
+	for row := 1; ; row++ {
+		cells, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		// A malformed row fails on its own, while a body that cannot be read fails the import
+		if err != nil && !errors.As(err, &parseErr) {
+			return err
+		}
+
+		var record LinkRecord
+		if err == nil {
+			err = parseRow(columns, cells, &record)
+		} else {
+			err = fmt.Errorf("%w: error reading CSV: %v", ErrInvalidRequest, err)
+		}
+		if err := fn(row, record, err); err != nil {
+			return err
+		}
+	}
+}
+
+// importColumns returns the columns named by the header of a CSV import, nil for the columns that
+// are only exported. The slug and url columns are required.
+func importColumns(header []string) ([]*recordColumn, error) {
+	columns := make([]*recordColumn, len(header))
+	found := make(map[string]bool)
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		var column *recordColumn
+		for k := range recordColumns {
+			if recordColumns[k].name == name {
+				column = &recordColumns[k]
+			}
+		}
+		if column == nil || found[name] {
+			return nil, fmt.Errorf("%w: unknown or repeated CSV column %q", ErrInvalidRequest, name)
+		}
+		found[name] = true
+		if column.set != nil {
+			columns[i] = column
+		}
+	}
+	for _, name := range []string{"slug", "url"} {
+		if !found[name] {
+			return nil, fmt.Errorf("%w: the CSV header has no %s column", ErrInvalidRequest, name)
+		}
+	}
+	return columns, nil
+}
+
+// parseRow fills in record from the cells of a CSV row
+func parseRow(columns []*recordColumn, cells []string, record *LinkRecord) error {
+	if len(cells) != len(columns) {
+		return fmt.Errorf("%w: the row has %d cells, the header %d", ErrInvalidRequest, len(cells), len(columns))
+	}
+	for i, column := range columns {
+		if column == nil {
+			continue
+		}
+		if err := column.set(record, strings.TrimSpace(cells[i])); err != nil {
+			return fieldError(column.name, fmt.Errorf("%w: %s %v", ErrInvalidRequest, column.name, err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package urlshortener
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newExportService returns a service on a fresh memory repository with a branded domain and a
+// few links, one of them deleted
+func newExportService(t *testing.T) (*Service, *MemoryURLRepository) {
+	t.Helper()
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{Domains: []string{"https://brand.example/"}})
+	ctx := context.Background()
+
+	five := int64(5)
+	notAfter := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	for _, req := range []URLRequest{
+		{URL: "http://example.com/a", Slug: "first", Tags: []string{"docs", "news"}, MaxClicks: &five},
+		{URL: "http://example.com/b", Slug: "second", Domain: "brand.example", Password: "secret", RedirectStatus: 308},
+		{URL: "http://example.com/c", Slug: "third", Window: &Window{NotAfter: &notAfter}},
+	} {
+		_, err := svc.Shorten(ctx, req)
+		require.NoError(t, err)
+	}
+	require.NoError(t, repo.DeleteURL(ctx, "", "http://example.com/c"))
+	return svc, repo
+}
+
+func TestExportLinksCSV(t *testing.T) {
+	svc, _ := newExportService(t)
+
+	var buf bytes.Buffer
+	n, err := svc.ExportLinks(context.Background(), &buf, ExportOptions{Format: FormatCSV})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "soft-deleted links should only be exported when asked")
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, []string{"domain", "slug", "short_url", "url", "original_url", "expires_at", "clicks_left", "not_before", "not_after", "redirect_status", "password_hash", "tags", "clicks"}, rows[0])
+	assert.Equal(t, []string{"", "first", "http://localhost:8080/first", "http://example.com/a", "http://example.com/a", "", "5", "", "", "", "", "docs news", "0"}, rows[1])
+	assert.Equal(t, "brand.example", rows[2][0])
+	assert.Equal(t, "308", rows[2][9])
+	assert.True(t, strings.HasPrefix(rows[2][10], "$2"), "the password hash should be exported")
+
+	buf.Reset()
+	n, err = svc.ExportLinks(context.Background(), &buf, ExportOptions{Format: FormatCSV, Timestamps: true, Deleted: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	rows, err = csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"created_at", "updated_at", "deleted_at"}, rows[0][13:])
+	assert.NotEmpty(t, rows[1][13])
+	assert.Empty(t, rows[1][15])
+	assert.NotEmpty(t, rows[3][15], "a soft-deleted link should have its deletion time")
+}
+
+func TestExportLinksPages(t *testing.T) {
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{})
+	for i := 0; i < exportPageSize+1; i++ {
+		_, err := svc.Shorten(context.Background(), URLRequest{URL: fmt.Sprintf("http://example.com/%d", i)})
+		require.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+	n, err := svc.ExportLinks(context.Background(), &buf, ExportOptions{Format: FormatNDJSON})
+	require.NoError(t, err)
+	assert.Equal(t, exportPageSize+1, n)
+	assert.Equal(t, exportPageSize+1, strings.Count(buf.String(), "\n"))
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatCSV, FormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			svc, repo := newExportService(t)
+			ctx := context.Background()
+
+			var buf bytes.Buffer
+			_, err := svc.ExportLinks(ctx, &buf, ExportOptions{Format: format, Timestamps: true, Deleted: true})
+			require.NoError(t, err)
+
+			target := NewMemoryURLRepository()
+			targetSvc := newTestService(t, target, ServiceConfig{Domains: []string{"https://brand.example/"}})
+			report, err := targetSvc.ImportLinks(ctx, &buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, 3, report.Imported)
+			assert.Empty(t, report.Failed)
+
+			// Links keep their slugs, settings and timestamps
+			for _, key := range []struct{ domain, slug string }{{"", "first"}, {"brand.example", "second"}} {
+				want, err := repo.ReadURLBySlug(ctx, key.domain, key.slug)
+				require.NoError(t, err)
+				got, err := target.ReadURLBySlug(ctx, key.domain, key.slug)
+				require.NoError(t, err)
+				require.NotNil(t, got, "the link %s should be imported", key.slug)
+
+				assert.Equal(t, want.ShortUrl, got.ShortUrl)
+				assert.Equal(t, want.LongUrl, got.LongUrl)
+				assert.Equal(t, want.ClicksLeft, got.ClicksLeft)
+				assert.Equal(t, want.RedirectStatus, got.RedirectStatus)
+				assert.Equal(t, want.PasswordHash, got.PasswordHash)
+				assert.Equal(t, want.Tags, got.Tags)
+				assert.True(t, want.CreatedAt.Equal(got.CreatedAt), "the creation time should be kept")
+			}
+
+			// The deleted link stays deleted but keeps its slug
+			got, err := target.ReadURLBySlug(ctx, "", "third")
+			require.NoError(t, err)
+			assert.Nil(t, got)
+			_, err = targetSvc.Shorten(ctx, URLRequest{URL: "http://example.com/other", Slug: "third"})
+			assert.ErrorIs(t, err, ErrSlugTaken)
+
+			// Importing the same links again reports every one as a conflict
+			buf.Reset()
+			_, err = svc.ExportLinks(ctx, &buf, ExportOptions{Format: format})
+			require.NoError(t, err)
+			report, err = targetSvc.ImportLinks(ctx, &buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, 0, report.Imported)
+			require.Len(t, report.Failed, 2)
+			assert.Equal(t, 1, report.Failed[0].Row)
+			assert.Equal(t, "first", report.Failed[0].Slug)
+			assert.ErrorIs(t, report.Failed[0].Err, ErrSlugTaken)
+		})
+	}
+}
+
+func TestImportLinksValidates(t *testing.T) {
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{Slug: SlugConfig{Reserved: []string{"promo"}}})
+
+	input := strings.Join([]string{
+		"slug,url,tags,expires_at",
+		"good,http://example.com/good,docs,",
+		"local,http://10.0.0.1/admin,,",
+		"api,http://example.com/api,,",
+		"has space,http://example.com/space,,",
+		"when,http://example.com/when,,yesterday",
+		"tagged,http://example.com/tagged,Bad!,",
+		"short,http://example.com/short",
+		"dup,http://example.com/good,,",
+		"promo,http://example.com/promo,,",
+		"self,http://localhost:8080/good,,",
+		"canon,HTTP://Example.COM:80/canon,,",
+	}, "\n")
+	report, err := svc.ImportLinks(context.Background(), strings.NewReader(input), FormatCSV)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Imported)
+
+	wants := []struct {
+		row   int
+		err   error
+		field string
+	}{
+		{2, ErrDestinationBlocked, "url"},
+		{3, ErrSlugReserved, "slug"},
+		{4, ErrInvalidSlug, "slug"},
+		{5, ErrInvalidRequest, "expires_at"},
+		{6, ErrInvalidTag, "tags"},
+		{7, ErrInvalidRequest, ""},
+		{9, ErrSlugReserved, "slug"},
+		{10, ErrSelfLink, "url"},
+		// Conflicts are only found when the valid links are stored
+		{8, ErrDuplicateURL, "url"},
+	}
+	require.Len(t, report.Failed, len(wants))
+	for i, want := range wants {
+		failure := report.Failed[i]
+		assert.Equal(t, want.row, failure.Row)
+		assert.ErrorIs(t, failure.Err, want.err, "row %d", want.row)
+		if want.field != "" {
+			_, apiErr := newAPIError(failure.Err)
+			require.Len(t, apiErr.Details, 1, "row %d", want.row)
+			assert.Equal(t, want.field, apiErr.Details[0].Field)
+		}
+	}
+
+	// Destinations are stored in canonical form
+	canon, err := repo.ReadURLBySlug(context.Background(), "", "canon")
+	require.NoError(t, err)
+	require.NotNil(t, canon)
+	assert.Equal(t, "http://example.com/canon", canon.LongUrl)
+	assert.Equal(t, "HTTP://Example.COM:80/canon", canon.OriginalUrl)
+
+	// A header that is not understood fails the whole import
+	_, err = svc.ImportLinks(context.Background(), strings.NewReader("slug,destination\nabc,http://example.com\n"), FormatCSV)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, err = svc.ImportLinks(context.Background(), strings.NewReader("url\nhttp://example.com\n"), FormatCSV)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, err = svc.ImportLinks(context.Background(), strings.NewReader(""), "xml")
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
+
+func TestExportImportAdmin(t *testing.T) {
+	svc, _ := newExportService(t)
+
+	// Exports carry password hashes, so they are not served on the public handler
+	public := URLHandler(svc, "../templates/")
+	rr := serveAPI(t, public, "GET", "/api/v1/export", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = serveAPI(t, public, "POST", "/api/v1/import", "slug,url\nabc,http://example.com\n")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	handler := AdminHandler(svc)
+	rr = serveAPI(t, handler, "GET", "/api/v1/export?format=ndjson&deleted=true", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="links.ndjson"`, rr.Header().Get("Content-Disposition"))
+	export := rr.Body.String()
+	assert.Equal(t, 3, strings.Count(export, "\n"))
+
+	rr = serveAPI(t, handler, "GET", "/api/v1/export", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(rr.Body.String(), "domain,slug,"))
+
+	rr = serveAPI(t, handler, "GET", "/api/v1/export?format=xml", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Import the export into another deployment, where one slug is already taken
+	target := newTestService(t, NewMemoryURLRepository(), ServiceConfig{Domains: []string{"https://brand.example/"}})
+	_, err := target.Shorten(context.Background(), URLRequest{URL: "http://example.com/taken", Slug: "first"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/api/v1/import", strings.NewReader(export))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rr = httptest.NewRecorder()
+	AdminHandler(target).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var response ImportResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, 2, response.Imported)
+	assert.Equal(t, 1, response.Failed)
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, ImportError{Row: 1, Slug: "first", Error: response.Errors[0].Error}, response.Errors[0])
+	assert.Equal(t, "slug_taken", response.Errors[0].Error.Code)
+}
@@ -2,33 +2,79 @@ package urlshortener
 
 import (
 	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type URLRequest struct {
 	URL    string `json:"url"`
 	NewURL string `json:"new_url"`
+	// Slug optionally requests a custom slug instead of a generated one
+	Slug string `json:"slug,omitempty"`
+	// Domain optionally selects the host of a branded domain instead of the primary domain
+	Domain string `json:"domain,omitempty"`
+	// ExpiresAt optionally sets when the link stops redirecting
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// TTL optionally sets how long the link redirects instead, as a duration such as "72h"
+	TTL string `json:"ttl,omitempty"`
+	// MaxClicks optionally limits how many times the link redirects, 1 for a one-time link
+	MaxClicks *int64 `json:"max_clicks,omitempty"`
+	// Password optionally protects the link, visitors have to enter it before they are redirected
+	Password string `json:"password,omitempty"`
+	// Window optionally schedules when the link redirects. On update it replaces the link's window,
+	// an empty window removes it.
+	Window *Window `json:"window,omitempty"`
+	// RedirectStatus optionally chooses 301, 302, 307 or 308 instead of the server default
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	// Tags optionally label the link, so it can be found by them when links are listed
+	Tags []string `json:"tags,omitempty"`
 }
 
-func URLHandler(db URLRepository, templatePath string) http.Handler {
+// unlockCookie is the name of the cookie that remembers an unlocked link, scoped to the link's path
+const unlockCookie = "url_unlock"
+
+func URLHandler(svc *Service, templatePath string) http.Handler {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/", rootHandler(db))
-	mux.HandleFunc("/app", appHandler(templatePath))
-	mux.HandleFunc("/shorten", shortenHandler(db, templatePath))
-	mux.HandleFunc("/api", apiHandler(db))
+	mux.HandleFunc("/", rootHandler(svc, templatePath))
+	mux.HandleFunc("/app", appHandler(svc, templatePath))
+	mux.HandleFunc("/shorten", shortenHandler(svc, templatePath))
+	mux.HandleFunc("/api", apiHandler(svc))
+	registerAPI(mux, svc)
+
+	return withRequestID(mux)
+}
 
-	return mux
+// AdminHandler serves the metrics on /debug/vars, and the export and import of every link. The
+// metrics expose the command line and memory statistics of the process, exports the password hashes
+// of the links and imports choose their slugs, so it is meant for a separate listener that is not public.
+func AdminHandler(svc *Service) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("GET "+apiRoot+"/export", exportHandler(svc))
+	mux.HandleFunc("POST "+apiRoot+"/import", importHandler(svc))
+	return withRequestID(mux)
 }
 
-func rootHandler(db URLRepository) http.HandlerFunc {
+func rootHandler(svc *Service, templatePath string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		slug := r.URL.Path[1:]
-		log.Printf("Looking up slug: %s", slug)
+		// Slugs follow the domain's base path, which is / unless the domain is mounted under a prefix
+		domain := svc.domainForHost(r.Host)
+		slug, ok := strings.CutPrefix(r.URL.Path, domain.path)
+		if !ok {
+			http.Error(w, "URL not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Looking up slug: %s on %s", slug, domain.Host)
 
-		query, err := db.ReadURLBySlug(slug)
+		query, err := svc.repo.ReadURLBySlug(r.Context(), domain.key, slug)
 		if err != nil {
 			http.Error(w, "Error reading URL", http.StatusInternalServerError)
 			return
@@ -39,11 +85,150 @@ func rootHandler(db URLRepository) http.HandlerFunc {
 			return
 		}
 
-		http.Redirect(w, r, query.LongUrl, http.StatusSeeOther)
+		// Expired links are gone for good, until the sweeper removes them and they are simply not found
+		if query.Expired(time.Now()) {
+			http.Error(w, "URL has expired", http.StatusGone)
+			return
+		}
+
+		// Scheduled links are not found outside their window, but may announce their launch
+		now := time.Now()
+		if query.Pending(now) && svc.config.Schedule.ComingSoon {
+			comingSoon(w, templatePath, query)
+			return
+		}
+		if query.Pending(now) || query.Ended(now) {
+			// The link may go live at any moment, so the answer must not be cached
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "URL not found", http.StatusNotFound)
+			return
+		}
+
+		// Password-protected links ask for the password until the visitor has unlocked them
+		if query.PasswordHash != "" && !unlockLink(w, r, svc, templatePath, domain, query) {
+			return
+		}
+
+		// Link checkers and previews send HEAD, they must neither use up a click-limited link nor
+		// learn where it goes
+		if query.ClicksLeft != nil && r.Method == http.MethodHead {
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// The read may be stale, so a click-limited link only redirects if it wins one of the clicks left
+		if query.ClicksLeft != nil {
+			ok, err := svc.repo.ConsumeClick(r.Context(), domain.key, slug)
+			if err != nil {
+				http.Error(w, "Error reading URL", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "URL has expired", http.StatusGone)
+				return
+			}
+		}
+
+		// A redirect answering the unlock form must not send the password on to the destination,
+		// as 307 and 308 would
+		status := svc.redirectStatus(query)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusSeeOther
+		}
+
+		// Clicks are recorded in the background, so the redirect never waits for the database.
+		// HEAD requests come from link checkers and previews rather than visitors.
+		if r.Method != http.MethodHead {
+			svc.recordClick(r, query)
+		}
+
+		w.Header().Set("Cache-Control", svc.redirectCacheControl(query, status, now))
+		http.Redirect(w, r, query.LongUrl, status)
+	}
+}
+
+// comingSoon serves the page announcing when a scheduled link goes live
+func comingSoon(w http.ResponseWriter, templatePath string, link *URLSchema) {
+	tmpl, err := template.ParseFiles(templatePath + "coming_soon.html")
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+
+	// The link is not there yet, and the page must not be cached past the launch
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	err = tmpl.Execute(w, struct {
+		ShortURL  string
+		NotBefore time.Time
+	}{link.ShortUrl, *link.NotBefore})
+	if err != nil {
+		log.Printf("Error executing coming soon template: %v", err)
 	}
 }
 
-func appHandler(templatePath string) http.HandlerFunc {
+// unlockLink reports whether the visitor has unlocked a password-protected link, either with the
+// cookie of an earlier unlock or by posting the password now. Otherwise it serves the unlock form,
+// or 429 Too Many Requests once the client has tried too many wrong passwords.
+func unlockLink(w http.ResponseWriter, r *http.Request, svc *Service, templatePath string, domain ShortDomain, link *URLSchema) bool {
+	now := time.Now()
+	if cookie, err := r.Cookie(unlockCookie); err == nil && verifyUnlock(svc.unlockKey, link, cookie.Value, now) {
+		return true
+	}
+
+	status, message := http.StatusUnauthorized, ""
+	if r.Method == http.MethodPost {
+		// Attempts are counted per link and client address, so one client cannot lock out the others
+		client := scoped(link.Domain, link.Slug) + "\x00" + svc.clientIP(r)
+
+		if ok, retryAfter := svc.attempts.Attempt(client, now); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			http.Error(w, "Too many wrong passwords, please try again later", http.StatusTooManyRequests)
+			return false
+		}
+
+		if checkPassword(link.PasswordHash, r.FormValue("password")) {
+			svc.attempts.Reset(client)
+			expires := now.Add(svc.config.Passwords.CookieTTL)
+			http.SetCookie(w, &http.Cookie{
+				Name:     unlockCookie,
+				Value:    signUnlock(svc.unlockKey, link, expires),
+				Path:     domain.path + link.Slug,
+				Expires:  expires,
+				HttpOnly: true,
+				Secure:   r.TLS != nil || strings.HasPrefix(domain.URL, "https://"),
+				SameSite: http.SameSiteLaxMode,
+			})
+			return true
+		}
+
+		log.Printf("Wrong password for slug: %s on %s", link.Slug, domain.Host)
+		message = "Wrong password, please try again"
+	}
+
+	tmpl, err := template.ParseFiles(templatePath + "unlock.html")
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return false
+	}
+
+	// The unlock form must not be cached in place of the redirect
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err = tmpl.Execute(w, struct {
+		ShortURL string
+		Error    string
+	}{link.ShortUrl, message})
+	if err != nil {
+		log.Printf("Error executing unlock template: %v", err)
+	}
+	return false
+}
+
+func appHandler(svc *Service, templatePath string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tmpl, err := template.ParseFiles(templatePath + "form.html")
 		if err != nil {
@@ -51,7 +236,7 @@ func appHandler(templatePath string) http.HandlerFunc {
 			return
 		}
 
-		err = tmpl.Execute(w, nil)
+		err = tmpl.Execute(w, svc.Domains())
 		if err != nil {
 			http.Error(w, "Error executing template", http.StatusInternalServerError)
 			return
@@ -59,7 +244,7 @@ func appHandler(templatePath string) http.HandlerFunc {
 	}
 }
 
-func shortenHandler(db URLRepository, templatePath string) http.HandlerFunc {
+func shortenHandler(svc *Service, templatePath string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Redirect(w, r, "/app", http.StatusSeeOther)
@@ -67,17 +252,67 @@ func shortenHandler(db URLRepository, templatePath string) http.HandlerFunc {
 		}
 
 		longURL := r.FormValue("url")
-		url := &URL{
-			LongURL: longURL,
+		slug := r.FormValue("slug")
+		ttl := r.FormValue("ttl")
+		password := r.FormValue("password")
+
+		var redirectStatus int
+		if v := r.FormValue("redirect_status"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "Redirect status must be a number", http.StatusBadRequest)
+				return
+			}
+			redirectStatus = n
+		}
+
+		var maxClicks *int64
+		if v := r.FormValue("max_clicks"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, "Max clicks must be a number", http.StatusBadRequest)
+				return
+			}
+			maxClicks = &n
+		}
+
+		domain, err := svc.resolveDomain(r.FormValue("domain"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
 		// Check if the URL is already in the database
-		query, err := db.ReadURL(longURL)
+		query, err := svc.findURL(r.Context(), domain, longURL)
 		if err != nil {
 			http.Error(w, "Error reading URL", http.StatusInternalServerError)
 			return
 		}
 
+		// A limit or an expiry, on either side, makes it a different link, which is added next to the
+		// existing one. Only one link without either can lead to a URL.
+		if query != nil && (maxClicks != nil || ttl != "" || query.ClicksLeft != nil || query.ExpiresAt != nil) {
+			query = nil
+		}
+
+		// A URL has only one such link, so a different custom slug cannot be added to it
+		if query != nil && slug != "" && slug != query.Slug {
+			http.Error(w, "URL is already shortened as "+query.ShortUrl, http.StatusConflict)
+			return
+		}
+
+		// Handing out the existing link would leave the destination unprotected
+		if query != nil && password != "" {
+			http.Error(w, "URL is already shortened as "+query.ShortUrl, http.StatusConflict)
+			return
+		}
+
+		// The existing link is only handed out when it redirects like the one asked for
+		if query != nil && redirectStatus != query.RedirectStatus {
+			http.Error(w, "URL is already shortened as "+query.ShortUrl, http.StatusConflict)
+			return
+		}
+
 		if query != nil {
 			tmpl := template.Must(template.ParseFiles(templatePath + "result.html"))
 			if err != nil {
@@ -92,21 +327,14 @@ func shortenHandler(db URLRepository, templatePath string) http.HandlerFunc {
 			return
 		}
 
-		shortURL, err := url.GenerateShortURL(longURL)
-		if err != nil {
-			http.Error(w, "Error generating short URL", http.StatusInternalServerError)
-			return
-		}
-
-		u := &URLSchema{
-			LongUrl:  longURL,
-			ShortUrl: shortURL.ShortURL,
-			Slug:     shortURL.Slug,
-		}
-
-		err = db.CreateURL(u)
+		u, err := svc.Shorten(r.Context(), URLRequest{URL: longURL, Slug: slug, Domain: domain.Host, TTL: ttl, MaxClicks: maxClicks, Password: password, RedirectStatus: redirectStatus})
 		if err != nil {
-			http.Error(w, "Error creating URL", http.StatusInternalServerError)
+			// The form shows the message as it is, the API's JSON would only get in the way
+			status, _, message := classifyError(err)
+			if status >= http.StatusInternalServerError {
+				log.Printf("Error shortening %s: %v", longURL, err)
+			}
+			http.Error(w, message, status)
 			return
 		}
 
@@ -125,146 +353,157 @@ func shortenHandler(db URLRepository, templatePath string) http.HandlerFunc {
 	}
 }
 
-func apiHandler(db URLRepository) http.HandlerFunc {
+// apiHandler serves the original API, where the method selects the action and every request has a
+// JSON body. It is deprecated in favor of the links resource at /api/v1/links.
+func apiHandler(svc *Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+apiPrefix+">; rel=\"successor-version\"")
+		metrics.Add("legacy_api_requests", 1)
+
 		var urlRequest URLRequest
 		err := json.NewDecoder(r.Body).Decode(&urlRequest)
 		if err != nil {
-			http.Error(w, "Error decoding request body", http.StatusBadRequest)
+			writeError(w, r, fmt.Errorf("%w: error decoding request body: %v", ErrInvalidRequest, err))
 			return
 		}
 
 		switch r.Method {
 		case http.MethodGet:
-			handleGet(w, r, db, urlRequest)
+			handleGet(w, r, svc, urlRequest)
 		case http.MethodPost:
-			handlePost(w, r, db, urlRequest)
+			handlePost(w, r, svc, urlRequest)
 		case http.MethodPut:
-			handlePut(w, r, db, urlRequest)
+			handlePut(w, r, svc, urlRequest)
 		case http.MethodDelete:
-			handleDelete(w, r, db, urlRequest)
+			handleDelete(w, r, svc, urlRequest)
 		default:
-			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			writeError(w, r, errMethodNotAllowed)
 		}
 	}
 }
 
-func handleGet(w http.ResponseWriter, r *http.Request, db URLRepository, urlRequest URLRequest) {
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&urlRequest)
-	if err != nil {
-		http.Error(w, "Error decoding request body", http.StatusBadRequest)
-		return
-	}
-
+func handleGet(w http.ResponseWriter, r *http.Request, svc *Service, urlRequest URLRequest) {
 	log.Printf("GET request received for: %s", urlRequest.URL)
 
-	response, err := db.ReadURL(urlRequest.URL)
+	domain, err := svc.resolveDomain(urlRequest.Domain)
 	if err != nil {
-		http.Error(w, "Error reading URL", http.StatusInternalServerError)
+		writeError(w, r, fieldError("domain", err))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(response)
+	response, err := svc.findURL(r.Context(), domain, urlRequest.URL)
 	if err != nil {
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-}
-
-func handlePost(w http.ResponseWriter, r *http.Request, db URLRepository, urlRequest URLRequest) {
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&urlRequest)
-	if err != nil {
-		http.Error(w, "Error decoding request body", http.StatusBadRequest)
+	if response == nil {
+		writeError(w, r, ErrNotFound)
 		return
 	}
 
+	writeJSON(w, http.StatusOK, response)
+}
+
+func handlePost(w http.ResponseWriter, r *http.Request, svc *Service, urlRequest URLRequest) {
 	log.Printf("POST request received for: %s", urlRequest.URL)
 
-	u := &URL{}
-	u, err = u.GenerateShortURL(urlRequest.URL)
+	url, err := svc.Shorten(r.Context(), urlRequest)
 	if err != nil {
-		http.Error(w, "Error generating short URL", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	url := &URLSchema{
-		Slug:     u.Slug,
-		ShortUrl: u.ShortURL,
-		LongUrl:  u.LongURL,
+	u := &URL{
+		LongURL:        url.LongUrl,
+		ShortURL:       url.ShortUrl,
+		Slug:           url.Slug,
+		ExpiresAt:      url.ExpiresAt,
+		MaxClicks:      url.ClicksLeft,
+		NotBefore:      url.NotBefore,
+		NotAfter:       url.NotAfter,
+		RedirectStatus: url.RedirectStatus,
 	}
 
-	err = db.CreateURL(url)
-	if err != nil {
-		http.Error(w, "Error creating URL", http.StatusInternalServerError)
-		return
-	}
+	writeJSON(w, http.StatusCreated, u)
+}
+
+func handlePut(w http.ResponseWriter, r *http.Request, svc *Service, urlRequest URLRequest) {
+	log.Printf("PUT request received for: %s", urlRequest.URL)
 
-	w.WriteHeader(http.StatusCreated)
-	err = json.NewEncoder(w).Encode(u)
+	domain, err := svc.resolveDomain(urlRequest.Domain)
 	if err != nil {
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		writeError(w, r, fieldError("domain", err))
 		return
 	}
-}
 
-func handlePut(w http.ResponseWriter, r *http.Request, db URLRepository, urlRequest URLRequest) {
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&urlRequest)
+	response, err := svc.findURL(r.Context(), domain, urlRequest.URL)
 	if err != nil {
-		http.Error(w, "Error decoding request body", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	log.Printf("PUT request received for: %s", urlRequest.URL)
-
-	response, err := db.ReadURL(urlRequest.URL)
-	if err != nil {
-		http.Error(w, "Error reading URL", http.StatusInternalServerError)
-		return
+	// A request that only reschedules the link keeps its destination
+	var newURL *string
+	if urlRequest.NewURL != "" || urlRequest.Window == nil {
+		newURL = &urlRequest.NewURL
 	}
 
-	err = db.UpdateURL(urlRequest.URL, urlRequest.NewURL)
-	if err != nil {
-		http.Error(w, "Error updating URL", http.StatusInternalServerError)
+	if response == nil {
+		// An invalid request is reported as such, even for a link that does not exist
+		if urlRequest.Window != nil {
+			if _, err := urlRequest.Window.validate(time.Now()); err != nil {
+				writeError(w, r, fieldError("window", err))
+				return
+			}
+		}
+		if newURL != nil {
+			if _, err := svc.prepareDestination(r.Context(), *newURL, nil); errors.Is(err, ErrInvalidURL) {
+				writeError(w, r, fieldError("new_url", err))
+				return
+			}
+		}
+		writeError(w, r, ErrNotFound)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(response)
-
+	err = svc.UpdateLink(r.Context(), domain, response, LinkPatch{URL: newURL, Window: urlRequest.Window})
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) && fieldErr.Field == "url" {
+		// The destination is new_url in this API
+		fieldErr.Field = "new_url"
+	}
 	if err != nil {
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+
+	writeJSON(w, http.StatusOK, response)
 }
 
-func handleDelete(w http.ResponseWriter, r *http.Request, db URLRepository, urlRequest URLRequest) {
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&urlRequest)
+func handleDelete(w http.ResponseWriter, r *http.Request, svc *Service, urlRequest URLRequest) {
+	log.Printf("DELETE request received for: %s", urlRequest.URL)
+
+	domain, err := svc.resolveDomain(urlRequest.Domain)
 	if err != nil {
-		http.Error(w, "Error decoding request body", http.StatusBadRequest)
+		writeError(w, r, fieldError("domain", err))
 		return
 	}
 
-	log.Printf("DELETE request received for: %s", urlRequest.URL)
-
-	response, err := db.ReadURL(urlRequest.URL)
+	response, err := svc.findURL(r.Context(), domain, urlRequest.URL)
 	if err != nil {
-		http.Error(w, "Error reading URL", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	if response == nil {
-		http.Error(w, "URL not found", http.StatusNotFound)
+		writeError(w, r, ErrNotFound)
 		return
 	}
 
-	err = db.DeleteURL(response.LongUrl)
+	err = svc.repo.DeleteURL(r.Context(), domain.key, response.LongUrl)
 	if err != nil {
-		http.Error(w, "Error deleting URL", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -2,12 +2,15 @@ package urlshortener
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -19,8 +22,8 @@ type MockURLRepository struct {
 }
 
 // ReadURLBySlug is a mock method for URLRepository.ReadURLBySlug
-func (m *MockURLRepository) ReadURLBySlug(slug string) (*URLSchema, error) {
-	args := m.Called(slug)
+func (m *MockURLRepository) ReadURLBySlug(ctx context.Context, domain, slug string) (*URLSchema, error) {
+	args := m.Called(domain, slug)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -28,14 +31,14 @@ func (m *MockURLRepository) ReadURLBySlug(slug string) (*URLSchema, error) {
 }
 
 // CreateURL is a mock method for URLRepository.CreateURL
-func (m *MockURLRepository) CreateURL(url *URLSchema) error {
+func (m *MockURLRepository) CreateURL(ctx context.Context, url *URLSchema) error {
 	args := m.Called(url)
 	return args.Error(0)
 }
 
 // ReadURL is a mock method for URLRepository.ReadURL
-func (m *MockURLRepository) ReadURL(longUrl string) (*URLSchema, error) {
-	args := m.Called(longUrl)
+func (m *MockURLRepository) ReadURL(ctx context.Context, domain, longUrl string) (*URLSchema, error) {
+	args := m.Called(domain, longUrl)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -43,26 +46,98 @@ func (m *MockURLRepository) ReadURL(longUrl string) (*URLSchema, error) {
 }
 
 // UpdateURL is a mock method for URLRepository.UpdateURL
-func (m *MockURLRepository) UpdateURL(slug, newLongURL string) error {
-	args := m.Called(slug, newLongURL)
+func (m *MockURLRepository) UpdateURL(ctx context.Context, domain, longUrl, newLongURL string) error {
+	args := m.Called(domain, longUrl, newLongURL)
+	return args.Error(0)
+}
+
+// UpdateLink is a mock method for URLRepository.UpdateLink
+func (m *MockURLRepository) UpdateLink(ctx context.Context, domain, slug string, update LinkUpdate) error {
+	args := m.Called(domain, slug, update)
 	return args.Error(0)
 }
 
 // DeleteURL is a mock method for URLRepository.DeleteURL
-func (m *MockURLRepository) DeleteURL(slug string) error {
-	args := m.Called(slug)
+func (m *MockURLRepository) DeleteURL(ctx context.Context, domain, longUrl string) error {
+	args := m.Called(domain, longUrl)
+	return args.Error(0)
+}
+
+// DeleteURLBySlug is a mock method for URLRepository.DeleteURLBySlug
+func (m *MockURLRepository) DeleteURLBySlug(ctx context.Context, domain, slug string) error {
+	args := m.Called(domain, slug)
 	return args.Error(0)
 }
 
+// NextSequence is a mock method for URLRepository.NextSequence
+func (m *MockURLRepository) NextSequence(ctx context.Context, name string) (uint64, error) {
+	args := m.Called(name)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+// ConsumeClick is a mock method for URLRepository.ConsumeClick
+func (m *MockURLRepository) ConsumeClick(ctx context.Context, domain, slug string) (bool, error) {
+	args := m.Called(domain, slug)
+	return args.Bool(0), args.Error(1)
+}
+
+// DeleteExpiredURLs is a mock method for URLRepository.DeleteExpiredURLs
+func (m *MockURLRepository) DeleteExpiredURLs(ctx context.Context, before time.Time, purge bool) (int64, error) {
+	args := m.Called(before, purge)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// RecordClicks is a mock method for URLRepository.RecordClicks
+func (m *MockURLRepository) RecordClicks(ctx context.Context, events []ClickEvent) error {
+	args := m.Called(events)
+	return args.Error(0)
+}
+
+// ClickStats is a mock method for URLRepository.ClickStats
+func (m *MockURLRepository) ClickStats(ctx context.Context, urlID uint, since time.Time, bucket time.Duration) (*ClickStats, error) {
+	args := m.Called(urlID, since, bucket)
+	if stats, ok := args.Get(0).(*ClickStats); ok {
+		return stats, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// CreateURLs is a mock method for URLRepository.CreateURLs
+func (m *MockURLRepository) CreateURLs(ctx context.Context, urls []*URLSchema, all bool) ([]error, error) {
+	args := m.Called(urls, all)
+	if errs, ok := args.Get(0).([]error); ok {
+		return errs, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// ListURLs is a mock method for URLRepository.ListURLs
+func (m *MockURLRepository) ListURLs(ctx context.Context, filter URLFilter) ([]URLSchema, error) {
+	args := m.Called(filter)
+	if urls, ok := args.Get(0).([]URLSchema); ok {
+		return urls, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// ExportURLs is a mock method for URLRepository.ExportURLs
+func (m *MockURLRepository) ExportURLs(ctx context.Context, afterID uint, limit int, deleted bool) ([]URLSchema, error) {
+	args := m.Called(afterID, limit, deleted)
+	if urls, ok := args.Get(0).([]URLSchema); ok {
+		return urls, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 func TestRootHandler(t *testing.T) {
 	// Create a new mock URL repository
 	repo := new(MockURLRepository)
 
 	// Create a new URL handler with the mock URL repository
-	handler := rootHandler(repo)
+	handler := rootHandler(newTestService(t, repo, ServiceConfig{}), "../templates/")
 
 	// Expect a call to ReadURLBySlug with "abc123" and return a URLSchema
-	repo.On("ReadURLBySlug", "abc123").Return(&URLSchema{
+	repo.On("ReadURLBySlug", "", "abc123").Return(&URLSchema{
 		Slug:     "abc123",
 		LongUrl:  "http://example.com",
 		ShortUrl: "http://localhost:8080/abc123",
@@ -78,7 +153,7 @@ func TestRootHandler(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 
 	// Check the status code
-	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, http.StatusFound, rr.Code)
 
 	// Check the redirect location
 	assert.Equal(t, "http://example.com", rr.Header().Get("Location"))
@@ -88,10 +163,266 @@ func TestRootHandler(t *testing.T) {
 
 }
 
+func TestRootHandlerExpired(t *testing.T) {
+	// Create a new mock URL repository with an expired and a live link
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	repo := new(MockURLRepository)
+	repo.On("ReadURLBySlug", "", "old").Return(&URLSchema{Slug: "old", LongUrl: "http://example.com", ExpiresAt: &past}, nil)
+	repo.On("ReadURLBySlug", "", "new").Return(&URLSchema{Slug: "new", LongUrl: "http://example.org", ExpiresAt: &future}, nil)
+
+	handler := rootHandler(newTestService(t, repo, ServiceConfig{}), "../templates/")
+
+	// Expired links are gone
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/old", nil))
+	assert.Equal(t, http.StatusGone, rr.Code)
+	assert.Empty(t, rr.Header().Get("Location"))
+
+	// Links that have not expired yet still redirect
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/new", nil))
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "http://example.org", rr.Header().Get("Location"))
+
+	repo.AssertExpectations(t)
+}
+
+func TestRootHandlerClickLimit(t *testing.T) {
+	// Create a one-time link in memory, so concurrent clicks race for real
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{})
+	one := int64(1)
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com", MaxClicks: &one})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when shortening the URL", err)
+	}
+
+	handler := rootHandler(svc, "../templates/")
+
+	// Only one of the concurrent requests is redirected
+	const n = 10
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/"+url.Slug, nil))
+			codes <- rr.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	count := make(map[int]int)
+	for code := range codes {
+		count[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusFound: 1, http.StatusGone: n - 1}, count)
+
+	// HEAD requests neither use a click nor reveal the destination
+	two := int64(2)
+	url, err = svc.Shorten(context.Background(), URLRequest{URL: "http://example.org", MaxClicks: &two})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when shortening the URL", err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("HEAD", "/"+url.Slug, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Location"))
+
+	stored, err := repo.ReadURLBySlug(context.Background(), "", url.Slug)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reading the URL", err)
+	}
+	assert.Equal(t, int64(2), *stored.ClicksLeft)
+}
+
+func TestRootHandlerRedirectStatus(t *testing.T) {
+	repo := new(MockURLRepository)
+	repo.On("ReadURLBySlug", "", "moved").Return(&URLSchema{Slug: "moved", LongUrl: "http://example.com", RedirectStatus: 301}, nil)
+	repo.On("ReadURLBySlug", "", "default").Return(&URLSchema{Slug: "default", LongUrl: "http://example.com"}, nil)
+
+	handler := rootHandler(newTestService(t, repo, ServiceConfig{Redirects: RedirectConfig{Status: 307}}), "../templates/")
+
+	// Links redirect with their own status, and permanent redirects may be cached
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/moved", nil))
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+	assert.Equal(t, "http://example.com", rr.Header().Get("Location"))
+	assert.Equal(t, "public, max-age=86400", rr.Header().Get("Cache-Control"))
+
+	// Or with the server default, temporary ones are not cached
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/default", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+
+	repo.AssertExpectations(t)
+}
+
+func TestRootHandlerSchedule(t *testing.T) {
+	now := time.Now()
+	launch, ended := now.Add(time.Hour), now.Add(-time.Hour)
+	repo := new(MockURLRepository)
+	repo.On("ReadURLBySlug", "", "soon").Return(&URLSchema{Slug: "soon", ShortUrl: "http://localhost:8080/soon", LongUrl: "http://example.com", NotBefore: &launch}, nil)
+	repo.On("ReadURLBySlug", "", "over").Return(&URLSchema{Slug: "over", LongUrl: "http://example.com", NotAfter: &ended}, nil)
+	repo.On("ReadURLBySlug", "", "live").Return(&URLSchema{Slug: "live", LongUrl: "http://example.com", NotBefore: &ended, NotAfter: &launch}, nil)
+
+	get := func(handler http.Handler, slug string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/"+slug, nil))
+		return rr
+	}
+
+	// Outside their window links are not found
+	handler := rootHandler(newTestService(t, repo, ServiceConfig{}), "../templates/")
+	for _, slug := range []string{"soon", "over"} {
+		rr := get(handler, slug)
+		assert.Equal(t, http.StatusNotFound, rr.Code, slug)
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"), slug)
+	}
+	assert.Equal(t, http.StatusFound, get(handler, "live").Code)
+
+	// Or, before launch, announced
+	handler = rootHandler(newTestService(t, repo, ServiceConfig{Schedule: ScheduleConfig{ComingSoon: true}}), "../templates/")
+	rr := get(handler, "soon")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), "http://localhost:8080/soon is coming soon")
+	assert.NotContains(t, rr.Body.String(), "http://example.com")
+
+	rr = get(handler, "over")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "coming soon")
+
+	repo.AssertExpectations(t)
+}
+
+func TestRootHandlerPassword(t *testing.T) {
+	// Create a password-protected link in memory
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{Passwords: PasswordConfig{MaxAttempts: 2}})
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com", Password: "s3cret"})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when shortening the URL", err)
+	}
+
+	handler := rootHandler(svc, "../templates/")
+	unlock := func(password string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/"+url.Slug, strings.NewReader("password="+password))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Visitors get the unlock form instead of a redirect
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/"+url.Slug, nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), `name="password"`)
+	assert.Empty(t, rr.Header().Get("Location"))
+
+	// A wrong password shows the form again
+	rr = unlock("wrong")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Wrong password")
+	assert.Empty(t, rr.Result().Cookies())
+
+	// The right password redirects, with 303 so the form is not posted on, and sets a cookie scoped to the link
+	rr = unlock("s3cret")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "http://example.com/", rr.Header().Get("Location"))
+	cookies := rr.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, "/"+url.Slug, cookies[0].Path)
+		assert.True(t, cookies[0].HttpOnly)
+	}
+
+	// The cookie unlocks the link for later visits
+	req := httptest.NewRequest("GET", "/"+url.Slug, nil)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+
+	// Wrong passwords are limited per client
+	unlock("wrong")
+	unlock("wrong")
+	rr = unlock("s3cret")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	// But a client that already unlocked the link is still let through
+	rr = unlock("", cookies[0])
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+}
+
+func TestRootHandlerDomains(t *testing.T) {
+	// Create a new mock URL repository with the same slug on two domains
+	repo := new(MockURLRepository)
+	repo.On("ReadURLBySlug", "", "sale").Return(&URLSchema{Slug: "sale", LongUrl: "http://example.com"}, nil)
+	repo.On("ReadURLBySlug", "brand.co", "sale").Return(&URLSchema{Domain: "brand.co", Slug: "sale", LongUrl: "http://example.org"}, nil)
+
+	handler := rootHandler(newTestService(t, repo, ServiceConfig{Domain: "https://sho.rt", Domains: []string{"https://brand.co"}}), "../templates/")
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "sho.rt", want: "http://example.com"},
+		{host: "BRAND.co", want: "http://example.org"},
+		// Unknown hosts fall back to the primary domain
+		{host: "10.0.0.1:8080", want: "http://example.com"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/sale", nil)
+		req.Host = tt.host
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusFound, rr.Code, tt.host)
+		assert.Equal(t, tt.want, rr.Header().Get("Location"), tt.host)
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestRootHandlerDomainPath(t *testing.T) {
+	// Create a new mock URL repository with a link on a domain mounted under /s/
+	repo := new(MockURLRepository)
+	repo.On("ReadURLBySlug", "example.com", "sale").Return(&URLSchema{Domain: "example.com", Slug: "sale", LongUrl: "http://example.org"}, nil)
+
+	handler := rootHandler(newTestService(t, repo, ServiceConfig{Domain: "https://sho.rt", Domains: []string{"https://example.com/s/"}}), "../templates/")
+
+	// The slug follows the domain's path
+	req := httptest.NewRequest("GET", "/s/sale", nil)
+	req.Host = "example.com"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "http://example.org", rr.Header().Get("Location"))
+
+	// Paths outside it are not links
+	req = httptest.NewRequest("GET", "/sale", nil)
+	req.Host = "example.com"
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	repo.AssertExpectations(t)
+}
+
 func TestAppHandler(t *testing.T) {
 
 	// Create a new URL handler with the mock URL repository
-	handler := appHandler("../templates/")
+	handler := appHandler(newTestService(t, new(MockURLRepository), ServiceConfig{Domain: "https://sho.rt"}), "../templates/")
 
 	// Create a new HTTP request
 	req := httptest.NewRequest("GET", "/app", nil)
@@ -107,6 +438,14 @@ func TestAppHandler(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 
+	// The form shows the configured domain
+	assert.Contains(t, rr.Body.String(), "https://sho.rt/")
+
+	// With branded domains the form offers a choice
+	handler = appHandler(newTestService(t, new(MockURLRepository), ServiceConfig{Domain: "https://sho.rt", Domains: []string{"https://brand.co"}}), "../templates/")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Contains(t, rr.Body.String(), `<option value="brand.co">https://brand.co/</option>`)
 }
 
 func TestShortenHandler(t *testing.T) {
@@ -115,7 +454,7 @@ func TestShortenHandler(t *testing.T) {
 
 	// Set up the expectation
 	repo.On("CreateURL", mock.Anything).Return(nil)
-	repo.On("ReadURL", "http://example.com").Return(nil, nil)
+	repo.On("ReadURL", "", "http://example.com/").Return(nil, nil)
 
 	// Create a new HTTP request with form data
 	form := url.Values{}
@@ -127,7 +466,7 @@ func TestShortenHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Create the handler
-	handler := shortenHandler(repo, "../templates/")
+	handler := shortenHandler(newTestService(t, repo, ServiceConfig{}), "../templates/")
 
 	// Serve the HTTP request
 	handler.ServeHTTP(rr, req)
@@ -141,7 +480,7 @@ func TestShortenHandler(t *testing.T) {
 	// Test the case where the URL is already in the database
 	// Set up the expectation
 
-	repo.On("ReadURL", "http://example.com").Return(&URLSchema{
+	repo.On("ReadURL", "", "http://example.com/").Return(&URLSchema{
 		LongUrl:  "http://example.com",
 		ShortUrl: "http://short.com"}, nil)
 
@@ -155,7 +494,7 @@ func TestShortenHandler(t *testing.T) {
 	rr = httptest.NewRecorder()
 
 	// Create the handler
-	handler = shortenHandler(repo, "../templates/")
+	handler = shortenHandler(newTestService(t, repo, ServiceConfig{}), "../templates/")
 	// Serve the HTTP request
 	handler.ServeHTTP(rr, req)
 
@@ -172,7 +511,7 @@ func Test_Api_Get(t *testing.T) {
 
 	// Set up the expectation
 	expectedResponse := &URLSchema{LongUrl: "http://example.com", ShortUrl: "http://short.com"}
-	repo.On("ReadURL", "http://example.com").Return(expectedResponse, nil)
+	repo.On("ReadURL", "", "http://example.com/").Return(expectedResponse, nil)
 
 	// Create a new URLRequest
 	urlRequest := URLRequest{
@@ -193,7 +532,7 @@ func Test_Api_Get(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Call the handleGet function
-	handleGet(rr, req, repo, urlRequest)
+	handleGet(rr, req, newTestService(t, repo, ServiceConfig{}), urlRequest)
 
 	// Check the status code
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -239,7 +578,7 @@ func Test_Api_Post(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Call the handlePost function
-	handlePost(rr, req, repo, urlRequest)
+	handlePost(rr, req, newTestService(t, repo, ServiceConfig{}), urlRequest)
 
 	// Check the status code
 	assert.Equal(t, http.StatusCreated, rr.Code)
@@ -251,8 +590,8 @@ func Test_Api_Post(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when unmarshaling the response", err)
 	}
 
-	// Check the response
-	assert.Equal(t, urlRequest.URL, response.LongURL)
+	// Check the response, the long URL is stored in canonical form
+	assert.Equal(t, "http://example.com/", response.LongURL)
 
 	// Assert that the expectations were met
 	repo.AssertExpectations(t)
@@ -264,9 +603,10 @@ func Test_Api_Put(t *testing.T) {
 	repo := new(MockURLRepository)
 
 	// Set up the expectation
-	expectedResponse := &URLSchema{LongUrl: "http://example.com", ShortUrl: "http://short.com"}
-	repo.On("ReadURL", "http://example.com").Return(expectedResponse, nil)
-	repo.On("UpdateURL", "http://example.com", "http://newexample.com").Return(nil)
+	expectedResponse := &URLSchema{Slug: "abc123", LongUrl: "http://example.com", ShortUrl: "http://short.com"}
+	newURL := "http://newexample.com/"
+	repo.On("ReadURL", "", "http://example.com/").Return(expectedResponse, nil)
+	repo.On("UpdateLink", "", "abc123", LinkUpdate{LongURL: &newURL, OriginalURL: "http://newexample.com"}).Return(nil)
 
 	// Create a new URLRequest
 	urlRequest := URLRequest{
@@ -288,7 +628,7 @@ func Test_Api_Put(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Call the handlePut function
-	handlePut(rr, req, repo, urlRequest)
+	handlePut(rr, req, newTestService(t, repo, ServiceConfig{}), urlRequest)
 
 	// Check the status code
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -314,8 +654,8 @@ func Test_Api_Delete(t *testing.T) {
 
 	// Set up the expectation
 	expectedResponse := &URLSchema{LongUrl: "http://example.com", ShortUrl: "http://short.com"}
-	repo.On("ReadURL", "http://example.com").Return(expectedResponse, nil)
-	repo.On("DeleteURL", "http://example.com").Return(nil)
+	repo.On("ReadURL", "", "http://example.com/").Return(expectedResponse, nil)
+	repo.On("DeleteURL", "", "http://example.com").Return(nil)
 
 	// Create a new URLRequest
 	urlRequest := URLRequest{
@@ -336,7 +676,7 @@ func Test_Api_Delete(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Call the handleDelete function
-	handleDelete(rr, req, repo, urlRequest)
+	handleDelete(rr, req, newTestService(t, repo, ServiceConfig{}), urlRequest)
 
 	// Check the status code
 	assert.Equal(t, http.StatusNoContent, rr.Code)
@@ -345,3 +685,369 @@ func Test_Api_Delete(t *testing.T) {
 	repo.AssertExpectations(t)
 
 }
+
+func Test_Api_Post_URLTooLong(t *testing.T) {
+	// Create a new mock URL repository, nothing should be stored
+	repo := new(MockURLRepository)
+
+	// Create a new URLRequest over the configured limit
+	urlRequest := URLRequest{
+		URL: "http://example.com/" + strings.Repeat("a", 100),
+	}
+
+	// Marshal the URLRequest to JSON
+	jsonRequest, err := json.Marshal(urlRequest)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when marshaling the URLRequest", err)
+	}
+
+	// Create a new HTTP request
+	req := httptest.NewRequest("POST", "/api", bytes.NewBuffer(jsonRequest))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create a new response recorder
+	rr := httptest.NewRecorder()
+
+	// Call the handlePost function
+	handlePost(rr, req, newTestService(t, repo, ServiceConfig{MaxURLLength: 50}), urlRequest)
+
+	// Check the status code
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "URL is too long")
+
+	// Assert that the expectations were met
+	repo.AssertExpectations(t)
+}
+
+func Test_Api_Post_CustomSlug(t *testing.T) {
+	// Create a new mock URL repository
+	repo := new(MockURLRepository)
+
+	// Set up the expectation
+	repo.On("CreateURL", mock.MatchedBy(func(u *URLSchema) bool { return u.Slug == "spring-sale" })).Return(nil)
+
+	// Create a new URLRequest with a custom slug
+	urlRequest := URLRequest{
+		URL:  "http://example.com",
+		Slug: "spring-sale",
+	}
+
+	// Marshal the URLRequest to JSON
+	jsonRequest, err := json.Marshal(urlRequest)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when marshaling the URLRequest", err)
+	}
+
+	// Create a new HTTP request
+	req := httptest.NewRequest("POST", "/api", bytes.NewBuffer(jsonRequest))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create a new response recorder
+	rr := httptest.NewRecorder()
+
+	// Call the handlePost function
+	handlePost(rr, req, newTestService(t, repo, ServiceConfig{}), urlRequest)
+
+	// Check the status code and the slug
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var response URL
+	err = json.Unmarshal(rr.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when unmarshaling the response", err)
+	}
+	assert.Equal(t, "spring-sale", response.Slug)
+
+	// Assert that the expectations were met
+	repo.AssertExpectations(t)
+}
+
+func Test_Api_Post_TTL(t *testing.T) {
+	// Create a new mock URL repository
+	repo := new(MockURLRepository)
+	repo.On("CreateURL", mock.MatchedBy(func(u *URLSchema) bool { return u.ExpiresAt != nil })).Return(nil)
+
+	// Create a URLRequest for a link that expires in a day
+	urlRequest := URLRequest{URL: "http://example.com", TTL: "24h"}
+	jsonRequest, err := json.Marshal(urlRequest)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when marshaling the URLRequest", err)
+	}
+
+	req := httptest.NewRequest("POST", "/api", bytes.NewBuffer(jsonRequest))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	handlePost(rr, req, newTestService(t, repo, ServiceConfig{}), urlRequest)
+
+	// The response tells when the link expires
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var response URL
+	err = json.Unmarshal(rr.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when unmarshaling the response", err)
+	}
+	if assert.NotNil(t, response.ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(24*time.Hour), *response.ExpiresAt, time.Minute)
+	}
+
+	// An expiry in the past is rejected
+	urlRequest = URLRequest{URL: "http://example.org", TTL: "-1h"}
+	jsonRequest, err = json.Marshal(urlRequest)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when marshaling the URLRequest", err)
+	}
+	rr = httptest.NewRecorder()
+	handlePost(rr, httptest.NewRequest("POST", "/api", bytes.NewBuffer(jsonRequest)), newTestService(t, repo, ServiceConfig{}), urlRequest)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "invalid expiry")
+
+	repo.AssertExpectations(t)
+}
+
+func Test_Api_Post_CustomSlugRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		slug     string
+		createFn func(repo *MockURLRepository)
+		wantCode int
+	}{
+		{
+			name:     "taken",
+			slug:     "spring-sale",
+			createFn: func(repo *MockURLRepository) { repo.On("CreateURL", mock.Anything).Return(ErrSlugTaken) },
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "reserved",
+			slug:     "shorten",
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "invalid characters",
+			slug:     "spring sale",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new mock URL repository
+			repo := new(MockURLRepository)
+			if tt.createFn != nil {
+				tt.createFn(repo)
+			}
+
+			urlRequest := URLRequest{URL: "http://example.com", Slug: tt.slug}
+			jsonRequest, err := json.Marshal(urlRequest)
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when marshaling the URLRequest", err)
+			}
+
+			req := httptest.NewRequest("POST", "/api", bytes.NewBuffer(jsonRequest))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			handlePost(rr, req, newTestService(t, repo, ServiceConfig{}), urlRequest)
+
+			assert.Equal(t, tt.wantCode, rr.Code)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestShortenHandlerCustomSlug(t *testing.T) {
+	// Create a new mock URL repository where the URL is already shortened
+	repo := new(MockURLRepository)
+	repo.On("ReadURL", "", "http://example.com/").Return(&URLSchema{
+		Slug:     "abc123",
+		LongUrl:  "http://example.com",
+		ShortUrl: "http://short.com/abc123"}, nil)
+
+	// Asking for another slug for the same URL is a conflict
+	form := url.Values{}
+	form.Add("url", "http://example.com")
+	form.Add("slug", "spring-sale")
+	req := httptest.NewRequest("POST", "/shorten", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := shortenHandler(newTestService(t, repo, ServiceConfig{}), "../templates/")
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "http://short.com/abc123")
+
+	// So is asking for a password, the existing link would not be protected
+	form = url.Values{}
+	form.Add("url", "http://example.com")
+	form.Add("password", "s3cret")
+	req = httptest.NewRequest("POST", "/shorten", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	repo.AssertExpectations(t)
+}
+
+func TestShortenHandlerDedupe(t *testing.T) {
+	one := int64(1)
+	used := int64(0)
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name    string
+		stored  URLSchema
+		form    map[string]string
+		status  int
+		created bool
+	}{
+		{"same link", URLSchema{}, nil, http.StatusOK, false},
+		{"other status", URLSchema{}, map[string]string{"redirect_status": "301"}, http.StatusConflict, false},
+		{"stored status", URLSchema{RedirectStatus: 308}, nil, http.StatusConflict, false},
+		{"limit asked for", URLSchema{}, map[string]string{"max_clicks": "5"}, http.StatusOK, true},
+		{"expiry asked for", URLSchema{}, map[string]string{"ttl": "24h"}, http.StatusOK, true},
+		{"stored limit", URLSchema{ClicksLeft: &one}, nil, http.StatusOK, true},
+		{"used up", URLSchema{ClicksLeft: &used}, nil, http.StatusOK, true},
+		{"expired", URLSchema{ExpiresAt: &past}, nil, http.StatusOK, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new mock URL repository where the URL is already shortened
+			stored := tt.stored
+			stored.Slug, stored.LongUrl, stored.ShortUrl = "abc123", "http://example.com/", "http://short.com/abc123"
+			repo := new(MockURLRepository)
+			repo.On("ReadURL", "", "http://example.com/").Return(&stored, nil)
+			repo.On("CreateURL", mock.Anything).Return(nil)
+
+			form := url.Values{}
+			form.Add("url", "http://example.com")
+			for k, v := range tt.form {
+				form.Add(k, v)
+			}
+			req := httptest.NewRequest("POST", "/shorten", strings.NewReader(form.Encode()))
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			rr := httptest.NewRecorder()
+			shortenHandler(newTestService(t, repo, ServiceConfig{}), "../templates/").ServeHTTP(rr, req)
+
+			// A link with a limit or an expiry, asked for or stored, is added next to the existing one
+			assert.Equal(t, tt.status, rr.Code)
+			if tt.created {
+				assert.NotContains(t, rr.Body.String(), "http://short.com/abc123")
+				repo.AssertNumberOfCalls(t, "CreateURL", 1)
+			} else {
+				assert.Contains(t, rr.Body.String(), "http://short.com/abc123")
+				repo.AssertNotCalled(t, "CreateURL", mock.Anything)
+			}
+		})
+	}
+}
+
+func Test_Api_Put_Window(t *testing.T) {
+	launch := time.Now().Add(time.Hour).UTC()
+
+	// Create a new mock URL repository where only the window is updated
+	repo := new(MockURLRepository)
+	repo.On("ReadURL", "", "http://example.com/").Return(&URLSchema{Slug: "abc123", LongUrl: "http://example.com/"}, nil)
+	repo.On("UpdateLink", "", "abc123", LinkUpdate{Window: &Window{NotBefore: &launch}}).Return(nil)
+
+	// Reschedule the link without changing its destination
+	urlRequest := URLRequest{URL: "http://example.com", Window: &Window{NotBefore: &launch}}
+	jsonRequest, err := json.Marshal(urlRequest)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when marshaling the URLRequest", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handlePut(rr, httptest.NewRequest("PUT", "/api", bytes.NewBuffer(jsonRequest)), newTestService(t, repo, ServiceConfig{}), urlRequest)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// The response shows the new window
+	var response URLSchema
+	err = json.Unmarshal(rr.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when unmarshaling the response", err)
+	}
+	if assert.NotNil(t, response.NotBefore) {
+		assert.True(t, launch.Equal(*response.NotBefore))
+	}
+
+	// A window that ends before it starts is rejected before anything is updated
+	ended := launch.Add(-2 * time.Hour)
+	urlRequest = URLRequest{URL: "http://example.com", NewURL: "http://example.org", Window: &Window{NotAfter: &ended}}
+	jsonRequest, err = json.Marshal(urlRequest)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when marshaling the URLRequest", err)
+	}
+
+	rr = httptest.NewRecorder()
+	handlePut(rr, httptest.NewRequest("PUT", "/api", bytes.NewBuffer(jsonRequest)), newTestService(t, repo, ServiceConfig{}), urlRequest)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	repo.AssertExpectations(t)
+	repo.AssertNumberOfCalls(t, "UpdateLink", 1)
+}
+
+func Test_Api_Put_BlockedDestination(t *testing.T) {
+	// Create a new mock URL repository, nothing should be updated
+	repo := new(MockURLRepository)
+	repo.On("ReadURL", "", "http://example.com/").Return(&URLSchema{Slug: "abc123", LongUrl: "http://example.com"}, nil)
+
+	// Create a new URLRequest pointing the link at a private address
+	urlRequest := URLRequest{
+		URL:    "http://example.com",
+		NewURL: "http://169.254.169.254/latest/meta-data/",
+	}
+
+	jsonRequest, err := json.Marshal(urlRequest)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when marshaling the URLRequest", err)
+	}
+
+	req := httptest.NewRequest("PUT", "/api", bytes.NewBuffer(jsonRequest))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	handlePut(rr, req, newTestService(t, repo, ServiceConfig{}), urlRequest)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "not a public address")
+	repo.AssertExpectations(t)
+}
+
+func TestRootHandlerRecordsClicks(t *testing.T) {
+	repo := new(MockURLRepository)
+	repo.On("ReadURLBySlug", "", "abc123").Return(&URLSchema{Slug: "abc123", LongUrl: "http://example.com"}, nil)
+	svc := newTestService(t, repo, ServiceConfig{})
+	handler := rootHandler(svc, "../templates/")
+
+	// Redirects queue a click without touching the repository
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/abc123", nil))
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Len(t, svc.clicks, 1)
+
+	// Link checkers and previews are not counted
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("HEAD", "/abc123", nil))
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Len(t, svc.clicks, 1)
+
+	repo.AssertNotCalled(t, "RecordClicks", mock.Anything)
+}
+
+func TestAdminHandler(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{})
+
+	// The metrics are not served on the public handler
+	rr := httptest.NewRecorder()
+	URLHandler(svc, "../templates/").ServeHTTP(rr, httptest.NewRequest("GET", "/debug/vars", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	AdminHandler(svc).ServeHTTP(rr, httptest.NewRequest("GET", "/debug/vars", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"urlshortener"`)
+}
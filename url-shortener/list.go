@@ -0,0 +1,130 @@
+package urlshortener
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// SortCreated lists links by when they were created
+	SortCreated = "created"
+	// SortClicks lists links by how often they were clicked
+	SortClicks = "clicks"
+)
+
+const (
+	// DefaultListLimit is how many links a page holds when the request does not say
+	DefaultListLimit = 50
+	// maxListLimit bounds the links of one page
+	maxListLimit = 200
+)
+
+// URLFilter is a struct that selects which links ListURLs returns and in which order
+type URLFilter struct {
+	Domain string
+	// Host only selects links to this destination host
+	Host string
+	// Contains only selects links whose destination or slug contains it, ignoring case
+	Contains string
+	// Tag only selects links with this tag
+	Tag string
+	// CreatedSince and CreatedBefore bound when the links were created, nil for no bound
+	CreatedSince  *time.Time
+	CreatedBefore *time.Time
+	// Sort is SortCreated or SortClicks, newest or most clicked first unless Ascending is set.
+	// Links that sort the same are ordered by ID.
+	Sort      string
+	Ascending bool
+	// After continues the listing after this link
+	After *URLCursor
+	Limit int
+}
+
+// URLCursor is a struct that holds the sort key of the last link of a page
+type URLCursor struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Clicks    int64     `json:"clicks,omitempty"`
+}
+
+// pageCursor is the content of the opaque cursor handed to clients. It remembers the order it was
+// made for, because a position in one order means nothing in another.
+type pageCursor struct {
+	Sort      string `json:"sort"`
+	Ascending bool   `json:"asc,omitempty"`
+	URLCursor
+}
+
+// encodeCursor returns the cursor of the page after link, listed in the order of filter
+func encodeCursor(filter URLFilter, link URLSchema) string {
+	data, _ := json.Marshal(pageCursor{
+		Sort:      filter.Sort,
+		Ascending: filter.Ascending,
+		URLCursor: URLCursor{ID: link.ID, CreatedAt: link.CreatedAt, Clicks: link.Clicks},
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns the position of cursor, which must have been made for the order of filter
+func decodeCursor(filter URLFilter, cursor string) (*URLCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidRequest)
+	}
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidRequest)
+	}
+	if c.Sort != filter.Sort || c.Ascending != filter.Ascending {
+		return nil, fmt.Errorf("%w: the cursor belongs to another sort order", ErrInvalidRequest)
+	}
+	return &c.URLCursor, nil
+}
+
+// ListLinks returns a page of the links matching filter, continuing after cursor unless it is
+// empty, and the cursor of the next page, which is empty on the last page
+func (s *Service) ListLinks(ctx context.Context, filter URLFilter, cursor string) ([]URLSchema, string, error) {
+	if filter.Sort == "" {
+		filter.Sort = SortCreated
+	}
+	if filter.Sort != SortCreated && filter.Sort != SortClicks {
+		return nil, "", fieldError("sort", fmt.Errorf("%w: sort must be %s or %s", ErrInvalidRequest, SortCreated, SortClicks))
+	}
+	if filter.Limit == 0 {
+		filter.Limit = DefaultListLimit
+	}
+	if filter.Limit < 0 || filter.Limit > maxListLimit {
+		return nil, "", fieldError("limit", fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidRequest, maxListLimit))
+	}
+	if filter.Tag != "" {
+		tags, err := normalizeTags([]string{filter.Tag})
+		if err != nil {
+			return nil, "", fieldError("tag", err)
+		}
+		filter.Tag = tags[0]
+	}
+	if cursor != "" {
+		after, err := decodeCursor(filter, cursor)
+		if err != nil {
+			return nil, "", fieldError("cursor", err)
+		}
+		filter.After = after
+	}
+
+	// One more link than asked for tells whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	links, err := s.repo.ListURLs(ctx, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(links) <= limit {
+		return links, "", nil
+	}
+
+	links = links[:limit]
+	return links, encodeCursor(filter, links[limit-1]), nil
+}
@@ -0,0 +1,47 @@
+package urlshortener
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListLinks(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{})
+	for i := 0; i < 4; i++ {
+		_, err := svc.Shorten(ctx, URLRequest{URL: fmt.Sprintf("http://example.com/%d", i)})
+		require.NoError(t, err)
+	}
+
+	// A page that ends with the last link has no next page
+	links, next, err := svc.ListLinks(ctx, URLFilter{Limit: 4}, "")
+	require.NoError(t, err)
+	assert.Len(t, links, 4)
+	assert.Empty(t, next)
+
+	links, next, err = svc.ListLinks(ctx, URLFilter{Sort: SortClicks, Limit: 3}, "")
+	require.NoError(t, err)
+	assert.Len(t, links, 3)
+	require.NotEmpty(t, next)
+
+	links, next, err = svc.ListLinks(ctx, URLFilter{Sort: SortClicks, Limit: 3}, next)
+	require.NoError(t, err)
+	assert.Len(t, links, 1)
+	assert.Empty(t, next)
+
+	// The cursor of one order is rejected by another
+	_, cursor, err := svc.ListLinks(ctx, URLFilter{Limit: 1}, "")
+	require.NoError(t, err)
+	_, _, err = svc.ListLinks(ctx, URLFilter{Sort: SortClicks, Limit: 1}, cursor)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	var fieldErr *FieldError
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "cursor", fieldErr.Field)
+
+	_, _, err = svc.ListLinks(ctx, URLFilter{Tag: "not a tag"}, "")
+	assert.ErrorIs(t, err, ErrInvalidTag)
+}
@@ -0,0 +1,489 @@
+package urlshortener
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryURLRepository is a struct that represents a URL repository kept in process memory.
+// It is safe for concurrent use and honors the same unique constraints as URLSchema, but its
+// contents are lost when the process exits.
+type MemoryURLRepository struct {
+	mu     sync.RWMutex
+	nextID uint
+	urls   map[uint]*URLSchema
+	slugs  map[string]uint // keyed by scoped(domain, slug)
+	shorts map[string]uint
+	longs  map[string]uint // keyed by scoped(domain, long URL), only holds the URLs that claim it
+	seqs   map[string]uint64
+	clicks map[uint][]time.Time // click times keyed by URL ID
+}
+
+// NewMemoryURLRepository returns an empty in-memory URL repository
+func NewMemoryURLRepository() *MemoryURLRepository {
+	return &MemoryURLRepository{
+		urls:   make(map[uint]*URLSchema),
+		slugs:  make(map[string]uint),
+		shorts: make(map[string]uint),
+		longs:  make(map[string]uint),
+		seqs:   make(map[string]uint64),
+		clicks: make(map[uint][]time.Time),
+	}
+}
+
+func (m *MemoryURLRepository) CreateURL(ctx context.Context, u *URLSchema) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.create(u)
+}
+
+func (m *MemoryURLRepository) CreateURLs(ctx context.Context, urls []*URLSchema, all bool) ([]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := make([]error, len(urls))
+	var failed bool
+	for i, u := range urls {
+		if errs[i] = m.create(u); errs[i] != nil {
+			failed = true
+		}
+	}
+
+	if all && failed {
+		for _, u := range urls {
+			if u.ID != 0 {
+				m.remove(u.ID)
+				u.ID = 0
+			}
+		}
+	}
+	return errs, nil
+}
+
+// create stores u, the caller holds the write lock
+func (m *MemoryURLRepository) create(u *URLSchema) error {
+	// Soft-deleted rows keep their slugs and short URLs, just like the unique indexes in the
+	// database, but only the URLs that claim their long URL hold on to it
+	if _, ok := m.slugs[scoped(u.Domain, u.Slug)]; ok {
+		return fmt.Errorf("%w: %q", ErrSlugTaken, u.Slug)
+	}
+	if _, ok := m.shorts[u.ShortUrl]; ok {
+		return fmt.Errorf("%w: short url %q", ErrSlugTaken, u.ShortUrl)
+	}
+	if _, ok := m.longs[scoped(u.Domain, u.LongUrl)]; ok && claimsLongURL(u) {
+		return fmt.Errorf("%w: long url %q", ErrDuplicateURL, u.LongUrl)
+	}
+
+	m.nextID++
+	now := time.Now()
+	u.ID = m.nextID
+	u.LongUrlHash = hashLongURL(u.LongUrl)
+	u.LongUrlHost = longURLHost(u.LongUrl)
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = now
+	}
+	if u.UpdatedAt.IsZero() {
+		u.UpdatedAt = now
+	}
+
+	stored := *u
+	stored.Tags = copyTags(u.Tags)
+	m.urls[u.ID] = &stored
+	m.slugs[scoped(u.Domain, u.Slug)] = u.ID
+	m.shorts[u.ShortUrl] = u.ID
+	if claimsLongURL(u) {
+		m.longs[scoped(u.Domain, u.LongUrl)] = u.ID
+	}
+	return nil
+}
+
+func (m *MemoryURLRepository) ReadURL(ctx context.Context, domain, longURL string) (*URLSchema, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lookup(m.byLongURL(domain, longURL)), nil
+}
+
+func (m *MemoryURLRepository) ReadURLBySlug(ctx context.Context, domain, slug string) (*URLSchema, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lookup(m.slugs[scoped(domain, slug)]), nil
+}
+
+func (m *MemoryURLRepository) UpdateURL(ctx context.Context, domain, longURL, newLongURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.byLongURL(domain, longURL)
+	if id == 0 || longURL == newLongURL {
+		return nil
+	}
+	if err := m.move(id, newLongURL); err != nil {
+		return err
+	}
+
+	url := m.urls[id]
+	url.OriginalUrl = newLongURL
+	url.UpdatedAt = time.Now()
+	return nil
+}
+
+func (m *MemoryURLRepository) UpdateLink(ctx context.Context, domain, slug string, update LinkUpdate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, ok := m.slugs[scoped(domain, slug)]
+	if !ok || m.urls[id].DeletedAt != nil {
+		return nil
+	}
+
+	// The long URL is the only change that can be rejected, so it goes first and a rejected
+	// update leaves the URL as it was
+	url := m.urls[id]
+	if update.LongURL != nil {
+		if err := m.move(id, *update.LongURL); err != nil {
+			return err
+		}
+		url.OriginalUrl = update.OriginalURL
+	}
+	if update.Window != nil {
+		url.NotBefore = utcTime(update.Window.NotBefore)
+		url.NotAfter = utcTime(update.Window.NotAfter)
+	}
+	if update.Tags != nil {
+		url.Tags = copyTags(*update.Tags)
+	}
+	url.UpdatedAt = time.Now()
+	return nil
+}
+
+func (m *MemoryURLRepository) DeleteURL(ctx context.Context, domain, longURL string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id := m.byLongURL(domain, longURL); id != 0 {
+		m.softDelete(id, time.Now())
+	}
+	return nil
+}
+
+func (m *MemoryURLRepository) DeleteURLBySlug(ctx context.Context, domain, slug string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id, ok := m.slugs[scoped(domain, slug)]; ok && m.urls[id].DeletedAt == nil {
+		m.softDelete(id, time.Now())
+	}
+	return nil
+}
+
+func (m *MemoryURLRepository) ConsumeClick(ctx context.Context, domain, slug string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, ok := m.slugs[scoped(domain, slug)]
+	if !ok || m.urls[id].DeletedAt != nil {
+		return false, nil
+	}
+
+	url := m.urls[id]
+	if url.ClicksLeft == nil || *url.ClicksLeft <= 0 {
+		return false, nil
+	}
+
+	// The stored counter may be shared with the URL passed to CreateURL, so replace it
+	left := *url.ClicksLeft - 1
+	url.ClicksLeft = &left
+	return true, nil
+}
+
+func (m *MemoryURLRepository) DeleteExpiredURLs(ctx context.Context, before time.Time, purge bool) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var n int64
+	for id, url := range m.urls {
+		if !url.Expired(before) {
+			continue
+		}
+
+		if purge {
+			m.remove(id)
+		} else if url.DeletedAt == nil {
+			m.softDelete(id, now)
+		} else {
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (m *MemoryURLRepository) RecordClicks(ctx context.Context, events []ClickEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, event := range events {
+		m.clicks[event.URLID] = append(m.clicks[event.URLID], event.ClickedAt.UTC())
+		if url, ok := m.urls[event.URLID]; ok {
+			url.Clicks++
+		}
+	}
+	return nil
+}
+
+func (m *MemoryURLRepository) ClickStats(ctx context.Context, urlID uint, since time.Time, bucket time.Duration) (*ClickStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	clicks := m.clicks[urlID]
+	var recent []time.Time
+	for _, t := range clicks {
+		if !t.Before(since) {
+			recent = append(recent, t)
+		}
+	}
+	return &ClickStats{Total: int64(len(clicks)), Buckets: bucketClicks(recent, bucket)}, nil
+}
+
+func (m *MemoryURLRepository) ListURLs(ctx context.Context, filter URLFilter) ([]URLSchema, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	contains := strings.ToLower(filter.Contains)
+	var urls []URLSchema
+	for _, url := range m.urls {
+		switch {
+		case url.DeletedAt != nil || url.Domain != filter.Domain:
+		case filter.Host != "" && url.LongUrlHost != strings.ToLower(filter.Host):
+		case contains != "" && !strings.Contains(strings.ToLower(url.LongUrl), contains) && !strings.Contains(strings.ToLower(url.Slug), contains):
+		case filter.Tag != "" && !hasTag(url.Tags, filter.Tag):
+		case filter.CreatedSince != nil && url.CreatedAt.Before(*filter.CreatedSince):
+		case filter.CreatedBefore != nil && !url.CreatedAt.Before(*filter.CreatedBefore):
+		case filter.After != nil && !sortsAfter(filter, url, filter.After):
+		default:
+			u := *url
+			u.Tags = copyTags(url.Tags)
+			urls = append(urls, u)
+		}
+	}
+
+	sort.Slice(urls, func(i, j int) bool {
+		return sortsAfter(filter, &urls[j], &URLCursor{ID: urls[i].ID, CreatedAt: urls[i].CreatedAt, Clicks: urls[i].Clicks})
+	})
+	if len(urls) > filter.Limit {
+		urls = urls[:filter.Limit]
+	}
+	return urls, nil
+}
+
+func (m *MemoryURLRepository) ExportURLs(ctx context.Context, afterID uint, limit int, deleted bool) ([]URLSchema, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var urls []URLSchema
+	for id, url := range m.urls {
+		if id > afterID && (deleted || url.DeletedAt == nil) {
+			u := *url
+			u.Tags = copyTags(url.Tags)
+			urls = append(urls, u)
+		}
+	}
+
+	sort.Slice(urls, func(i, j int) bool {
+		return urls[i].ID < urls[j].ID
+	})
+	if len(urls) > limit {
+		urls = urls[:limit]
+	}
+	return urls, nil
+}
+
+func (m *MemoryURLRepository) NextSequence(ctx context.Context, name string) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seqs[name]++
+	return m.seqs[name], nil
+}
+
+// lookup returns a copy of the live URL with the given ID, or nil if there is none
+func (m *MemoryURLRepository) lookup(id uint) *URLSchema {
+	stored, ok := m.urls[id]
+	if !ok || stored.DeletedAt != nil {
+		return nil
+	}
+
+	url := *stored
+	url.Tags = copyTags(url.Tags)
+	return &url
+}
+
+// claimsLongURL reports whether u holds on to its long URL, like the partial unique index in the
+// database: only one live URL without a click limit or an expiry may be stored for a long URL
+func claimsLongURL(u *URLSchema) bool {
+	return u.DeletedAt == nil && u.ClicksLeft == nil && u.ExpiresAt == nil
+}
+
+// byLongURL returns the ID of the URL ReadURL returns for longURL, the one that claims it or else
+// the newest, or 0 if there is none. The caller holds the lock.
+func (m *MemoryURLRepository) byLongURL(domain, longURL string) uint {
+	if id, ok := m.longs[scoped(domain, longURL)]; ok {
+		return id
+	}
+
+	var newest uint
+	for id, url := range m.urls {
+		if id > newest && url.DeletedAt == nil && url.Domain == domain && url.LongUrl == longURL {
+			newest = id
+		}
+	}
+	return newest
+}
+
+// move points the URL with the given ID at longURL, unless another URL claims it, the caller holds the write lock
+func (m *MemoryURLRepository) move(id uint, longURL string) error {
+	url := m.urls[id]
+	if claimsLongURL(url) && url.LongUrl != longURL {
+		if _, ok := m.longs[scoped(url.Domain, longURL)]; ok {
+			return fmt.Errorf("%w: long url %q", ErrDuplicateURL, longURL)
+		}
+		delete(m.longs, scoped(url.Domain, url.LongUrl))
+		m.longs[scoped(url.Domain, longURL)] = id
+	}
+
+	url.LongUrl = longURL
+	url.LongUrlHash = hashLongURL(longURL)
+	url.LongUrlHost = longURLHost(longURL)
+	return nil
+}
+
+// softDelete marks the URL with the given ID deleted at now, freeing its long URL but not its slug.
+// The caller holds the write lock.
+func (m *MemoryURLRepository) softDelete(id uint, now time.Time) {
+	url := m.urls[id]
+	url.DeletedAt = &now
+	if m.longs[scoped(url.Domain, url.LongUrl)] == id {
+		delete(m.longs, scoped(url.Domain, url.LongUrl))
+	}
+}
+
+// remove removes the URL with the given ID and its clicks, freeing its slug, the caller holds the write lock
+func (m *MemoryURLRepository) remove(id uint) {
+	url := m.urls[id]
+	delete(m.urls, id)
+	delete(m.slugs, scoped(url.Domain, url.Slug))
+	delete(m.shorts, url.ShortUrl)
+	if m.longs[scoped(url.Domain, url.LongUrl)] == id {
+		delete(m.longs, scoped(url.Domain, url.LongUrl))
+	}
+	delete(m.clicks, id)
+}
+
+// sortsAfter reports whether url comes after the cursor in the order of filter
+func sortsAfter(filter URLFilter, url *URLSchema, cursor *URLCursor) bool {
+	var order int
+	if filter.Sort == SortClicks {
+		order = cmp.Compare(url.Clicks, cursor.Clicks)
+	} else {
+		order = url.CreatedAt.Compare(cursor.CreatedAt)
+	}
+	if order == 0 {
+		order = cmp.Compare(url.ID, cursor.ID)
+	}
+	if filter.Ascending {
+		return order > 0
+	}
+	return order < 0
+}
+
+// hasTag reports whether tags contains tag
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// copyTags returns a copy of tags, so stored URLs do not share them with callers
+func copyTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	return append([]string(nil), tags...)
+}
+
+// scoped returns the index key of a slug or long URL within a domain
+func scoped(domain, key string) string {
+	return domain + "\x00" + key
+}
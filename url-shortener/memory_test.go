@@ -0,0 +1,180 @@
+package urlshortener
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCreateURL(t *testing.T) {
+	ctx := context.Background()
+
+	// Create the repository
+	repo := NewMemoryURLRepository()
+
+	// Create the URL schema
+	url := &URLSchema{
+		Slug:     "abc123",
+		ShortUrl: "http://localhost:8080/abc123",
+		LongUrl:  "http://example.com",
+	}
+
+	// Call CreateURL
+	err := repo.CreateURL(ctx, url)
+	assert.NoError(t, err)
+
+	// Check that the model fields were filled in
+	assert.NotZero(t, url.ID)
+	assert.False(t, url.CreatedAt.IsZero())
+
+	// A duplicate slug, short URL or long URL is rejected
+	err = repo.CreateURL(ctx, &URLSchema{Slug: "abc123", ShortUrl: "http://localhost:8080/other", LongUrl: "http://example.org"})
+	assert.ErrorIs(t, err, ErrDuplicateURL)
+
+	err = repo.CreateURL(ctx, &URLSchema{Slug: "other", ShortUrl: "http://localhost:8080/abc123", LongUrl: "http://example.org"})
+	assert.ErrorIs(t, err, ErrDuplicateURL)
+
+	err = repo.CreateURL(ctx, &URLSchema{Slug: "other", ShortUrl: "http://localhost:8080/other", LongUrl: "http://example.com"})
+	assert.ErrorIs(t, err, ErrDuplicateURL)
+}
+
+func TestMemoryReadURL(t *testing.T) {
+	ctx := context.Background()
+
+	// Create the repository
+	repo := NewMemoryURLRepository()
+
+	// Create the URL schema
+	err := repo.CreateURL(ctx, &URLSchema{
+		Slug:     "abc123",
+		ShortUrl: "http://localhost:8080/abc123",
+		LongUrl:  "http://example.com",
+	})
+	assert.NoError(t, err)
+
+	// Call ReadURL
+	url, err := repo.ReadURL(ctx, "", "http://example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", url.Slug)
+
+	// Call ReadURLBySlug
+	url, err = repo.ReadURLBySlug(ctx, "", "abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com", url.LongUrl)
+
+	// Changing the returned copy does not change the stored URL
+	url.LongUrl = "http://changed.com"
+	url, err = repo.ReadURLBySlug(ctx, "", "abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com", url.LongUrl)
+
+	// Unknown URLs are not an error
+	url, err = repo.ReadURL(ctx, "", "http://missing.com")
+	assert.NoError(t, err)
+	assert.Nil(t, url)
+}
+
+func TestMemoryUpdateURL(t *testing.T) {
+	ctx := context.Background()
+
+	// Create the repository
+	repo := NewMemoryURLRepository()
+
+	for _, u := range []*URLSchema{
+		{Slug: "abc123", ShortUrl: "http://localhost:8080/abc123", LongUrl: "http://example.com"},
+		{Slug: "def456", ShortUrl: "http://localhost:8080/def456", LongUrl: "http://example.net"},
+	} {
+		assert.NoError(t, repo.CreateURL(ctx, u))
+	}
+
+	// Call UpdateURL
+	err := repo.UpdateURL(ctx, "", "http://example.com", "http://example.org")
+	assert.NoError(t, err)
+
+	// The URL is found under its new long URL only
+	url, err := repo.ReadURL(ctx, "", "http://example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", url.Slug)
+
+	url, err = repo.ReadURL(ctx, "", "http://example.com")
+	assert.NoError(t, err)
+	assert.Nil(t, url)
+
+	// Updating onto a long URL that is already taken is rejected
+	err = repo.UpdateURL(ctx, "", "http://example.org", "http://example.net")
+	assert.ErrorIs(t, err, ErrDuplicateURL)
+}
+
+func TestMemoryDeleteURL(t *testing.T) {
+	ctx := context.Background()
+
+	// Create the repository
+	repo := NewMemoryURLRepository()
+
+	err := repo.CreateURL(ctx, &URLSchema{
+		Slug:     "abc123",
+		ShortUrl: "http://localhost:8080/abc123",
+		LongUrl:  "http://example.com",
+	})
+	assert.NoError(t, err)
+
+	// Call DeleteURL
+	err = repo.DeleteURL(ctx, "", "http://example.com")
+	assert.NoError(t, err)
+
+	// The URL can no longer be read
+	url, err := repo.ReadURLBySlug(ctx, "", "abc123")
+	assert.NoError(t, err)
+	assert.Nil(t, url)
+
+	// Like a soft delete in the database, the slug stays taken
+	err = repo.CreateURL(ctx, &URLSchema{Slug: "abc123", ShortUrl: "http://localhost:8080/new", LongUrl: "http://example.org"})
+	assert.ErrorIs(t, err, ErrDuplicateURL)
+}
+
+func TestMemoryConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+
+	// Create the repository
+	repo := NewMemoryURLRepository()
+
+	// Many goroutines race to claim the same slug
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repo.CreateURL(ctx, &URLSchema{
+				Slug:     "abc123",
+				ShortUrl: fmt.Sprintf("http://localhost:8080/%d", i),
+				LongUrl:  fmt.Sprintf("http://example.com/%d", i),
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	// Exactly one of them wins
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		}
+	}
+	assert.Equal(t, 1, created)
+}
+
+func TestNewURLRepository(t *testing.T) {
+	// The memory driver needs no DSN
+	repo, err := NewURLRepository(DatabaseConfig{Driver: DriverMemory})
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryURLRepository{}, repo)
+
+	// Unknown drivers are rejected
+	_, err = NewURLRepository(DatabaseConfig{Driver: "mysql"})
+	assert.Error(t, err)
+}
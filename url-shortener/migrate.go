@@ -0,0 +1,379 @@
+package urlshortener
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationFileName matches files such as migrations/sqlite3/0002_long_url_hash.up.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationLockID is the PostgreSQL advisory lock taken while migrating, so instances
+// starting at the same time apply each migration exactly once
+const migrationLockID = 7_267_372
+
+// migrationHooks holds data changes that cannot be written in portable SQL. A hook runs in
+// the same transaction, right after the up file of the migration with the same version.
+var migrationHooks = map[int]func(tx *gorm.DB) error{
+	2:  backfillLongURLHashes,
+	5:  canonicalizeLongURLs,
+	12: backfillLongURLHosts,
+}
+
+// migration is a struct that represents one versioned schema change
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a struct that represents a migration and whether it has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// loadMigrations reads the embedded migrations for a dialect, ordered by version
+func loadMigrations(dialect string) ([]migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %s: %w", dialect, err)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		data, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// MigrateUp applies every migration newer than the current schema version
+func (s *SQLURLRepository) MigrateUp(ctx context.Context) error {
+	migrations, err := loadMigrations(s.db.Dialect().GetName())
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		err := s.inMigration(ctx, func(tx *gorm.DB, current int) error {
+			if m.Version <= current {
+				return nil
+			}
+
+			if err := tx.Exec(m.Up).Error; err != nil {
+				return err
+			}
+			if hook, ok := migrationHooks[m.Version]; ok {
+				if err := hook(tx); err != nil {
+					return err
+				}
+			}
+			return tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().UTC()).Error
+		})
+		if err != nil {
+			return fmt.Errorf("error applying migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown reverts the given number of most recently applied migrations
+func (s *SQLURLRepository) MigrateDown(ctx context.Context, steps int) error {
+	migrations, err := loadMigrations(s.db.Dialect().GetName())
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < steps; i++ {
+		var reverted bool
+		err := s.inMigration(ctx, func(tx *gorm.DB, current int) error {
+			if current == 0 {
+				return nil
+			}
+
+			for _, m := range migrations {
+				if m.Version != current {
+					continue
+				}
+				if err := tx.Exec(m.Down).Error; err != nil {
+					return fmt.Errorf("error reverting migration %d_%s: %w", m.Version, m.Name, err)
+				}
+				reverted = true
+				return tx.Exec("DELETE FROM schema_version WHERE version = ?", m.Version).Error
+			}
+			return fmt.Errorf("schema version %d is not a known migration", current)
+		})
+		if err != nil {
+			return err
+		}
+		if !reverted {
+			break
+		}
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the version of the most recently applied migration, or 0 for an empty database
+func (s *SQLURLRepository) SchemaVersion(ctx context.Context) (int, error) {
+	db := s.withContext(ctx)
+	if !db.HasTable("schema_version") {
+		return 0, nil
+	}
+	return currentSchemaVersion(db)
+}
+
+// MigrationStatus lists every known migration along with when it was applied
+func (s *SQLURLRepository) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(s.db.Dialect().GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(s.withContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			st.AppliedAt = &at
+		}
+		status = append(status, st)
+	}
+	return status, nil
+}
+
+// appliedMigrations returns when each applied migration was applied, by version. A database that
+// was never migrated has no schema_version table yet, which is left to MigrateUp to create.
+func appliedMigrations(db *gorm.DB) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	if !db.HasTable("schema_version") {
+		return applied, nil
+	}
+
+	rows, err := db.Raw("SELECT version, applied_at FROM schema_version").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// inMigration runs fn in a transaction that holds the migration lock and sees the current schema version
+func (s *SQLURLRepository) inMigration(ctx context.Context, fn func(tx *gorm.DB, current int) error) error {
+	tx := s.withContext(ctx).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if tx.Dialect().GetName() == DriverPostgres {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// Create the version table and read the version only after taking the lock, another instance
+	// may be doing the same or may have migrated in the meantime
+	if err := createSchemaVersionTable(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	current, err := currentSchemaVersion(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := fn(tx, current); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// backfillLongURLHashes fills in long_url_hash for rows stored before the column existed
+func backfillLongURLHashes(tx *gorm.DB) error {
+	rows, err := tx.Raw("SELECT id, long_url FROM url_schemas WHERE long_url_hash IS NULL").Rows()
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[uint]string)
+	for rows.Next() {
+		var id uint
+		var longURL string
+		if err := rows.Scan(&id, &longURL); err != nil {
+			rows.Close()
+			return err
+		}
+		hashes[id] = hashLongURL(longURL)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, hash := range hashes {
+		if err := tx.Exec("UPDATE url_schemas SET long_url_hash = ? WHERE id = ?", hash, id).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canonicalizeLongURLs stores existing long URLs in canonical form, so they are found by the
+// canonical lookups. A URL whose canonical form is already taken in its domain is left as it is.
+func canonicalizeLongURLs(tx *gorm.DB) error {
+	rows, err := tx.Raw("SELECT id, domain, long_url, long_url_hash FROM url_schemas").Rows()
+	if err != nil {
+		return err
+	}
+
+	type row struct {
+		id      uint
+		domain  string
+		longURL string
+		hash    string
+	}
+	var stored []row
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.domain, &r.longURL, &r.hash); err != nil {
+			rows.Close()
+			return err
+		}
+		stored = append(stored, r)
+		taken[scoped(r.domain, r.hash)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range stored {
+		canonical, err := canonicalizeURL(r.longURL, nil)
+		if err != nil || canonical == r.longURL {
+			continue
+		}
+		hash := hashLongURL(canonical)
+		if taken[scoped(r.domain, hash)] {
+			continue
+		}
+
+		if err := tx.Exec("UPDATE url_schemas SET long_url = ?, long_url_hash = ? WHERE id = ?", canonical, hash, r.id).Error; err != nil {
+			return err
+		}
+		delete(taken, scoped(r.domain, r.hash))
+		taken[scoped(r.domain, hash)] = true
+	}
+	return nil
+}
+
+// backfillLongURLHosts stores the destination host of existing links, so they are listed by host
+func backfillLongURLHosts(tx *gorm.DB) error {
+	rows, err := tx.Raw("SELECT id, long_url FROM url_schemas WHERE long_url_host IS NULL").Rows()
+	if err != nil {
+		return err
+	}
+
+	hosts := make(map[uint]string)
+	for rows.Next() {
+		var id uint
+		var longURL string
+		if err := rows.Scan(&id, &longURL); err != nil {
+			rows.Close()
+			return err
+		}
+		hosts[id] = longURLHost(longURL)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, host := range hosts {
+		if err := tx.Exec("UPDATE url_schemas SET long_url_host = ? WHERE id = ?", host, id).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createSchemaVersionTable(db *gorm.DB) error {
+	return db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version integer PRIMARY KEY,
+		name varchar(255) NOT NULL,
+		applied_at timestamp NOT NULL
+	)`).Error
+}
+
+func currentSchemaVersion(db *gorm.DB) (int, error) {
+	var version int
+	if err := db.Raw("SELECT COALESCE(MAX(version), 0) FROM schema_version").Row().Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
@@ -0,0 +1,161 @@
+package urlshortener
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, dialect := range []string{DriverSQLite, DriverPostgres} {
+		migrations, err := loadMigrations(dialect)
+		assert.NoError(t, err)
+
+		// Versions start at 1 and have no gaps
+		for i, m := range migrations {
+			assert.Equal(t, i+1, m.Version, "%s migration %s", dialect, m.Name)
+			assert.NotEmpty(t, m.Up)
+			assert.NotEmpty(t, m.Down)
+		}
+	}
+
+	// Both dialects have the same migrations
+	sqlite, _ := loadMigrations(DriverSQLite)
+	postgres, _ := loadMigrations(DriverPostgres)
+	assert.Equal(t, len(sqlite), len(postgres))
+	for i := range sqlite {
+		assert.Equal(t, sqlite[i].Name, postgres[i].Name)
+	}
+
+	// Unknown dialects have no migrations
+	_, err := loadMigrations("mysql")
+	assert.Error(t, err)
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	ctx := context.Background()
+
+	// Open an empty database
+	repo, err := NewSQLURLRepository(DriverSQLite, t.TempDir()+"/url.db")
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	defer repo.Close()
+
+	latest, err := loadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	version, err := repo.SchemaVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	// Apply every migration
+	err = repo.MigrateUp(ctx)
+	assert.NoError(t, err)
+
+	version, err = repo.SchemaVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(latest), version)
+	assert.True(t, repo.db.HasTable(&URLSchema{}))
+
+	// Applying again is a no-op
+	err = repo.MigrateUp(ctx)
+	assert.NoError(t, err)
+
+	// Every migration is reported as applied
+	status, err := repo.MigrationStatus(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, status, len(latest))
+	for _, st := range status {
+		assert.NotNil(t, st.AppliedAt, "migration %d", st.Version)
+	}
+
+	// The migrated schema can store URLs
+	err = repo.CreateURL(ctx, &URLSchema{Slug: "abc123", ShortUrl: "http://localhost:8080/abc123", LongUrl: "http://example.com"})
+	assert.NoError(t, err)
+
+	// Revert one migration at a time back to an empty database
+	for v := len(latest) - 1; v >= 0; v-- {
+		err = repo.MigrateDown(ctx, 1)
+		assert.NoError(t, err)
+
+		version, err = repo.SchemaVersion(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, v, version)
+	}
+	assert.False(t, repo.db.HasTable(&URLSchema{}))
+
+	// Reverting an empty database is a no-op
+	err = repo.MigrateDown(ctx, 1)
+	assert.NoError(t, err)
+
+	// And everything can be applied again
+	err = repo.MigrateUp(ctx)
+	assert.NoError(t, err)
+	assert.True(t, repo.db.HasTable(&URLSchema{}))
+}
+
+func TestMigrateAdoptsAutoMigratedDatabase(t *testing.T) {
+	ctx := context.Background()
+	dsn := t.TempDir() + "/url.db"
+
+	// Create a database the way earlier releases did
+	db, err := gorm.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.AutoMigrate(&legacyURLSchema{})
+	db.Create(&legacyURLSchema{Slug: "abc123", ShortUrl: "http://localhost:8080/abc123", LongUrl: "http://example.com"})
+	db.Close()
+
+	// Migrating keeps the existing links
+	repo, err := NewSQLURLRepository(DriverSQLite, dsn)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	defer repo.Close()
+
+	err = repo.MigrateUp(ctx)
+	assert.NoError(t, err)
+
+	url, err := repo.ReadURLBySlug(ctx, "", "abc123")
+	assert.NoError(t, err)
+	if assert.NotNil(t, url) {
+		assert.Equal(t, "http://example.com/", url.LongUrl)
+		assert.Equal(t, "http://example.com", url.OriginalUrl)
+	}
+
+	// Existing links got a hash of their canonical long URL, so they are still found by long URL
+	url, err = repo.ReadURL(ctx, "", "http://example.com/")
+	assert.NoError(t, err)
+	if assert.NotNil(t, url) {
+		assert.Equal(t, hashLongURL("http://example.com/"), url.LongUrlHash)
+		assert.Equal(t, "example.com", url.LongUrlHost, "existing links should be listed by host")
+	}
+
+	// Reverting the latest migration keeps the links as well
+	err = repo.MigrateDown(ctx, 1)
+	assert.NoError(t, err)
+	err = repo.MigrateUp(ctx)
+	assert.NoError(t, err)
+
+	url, err = repo.ReadURL(ctx, "", "http://example.com/")
+	assert.NoError(t, err)
+	assert.NotNil(t, url)
+}
+
+// legacyURLSchema is URLSchema as it was when the schema was created with AutoMigrate
+type legacyURLSchema struct {
+	gorm.Model
+	Slug     string `gorm:"type:varchar(100);unique_index"`
+	ShortUrl string `gorm:"type:varchar(100);unique_index"`
+	LongUrl  string `gorm:"type:varchar(100);unique_index"`
+}
+
+func (legacyURLSchema) TableName() string {
+	return "url_schemas"
+}
@@ -0,0 +1,5 @@
+-- Fails if any stored long URL no longer fits in 100 characters.
+DROP INDEX IF EXISTS uix_url_schemas_long_url_hash;
+ALTER TABLE "url_schemas" DROP COLUMN "long_url_hash";
+ALTER TABLE "url_schemas" ALTER COLUMN "long_url" TYPE varchar(100);
+CREATE UNIQUE INDEX uix_url_schemas_long_url ON "url_schemas"(long_url);
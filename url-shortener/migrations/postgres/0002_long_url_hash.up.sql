@@ -0,0 +1,6 @@
+-- Long URLs move to text and uniqueness moves to long_url_hash. The hashes of
+-- existing rows are filled in by the migration's Go hook.
+ALTER TABLE "url_schemas" ALTER COLUMN "long_url" TYPE text;
+ALTER TABLE "url_schemas" ADD COLUMN "long_url_hash" char(64);
+DROP INDEX IF EXISTS uix_url_schemas_long_url;
+CREATE UNIQUE INDEX uix_url_schemas_long_url_hash ON "url_schemas"(long_url_hash);
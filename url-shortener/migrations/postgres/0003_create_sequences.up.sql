@@ -0,0 +1,5 @@
+-- Named counters shared by every instance, used by the sequential and hashids slug strategies.
+CREATE TABLE "sequences" (
+    "name" varchar(100) primary key,
+    "value" bigint NOT NULL
+);
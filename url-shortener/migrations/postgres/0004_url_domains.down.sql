@@ -0,0 +1,6 @@
+-- Fails if two domains share a slug or a long URL.
+DROP INDEX IF EXISTS uix_url_schemas_domain_slug;
+DROP INDEX IF EXISTS uix_url_schemas_domain_long_url_hash;
+ALTER TABLE "url_schemas" DROP COLUMN "domain";
+CREATE UNIQUE INDEX uix_url_schemas_slug ON "url_schemas"(slug);
+CREATE UNIQUE INDEX uix_url_schemas_long_url_hash ON "url_schemas"(long_url_hash);
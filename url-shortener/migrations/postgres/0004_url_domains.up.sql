@@ -0,0 +1,8 @@
+-- Short URLs can be minted under several branded domains, so slugs and long
+-- URLs become unique per domain. Existing rows belong to the primary domain,
+-- which is stored as an empty string.
+ALTER TABLE "url_schemas" ADD COLUMN "domain" varchar(255) NOT NULL DEFAULT '';
+DROP INDEX IF EXISTS uix_url_schemas_slug;
+DROP INDEX IF EXISTS uix_url_schemas_long_url_hash;
+CREATE UNIQUE INDEX uix_url_schemas_domain_slug ON "url_schemas"(domain, slug);
+CREATE UNIQUE INDEX uix_url_schemas_domain_long_url_hash ON "url_schemas"(domain, long_url_hash);
@@ -0,0 +1,2 @@
+-- Links keep their canonical long URL.
+ALTER TABLE "url_schemas" DROP COLUMN "original_url";
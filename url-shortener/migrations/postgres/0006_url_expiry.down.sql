@@ -0,0 +1,3 @@
+-- Links that have not been swept yet no longer expire.
+DROP INDEX IF EXISTS idx_url_schemas_expires_at;
+ALTER TABLE "url_schemas" DROP COLUMN "expires_at";
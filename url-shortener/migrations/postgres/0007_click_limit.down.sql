@@ -0,0 +1,2 @@
+-- Links that have clicks left no longer have a limit.
+ALTER TABLE "url_schemas" DROP COLUMN "clicks_left";
@@ -0,0 +1,2 @@
+-- Password-protected links become public.
+ALTER TABLE "url_schemas" DROP COLUMN "password_hash";
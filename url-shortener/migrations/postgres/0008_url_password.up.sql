@@ -0,0 +1,2 @@
+-- Password-protected links store the bcrypt hash of their password; NULL or empty means public.
+ALTER TABLE "url_schemas" ADD COLUMN "password_hash" varchar(100);
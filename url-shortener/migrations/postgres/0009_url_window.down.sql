@@ -0,0 +1,3 @@
+-- Scheduled links redirect at any time.
+ALTER TABLE "url_schemas" DROP COLUMN "not_before";
+ALTER TABLE "url_schemas" DROP COLUMN "not_after";
@@ -0,0 +1,3 @@
+-- Scheduled links only redirect between not_before and not_after; NULL leaves that side open.
+ALTER TABLE "url_schemas" ADD COLUMN "not_before" timestamp with time zone;
+ALTER TABLE "url_schemas" ADD COLUMN "not_after" timestamp with time zone;
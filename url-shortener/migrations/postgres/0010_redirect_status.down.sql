@@ -0,0 +1,2 @@
+-- Links redirect with the server default.
+ALTER TABLE "url_schemas" DROP COLUMN "redirect_status";
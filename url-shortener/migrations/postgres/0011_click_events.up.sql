@@ -0,0 +1,11 @@
+-- One row per redirect, for per-link statistics. Purging a link purges its clicks.
+CREATE TABLE "click_events" (
+    "id" bigserial primary key,
+    "url_id" integer NOT NULL REFERENCES "url_schemas"(id) ON DELETE CASCADE,
+    "clicked_at" timestamp with time zone NOT NULL,
+    "referrer" text,
+    "user_agent" text,
+    "country" varchar(2),
+    "ip" varchar(45)
+);
+CREATE INDEX idx_click_events_url_id_clicked_at ON "click_events"(url_id, clicked_at);
@@ -0,0 +1,7 @@
+-- Links can only be looked up one at a time.
+DROP TABLE IF EXISTS "url_tags";
+DROP INDEX IF EXISTS idx_url_schemas_domain_created_at;
+DROP INDEX IF EXISTS idx_url_schemas_domain_clicks;
+DROP INDEX IF EXISTS idx_url_schemas_domain_long_url_host;
+ALTER TABLE "url_schemas" DROP COLUMN "clicks";
+ALTER TABLE "url_schemas" DROP COLUMN "long_url_host";
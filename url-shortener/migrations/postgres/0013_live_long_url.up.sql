@@ -0,0 +1,5 @@
+-- Only live links without a click limit or an expiry hold on to their destination. Limited links
+-- can be added next to them, and deleted ones, such as those the sweeper removes, free it for new
+-- links while their slugs stay taken.
+DROP INDEX IF EXISTS uix_url_schemas_domain_long_url_hash;
+CREATE UNIQUE INDEX uix_url_schemas_domain_long_url_hash ON "url_schemas"(domain, long_url_hash) WHERE deleted_at IS NULL AND clicks_left IS NULL AND expires_at IS NULL;
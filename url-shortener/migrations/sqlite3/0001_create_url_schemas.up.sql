@@ -0,0 +1,15 @@
+-- Matches the table gorm's AutoMigrate created before versioned migrations,
+-- so existing url.db files are adopted as version 1 without changes.
+CREATE TABLE IF NOT EXISTS "url_schemas" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    "slug" varchar(100),
+    "short_url" varchar(100),
+    "long_url" varchar(100)
+);
+CREATE INDEX IF NOT EXISTS idx_url_schemas_deleted_at ON "url_schemas"(deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_url_schemas_slug ON "url_schemas"(slug);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_url_schemas_short_url ON "url_schemas"(short_url);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_url_schemas_long_url ON "url_schemas"(long_url);
@@ -0,0 +1,21 @@
+-- SQLite cannot change a column type in place, so the table is rebuilt with
+-- long_url as text and uniqueness moved to long_url_hash. The hashes of
+-- existing rows are filled in by the migration's Go hook.
+CREATE TABLE "url_schemas_new" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    "slug" varchar(100),
+    "short_url" varchar(100),
+    "long_url" text,
+    "long_url_hash" char(64)
+);
+INSERT INTO "url_schemas_new" (id, created_at, updated_at, deleted_at, slug, short_url, long_url)
+    SELECT id, created_at, updated_at, deleted_at, slug, short_url, long_url FROM "url_schemas";
+DROP TABLE "url_schemas";
+ALTER TABLE "url_schemas_new" RENAME TO "url_schemas";
+CREATE INDEX idx_url_schemas_deleted_at ON "url_schemas"(deleted_at);
+CREATE UNIQUE INDEX uix_url_schemas_slug ON "url_schemas"(slug);
+CREATE UNIQUE INDEX uix_url_schemas_short_url ON "url_schemas"(short_url);
+CREATE UNIQUE INDEX uix_url_schemas_long_url_hash ON "url_schemas"(long_url_hash);
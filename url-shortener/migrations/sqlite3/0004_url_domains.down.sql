@@ -0,0 +1,20 @@
+-- SQLite cannot drop a column, so the table is rebuilt without domain. Fails
+-- if two domains share a slug or a long URL.
+CREATE TABLE "url_schemas_old" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    "slug" varchar(100),
+    "short_url" varchar(100),
+    "long_url" text,
+    "long_url_hash" char(64)
+);
+INSERT INTO "url_schemas_old" (id, created_at, updated_at, deleted_at, slug, short_url, long_url, long_url_hash)
+    SELECT id, created_at, updated_at, deleted_at, slug, short_url, long_url, long_url_hash FROM "url_schemas";
+DROP TABLE "url_schemas";
+ALTER TABLE "url_schemas_old" RENAME TO "url_schemas";
+CREATE INDEX idx_url_schemas_deleted_at ON "url_schemas"(deleted_at);
+CREATE UNIQUE INDEX uix_url_schemas_slug ON "url_schemas"(slug);
+CREATE UNIQUE INDEX uix_url_schemas_short_url ON "url_schemas"(short_url);
+CREATE UNIQUE INDEX uix_url_schemas_long_url_hash ON "url_schemas"(long_url_hash);
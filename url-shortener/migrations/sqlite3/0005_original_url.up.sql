@@ -0,0 +1,4 @@
+-- Long URLs are stored in canonical form, the URL as submitted is kept in
+-- original_url. Existing long URLs are canonicalized by the migration's Go hook.
+ALTER TABLE "url_schemas" ADD COLUMN "original_url" text;
+UPDATE "url_schemas" SET original_url = long_url;
@@ -0,0 +1,3 @@
+-- Links can expire; expired links answer 410 Gone until the sweeper removes them.
+ALTER TABLE "url_schemas" ADD COLUMN "expires_at" datetime;
+CREATE INDEX idx_url_schemas_expires_at ON "url_schemas"(expires_at);
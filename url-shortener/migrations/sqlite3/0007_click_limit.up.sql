@@ -0,0 +1,2 @@
+-- Click-limited links count down the redirects they have left; NULL means no limit.
+ALTER TABLE "url_schemas" ADD COLUMN "clicks_left" integer;
@@ -0,0 +1,3 @@
+-- Scheduled links only redirect between not_before and not_after; NULL leaves that side open.
+ALTER TABLE "url_schemas" ADD COLUMN "not_before" datetime;
+ALTER TABLE "url_schemas" ADD COLUMN "not_after" datetime;
@@ -0,0 +1,2 @@
+-- Links redirect with their own status; 0 follows the server default.
+ALTER TABLE "url_schemas" ADD COLUMN "redirect_status" integer NOT NULL DEFAULT 0;
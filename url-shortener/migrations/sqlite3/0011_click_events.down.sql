@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS "click_events_purge";
+DROP TABLE IF EXISTS "click_events";
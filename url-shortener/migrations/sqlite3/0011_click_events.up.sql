@@ -0,0 +1,16 @@
+-- One row per redirect, for per-link statistics. Purging a link purges its clicks.
+CREATE TABLE "click_events" (
+    "id" integer primary key autoincrement,
+    "url_id" integer NOT NULL,
+    "clicked_at" datetime NOT NULL,
+    "referrer" text,
+    "user_agent" text,
+    "country" varchar(2),
+    "ip" varchar(45)
+);
+CREATE INDEX idx_click_events_url_id_clicked_at ON "click_events"(url_id, clicked_at);
+-- Foreign keys are not enforced without a pragma, so a trigger does the cascade.
+CREATE TRIGGER click_events_purge AFTER DELETE ON "url_schemas"
+BEGIN
+    DELETE FROM "click_events" WHERE url_id = OLD.id;
+END;
@@ -0,0 +1,36 @@
+-- SQLite cannot drop a column, so the table is rebuilt without long_url_host and clicks.
+-- Links can only be looked up one at a time.
+DROP TRIGGER IF EXISTS "url_tags_purge";
+DROP TABLE IF EXISTS "url_tags";
+CREATE TABLE "url_schemas_old" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    "slug" varchar(100),
+    "short_url" varchar(100),
+    "long_url" text,
+    "long_url_hash" char(64),
+    "domain" varchar(255) NOT NULL DEFAULT '',
+    "original_url" text,
+    "expires_at" datetime,
+    "clicks_left" integer,
+    "password_hash" varchar(100),
+    "not_before" datetime,
+    "not_after" datetime,
+    "redirect_status" integer NOT NULL DEFAULT 0
+);
+INSERT INTO "url_schemas_old" (id, created_at, updated_at, deleted_at, slug, short_url, long_url, long_url_hash, domain, original_url, expires_at, clicks_left, password_hash, not_before, not_after, redirect_status)
+    SELECT id, created_at, updated_at, deleted_at, slug, short_url, long_url, long_url_hash, domain, original_url, expires_at, clicks_left, password_hash, not_before, not_after, redirect_status FROM "url_schemas";
+DROP TABLE "url_schemas";
+ALTER TABLE "url_schemas_old" RENAME TO "url_schemas";
+CREATE INDEX idx_url_schemas_deleted_at ON "url_schemas"(deleted_at);
+CREATE INDEX idx_url_schemas_expires_at ON "url_schemas"(expires_at);
+CREATE UNIQUE INDEX uix_url_schemas_short_url ON "url_schemas"(short_url);
+CREATE UNIQUE INDEX uix_url_schemas_domain_slug ON "url_schemas"(domain, slug);
+CREATE UNIQUE INDEX uix_url_schemas_domain_long_url_hash ON "url_schemas"(domain, long_url_hash);
+-- The rebuilt table lost the trigger of the click events.
+CREATE TRIGGER click_events_purge AFTER DELETE ON "url_schemas"
+BEGIN
+    DELETE FROM "click_events" WHERE url_id = OLD.id;
+END;
@@ -0,0 +1,19 @@
+-- Links are listed by destination host, creation time or clicks, and can be tagged.
+-- long_url_host is filled in by the migration's Go hook, clicks from the recorded click events.
+ALTER TABLE "url_schemas" ADD COLUMN "long_url_host" varchar(255);
+ALTER TABLE "url_schemas" ADD COLUMN "clicks" integer NOT NULL DEFAULT 0;
+UPDATE "url_schemas" SET clicks = (SELECT COUNT(*) FROM "click_events" WHERE url_id = "url_schemas".id);
+CREATE INDEX idx_url_schemas_domain_created_at ON "url_schemas"(domain, created_at, id);
+CREATE INDEX idx_url_schemas_domain_clicks ON "url_schemas"(domain, clicks, id);
+CREATE INDEX idx_url_schemas_domain_long_url_host ON "url_schemas"(domain, long_url_host);
+CREATE TABLE "url_tags" (
+    "url_id" integer NOT NULL,
+    "tag" varchar(32) NOT NULL,
+    PRIMARY KEY (url_id, tag)
+);
+CREATE INDEX idx_url_tags_tag ON "url_tags"(tag, url_id);
+-- Foreign keys are not enforced without a pragma, so a trigger does the cascade.
+CREATE TRIGGER url_tags_purge AFTER DELETE ON "url_schemas"
+BEGIN
+    DELETE FROM "url_tags" WHERE url_id = OLD.id;
+END;
@@ -0,0 +1,4 @@
+-- Fails while a destination has more than one link, live or deleted; purge the deleted links and
+-- delete the extra live ones first.
+DROP INDEX IF EXISTS uix_url_schemas_domain_long_url_hash;
+CREATE UNIQUE INDEX uix_url_schemas_domain_long_url_hash ON "url_schemas"(domain, long_url_hash);
@@ -0,0 +1,142 @@
+package urlshortener
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultUnlockTTL is how long an unlocked link stays unlocked when no cookie TTL is configured
+	DefaultUnlockTTL = time.Hour
+	// DefaultMaxPasswordAttempts is how many wrong passwords a client may try per link and lockout window
+	DefaultMaxPasswordAttempts = 5
+	// DefaultPasswordLockout is the window wrong password attempts are counted in
+	DefaultPasswordLockout = 15 * time.Minute
+)
+
+// ErrInvalidPassword is returned when a requested link password cannot be hashed, such as one longer than 72 bytes
+var ErrInvalidPassword = errors.New("invalid password")
+
+// PasswordConfig is a struct that represents how password-protected links are unlocked
+type PasswordConfig struct {
+	// CookieSecret signs the cookies that remember unlocked links. Instances sharing a database need the
+	// same secret; when it is empty a random one is generated at startup.
+	CookieSecret string `yaml:"cookie_secret"`
+	// CookieTTL is how long an unlocked link stays unlocked
+	CookieTTL time.Duration `yaml:"cookie_ttl"`
+	// MaxAttempts is how many wrong passwords a client may try per link within Lockout
+	MaxAttempts int           `yaml:"max_attempts"`
+	Lockout     time.Duration `yaml:"lockout"`
+}
+
+// hashPassword returns the bcrypt hash stored in URLSchema.PasswordHash
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPassword, err)
+	}
+	return string(hash), nil
+}
+
+// checkPassword reports whether password matches a hash returned by hashPassword
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// signUnlock returns the value of a cookie that unlocks link until expires. The signature covers
+// the password hash, so changing the password locks the link again.
+func signUnlock(key []byte, link *URLSchema, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + unlockMAC(key, link, exp)
+}
+
+// verifyUnlock reports whether value was returned by signUnlock for link and has not expired at now
+func verifyUnlock(key []byte, link *URLSchema, value string, now time.Time) bool {
+	exp, mac, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(unlockMAC(key, link, exp)))
+}
+
+func unlockMAC(key []byte, link *URLSchema, exp string) string {
+	mac := hmac.New(sha256.New, key)
+	for _, field := range []string{link.Domain, link.Slug, link.PasswordHash, exp} {
+		mac.Write([]byte(field))
+		mac.Write([]byte{0})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// attemptLimiter is a struct that counts attempts per key in fixed windows, until one succeeds. It
+// is kept in process memory, so every instance allows its own attempts.
+type attemptLimiter struct {
+	mu        sync.Mutex
+	max       int
+	window    time.Duration
+	attempts  map[string]*attemptWindow
+	lastPrune time.Time
+}
+
+type attemptWindow struct {
+	failures int
+	reset    time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		max:      max,
+		window:   window,
+		attempts: make(map[string]*attemptWindow),
+	}
+}
+
+// Attempt records an attempt by key at now and reports whether key may make it, and if not, how
+// long until it may. The attempt is counted before it is checked, so concurrent attempts cannot get
+// past the limit; Reset forgets it once it succeeds.
+func (l *attemptLimiter) Attempt(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget finished windows now and then, so clients that never come back do not pile up
+	if now.Sub(l.lastPrune) >= l.window {
+		for k, a := range l.attempts {
+			if !now.Before(a.reset) {
+				delete(l.attempts, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	a, ok := l.attempts[key]
+	if !ok || !now.Before(a.reset) {
+		a = &attemptWindow{reset: now.Add(l.window)}
+		l.attempts[key] = a
+	}
+	if a.failures >= l.max {
+		return false, a.reset.Sub(now)
+	}
+	a.failures++
+	return true, 0
+}
+
+// Reset forgets the attempts of key, including the one that just succeeded
+func (l *attemptLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, key)
+}
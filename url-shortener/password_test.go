@@ -0,0 +1,99 @@
+package urlshortener
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword("s3cret")
+	assert.NoError(t, err)
+	assert.NotContains(t, hash, "s3cret")
+	assert.True(t, checkPassword(hash, "s3cret"))
+	assert.False(t, checkPassword(hash, "S3cret"))
+	assert.False(t, checkPassword(hash, ""))
+
+	// bcrypt only looks at the first 72 bytes, so longer passwords are rejected
+	_, err = hashPassword(strings.Repeat("a", 73))
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+}
+
+func TestSignUnlock(t *testing.T) {
+	key := []byte("key")
+	now := time.Now()
+	link := &URLSchema{Slug: "abc123", PasswordHash: "hash"}
+	value := signUnlock(key, link, now.Add(time.Hour))
+
+	assert.True(t, verifyUnlock(key, link, value, now))
+
+	// The cookie expires
+	assert.False(t, verifyUnlock(key, link, value, now.Add(time.Hour)))
+
+	// It only unlocks the link it was issued for, with the password it had
+	assert.False(t, verifyUnlock(key, &URLSchema{Slug: "other", PasswordHash: "hash"}, value, now))
+	assert.False(t, verifyUnlock(key, &URLSchema{Domain: "brand.co", Slug: "abc123", PasswordHash: "hash"}, value, now))
+	assert.False(t, verifyUnlock(key, &URLSchema{Slug: "abc123", PasswordHash: "new hash"}, value, now))
+
+	// And cannot be forged or extended
+	assert.False(t, verifyUnlock([]byte("other key"), link, value, now))
+	_, mac, _ := strings.Cut(value, ".")
+	later := signUnlock(key, link, now.Add(2*time.Hour))
+	exp, _, _ := strings.Cut(later, ".")
+	assert.False(t, verifyUnlock(key, link, exp+"."+mac, now))
+	assert.False(t, verifyUnlock(key, link, "garbage", now))
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	now := time.Now()
+	l := newAttemptLimiter(2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		ok, _ := l.Attempt("a", now)
+		assert.True(t, ok)
+	}
+
+	// Out of attempts until the window ends
+	ok, retryAfter := l.Attempt("a", now.Add(10*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, 50*time.Second, retryAfter)
+
+	// Other keys are not affected
+	ok, _ = l.Attempt("b", now)
+	assert.True(t, ok)
+
+	// A new window starts afresh
+	ok, _ = l.Attempt("a", now.Add(time.Minute))
+	assert.True(t, ok)
+
+	// And a reset forgets the attempts
+	l.Attempt("c", now)
+	l.Attempt("c", now)
+	l.Reset("c")
+	ok, _ = l.Attempt("c", now)
+	assert.True(t, ok)
+
+	// Concurrent attempts never get past the limit
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := l.Attempt("e", now); ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), allowed.Load())
+
+	// Finished windows are pruned
+	l.Attempt("d", now.Add(2*time.Minute))
+	assert.NotContains(t, l.attempts, "a")
+	assert.Contains(t, l.attempts, "d")
+}
@@ -0,0 +1,72 @@
+package urlshortener
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultRedirectStatus is the redirect of links that did not choose one when no default is configured
+	DefaultRedirectStatus = http.StatusFound
+	// DefaultRedirectMaxAge is how long permanent redirects may be cached when no max age is configured
+	DefaultRedirectMaxAge = 24 * time.Hour
+)
+
+// ErrInvalidRedirectStatus is returned when a link asks for a status other than 301, 302, 307 or 308
+var ErrInvalidRedirectStatus = errors.New("invalid redirect status")
+
+// RedirectConfig is a struct that represents how links redirect
+type RedirectConfig struct {
+	// Status is the redirect of links created without one: 301, 302, 307 or 308
+	Status int `yaml:"status"`
+	// MaxAge is how long browsers and proxies may cache a permanent redirect
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// validRedirectStatus reports whether links may redirect with status
+func validRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// validateRedirectStatus checks the redirect status requested for a new link, where 0 selects the server default
+func validateRedirectStatus(status int) error {
+	if status != 0 && !validRedirectStatus(status) {
+		return fmt.Errorf("%w: %d, expected 301, 302, 307 or 308", ErrInvalidRedirectStatus, status)
+	}
+	return nil
+}
+
+// redirectStatus returns the status link redirects with
+func (s *Service) redirectStatus(link *URLSchema) int {
+	if link.RedirectStatus != 0 {
+		return link.RedirectStatus
+	}
+	return s.config.Redirects.Status
+}
+
+// redirectCacheControl returns the Cache-Control header of a redirect with status for link at now.
+// Permanent redirects may be cached, but no longer than the link lives. Temporary redirects, and
+// links whose every visit has to be checked, are not cached at all.
+func (s *Service) redirectCacheControl(link *URLSchema, status int, now time.Time) string {
+	if status != http.StatusMovedPermanently && status != http.StatusPermanentRedirect {
+		return "no-store"
+	}
+	if link.ClicksLeft != nil || link.PasswordHash != "" {
+		return "no-store"
+	}
+
+	maxAge := s.config.Redirects.MaxAge
+	for _, end := range []*time.Time{link.ExpiresAt, link.NotAfter} {
+		if end != nil && end.Sub(now) < maxAge {
+			maxAge = end.Sub(now)
+		}
+	}
+	return "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+}
@@ -0,0 +1,67 @@
+package urlshortener
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRedirectStatus(t *testing.T) {
+	for _, status := range []int{0, 301, 302, 307, 308} {
+		assert.NoError(t, validateRedirectStatus(status), status)
+	}
+	for _, status := range []int{200, 300, 303, 304, 404, -1} {
+		assert.ErrorIs(t, validateRedirectStatus(status), ErrInvalidRedirectStatus, status)
+	}
+}
+
+func TestRedirectStatus(t *testing.T) {
+	// Links without a status follow the server default
+	svc := newTestService(t, nil, ServiceConfig{})
+	assert.Equal(t, http.StatusFound, svc.redirectStatus(&URLSchema{}))
+	assert.Equal(t, http.StatusPermanentRedirect, svc.redirectStatus(&URLSchema{RedirectStatus: 308}))
+
+	svc = newTestService(t, nil, ServiceConfig{Redirects: RedirectConfig{Status: 301}})
+	assert.Equal(t, http.StatusMovedPermanently, svc.redirectStatus(&URLSchema{}))
+	assert.Equal(t, http.StatusTemporaryRedirect, svc.redirectStatus(&URLSchema{RedirectStatus: 307}))
+
+	// The default has to be a redirect links may choose
+	_, err := NewService(nil, ServiceConfig{Redirects: RedirectConfig{Status: 303}})
+	assert.Error(t, err)
+
+	// And so does a link
+	_, err = newTestService(t, NewMemoryURLRepository(), ServiceConfig{}).Shorten(context.Background(), URLRequest{URL: "http://example.com", RedirectStatus: 303})
+	assert.ErrorIs(t, err, ErrInvalidRedirectStatus)
+}
+
+func TestRedirectCacheControl(t *testing.T) {
+	now := time.Now()
+	soon := now.Add(time.Hour)
+	one := int64(1)
+	svc := newTestService(t, nil, ServiceConfig{Redirects: RedirectConfig{MaxAge: 24 * time.Hour}})
+
+	tests := []struct {
+		name   string
+		link   URLSchema
+		status int
+		want   string
+	}{
+		{name: "Temporary", status: 302, want: "no-store"},
+		{name: "Temporary preserving the method", status: 307, want: "no-store"},
+		{name: "Permanent", status: 301, want: "public, max-age=86400"},
+		{name: "Permanent preserving the method", status: 308, want: "public, max-age=86400"},
+		{name: "Permanent until expiry", link: URLSchema{ExpiresAt: &soon}, status: 301, want: "public, max-age=3600"},
+		{name: "Permanent until the window closes", link: URLSchema{NotAfter: &soon}, status: 308, want: "public, max-age=3600"},
+		{name: "Click-limited", link: URLSchema{ClicksLeft: &one}, status: 301, want: "no-store"},
+		{name: "Password-protected", link: URLSchema{PasswordHash: "hash"}, status: 301, want: "no-store"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, svc.redirectCacheControl(&tt.link, tt.status, now))
+		})
+	}
+}
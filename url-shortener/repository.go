@@ -1,16 +1,115 @@
 package urlshortener
 
 import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
 	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// DriverSQLite stores URLs in a local SQLite database file
+	DriverSQLite = "sqlite3"
+	// DriverPostgres stores URLs in a PostgreSQL database that can be shared by several instances
+	DriverPostgres = "postgres"
+	// DriverMemory keeps URLs in process memory, which suits dev servers, tests and preview environments
+	DriverMemory = "memory"
+)
+
+const (
+	// MigrationsAuto applies pending schema migrations when the repository is opened
+	MigrationsAuto = "auto"
+	// MigrationsManual leaves the schema alone, migrations are applied with the migrate command
+	MigrationsManual = "manual"
 )
 
-// URLSchema is a struct that represents the schema of the URL table in the database
+// ErrDuplicateURL is returned when a URL violates one of the unique constraints of URLSchema
+var ErrDuplicateURL = errors.New("duplicate url")
+
+// ErrSlugTaken is the ErrDuplicateURL returned when the slug or short URL already exists
+var ErrSlugTaken = fmt.Errorf("%w: slug is taken", ErrDuplicateURL)
+
+// DatabaseConfig is a struct that represents the database section of the config file
+type DatabaseConfig struct {
+	Driver     string            `yaml:"driver"`
+	DSN        string            `yaml:"dsn"`
+	Migrations string            `yaml:"migrations"`
+	Timeouts   OperationTimeouts `yaml:"timeouts"`
+}
+
+// URLSchema is a struct that represents the schema of the URL table in the database. The table
+// itself, with its types, defaults and indexes, is defined by the migrations.
 type URLSchema struct {
 	gorm.Model
-	Slug     string `gorm:"type:varchar(100);unique_index"`
-	ShortUrl string `gorm:"type:varchar(100);unique_index"`
-	LongUrl  string `gorm:"type:varchar(100);unique_index"`
+	// Domain is the host of the branded domain the URL was minted under, empty for the primary domain.
+	// Slugs and long URLs are unique per domain.
+	Domain   string
+	Slug     string
+	ShortUrl string
+	LongUrl  string
+	// LongUrlHash is the hex SHA-256 of LongUrl. Long URLs can be several kilobytes,
+	// so uniqueness is enforced on the hash instead of the raw text.
+	LongUrlHash string `json:"-"`
+	// OriginalUrl is the destination as it was submitted, LongUrl is its canonical form
+	OriginalUrl string
+	// ExpiresAt is when the link stops redirecting, nil for links that never expire
+	ExpiresAt *time.Time
+	// ClicksLeft is how many more redirects a click-limited link serves, nil for links without a limit
+	ClicksLeft *int64
+	// PasswordHash is the bcrypt hash of the password that unlocks the link, empty for public links
+	PasswordHash string `json:"-"`
+	// NotBefore and NotAfter bound the window in which the link redirects, nil for no bound.
+	// Unlike an expired link, a link outside its window is kept.
+	NotBefore *time.Time
+	NotAfter  *time.Time
+	// RedirectStatus is the status the link redirects with, 0 for the server default
+	RedirectStatus int
+	// LongUrlHost is the lowercase host of LongUrl, so links are listed by destination host
+	LongUrlHost string `json:"-"`
+	// Clicks counts the recorded clicks on the link. It is kept with the click events, so links
+	// are sorted by it without counting them.
+	Clicks int64
+	// Tags label the link, lowercase and sorted. They are stored in the url_tags table.
+	Tags []string `gorm:"-"`
+}
+
+// Expired reports whether the link has expired at now or used up its clicks
+func (u *URLSchema) Expired(now time.Time) bool {
+	if u.ClicksLeft != nil && *u.ClicksLeft <= 0 {
+		return true
+	}
+	return u.ExpiresAt != nil && !u.ExpiresAt.After(now)
+}
+
+// Pending reports whether the link has not gone live yet at now
+func (u *URLSchema) Pending(now time.Time) bool {
+	return u.NotBefore != nil && now.Before(*u.NotBefore)
+}
+
+// Ended reports whether the activation window of the link has closed at now
+func (u *URLSchema) Ended(now time.Time) bool {
+	return u.NotAfter != nil && !now.Before(*u.NotAfter)
+}
+
+// LinkUpdate is a struct that holds the changes UpdateLink makes to a URL, leaving out whichever is nil
+type LinkUpdate struct {
+	// LongURL is the new destination, stored with OriginalURL, the form it was submitted in
+	LongURL     *string
+	OriginalURL string
+	Window      *Window
+	Tags        *[]string
 }
 
 // SQLURLRepository is a struct that represents the SQL URL repository
@@ -18,70 +117,672 @@ type SQLURLRepository struct {
 	db *gorm.DB
 }
 
-// URLRepository is an interface that represents the URL repository
+// URLRepository is an interface that represents the URL repository.
+// URLs are scoped to the domain they were minted under, "" being the primary domain.
+// Every method gives up and returns the context's error once ctx is done.
 type URLRepository interface {
-	CreateURL(u *URLSchema) error
-	ReadURL(slug string) (*URLSchema, error)
-	ReadURLBySlug(slug string) (*URLSchema, error)
-	UpdateURL(slug string, newLongURL string) error
-	DeleteURL(slug string) error
+	// CreateURL stores u and its tags and assigns its ID. A zero CreatedAt is set to now.
+	CreateURL(ctx context.Context, u *URLSchema) error
+	// CreateURLs stores urls like CreateURL, in one transaction, and returns the error of each URL,
+	// nil for the stored ones. Only URLs that fail with ErrSlugTaken or ErrDuplicateURL are left out,
+	// any other error stores nothing and is returned on its own. If all is set, nothing is stored when any URL fails.
+	// URLs that were not stored keep a zero ID.
+	CreateURLs(ctx context.Context, urls []*URLSchema, all bool) ([]error, error)
+	// ReadURL returns the URL stored for longURL. Only one live URL without a click limit or an
+	// expiry may be stored for a long URL and it is preferred, otherwise the newest one is returned.
+	// UpdateURL and DeleteURL change the URL ReadURL returns.
+	ReadURL(ctx context.Context, domain, longURL string) (*URLSchema, error)
+	ReadURLBySlug(ctx context.Context, domain, slug string) (*URLSchema, error)
+	UpdateURL(ctx context.Context, domain, longURL, newLongURL string) error
+	DeleteURL(ctx context.Context, domain, longURL string) error
+	// DeleteURLBySlug soft-deletes the URL with the given slug, which stays taken
+	DeleteURLBySlug(ctx context.Context, domain, slug string) error
+	// UpdateLink applies update to the URL with the given slug, all of it or nothing. A new long URL
+	// that is taken on the domain is reported as ErrDuplicateURL.
+	UpdateLink(ctx context.Context, domain, slug string, update LinkUpdate) error
+	// NextSequence increments the named counter and returns its new value, starting at 1.
+	// Values are never handed out twice, even to instances sharing the store.
+	NextSequence(ctx context.Context, name string) (uint64, error)
+	// ConsumeClick takes one click from a click-limited URL and reports whether there was one left.
+	// Concurrent callers, even on instances sharing the store, never get the same click.
+	// URLs without a click limit are left alone and report false.
+	ConsumeClick(ctx context.Context, domain, slug string) (bool, error)
+	// DeleteExpiredURLs removes the URLs that expired at or before before, or used up their clicks, and returns how many were removed.
+	// They are soft-deleted like DeleteURL, unless purge is set, which also removes soft-deleted rows and frees their slugs.
+	DeleteExpiredURLs(ctx context.Context, before time.Time, purge bool) (int64, error)
+	// RecordClicks stores click events, all of them or none
+	RecordClicks(ctx context.Context, events []ClickEvent) error
+	// ClickStats returns the total clicks on the URL with the given ID, and the clicks since since
+	// counted into buckets of the given size, an hour or a day. Only buckets with clicks are returned,
+	// oldest first.
+	ClickStats(ctx context.Context, urlID uint, since time.Time, bucket time.Duration) (*ClickStats, error)
+	// ListURLs returns up to filter.Limit live URLs of filter.Domain that match the filter, in the
+	// order it asks for, starting after filter.After
+	ListURLs(ctx context.Context, filter URLFilter) ([]URLSchema, error)
+	// ExportURLs returns up to limit URLs of every domain with an ID above afterID, by ID, expired
+	// or not. Soft-deleted URLs are only returned if deleted is set.
+	ExportURLs(ctx context.Context, afterID uint, limit int, deleted bool) ([]URLSchema, error)
+}
+
+// NewURLRepository returns the URL repository selected by the database config,
+// applying pending migrations first unless they are configured to be run manually
+func NewURLRepository(config DatabaseConfig) (URLRepository, error) {
+	var repo URLRepository
+	if config.Driver == DriverMemory {
+		repo = NewMemoryURLRepository()
+	} else {
+		sqlRepo, err := NewSQLURLRepository(config.Driver, config.DSN)
+		if err != nil {
+			return nil, err
+		}
+
+		switch config.Migrations {
+		case "", MigrationsAuto:
+			if err := sqlRepo.MigrateUp(context.Background()); err != nil {
+				sqlRepo.Close()
+				return nil, err
+			}
+		case MigrationsManual:
+			// The schema is managed with the migrate command
+		default:
+			sqlRepo.Close()
+			return nil, fmt.Errorf("unsupported migrations mode: %s", config.Migrations)
+		}
+		repo = sqlRepo
+	}
+
+	if config.Timeouts != (OperationTimeouts{}) {
+		repo = NewTimeoutURLRepository(repo, config.Timeouts)
+	}
+	return repo, nil
 }
 
-func NewSQLURLRepository() (*SQLURLRepository, error) {
-	db, err := gorm.Open("sqlite3", "url.db")
+// NewSQLURLRepository opens the database described by the driver and DSN. It does not touch the
+// schema, call MigrateUp before using a new database. An empty driver defaults to SQLite. An empty
+// SQLite DSN defaults to url.db in the working directory.
+func NewSQLURLRepository(driver, dsn string) (*SQLURLRepository, error) {
+	if driver == "" {
+		driver = DriverSQLite
+	}
+
+	switch driver {
+	case DriverSQLite:
+		if dsn == "" {
+			dsn = "url.db"
+		}
+	case DriverPostgres:
+		if dsn == "" {
+			return nil, fmt.Errorf("a dsn is required for the %s driver", driver)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+
+	db, err := gorm.Open(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	db.AutoMigrate(&URLSchema{})
+	// SQLite allows a single writer, so concurrent requests queue for one connection
+	// instead of failing with "database is locked". This also keeps ":memory:" databases
+	// from being split across connections.
+	if driver == DriverSQLite {
+		db.DB().SetMaxOpenConns(1)
+	}
 
 	return &SQLURLRepository{
 		db: db,
 	}, nil
 }
 
-func (s *SQLURLRepository) CreateURL(u *URLSchema) error {
-	if err := s.db.Create(u).Error; err != nil {
+// Close closes the underlying database connection
+func (s *SQLURLRepository) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLURLRepository) CreateURL(ctx context.Context, u *URLSchema) error {
+	tx := s.withContext(ctx).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := createURL(tx, u); err != nil {
+		tx.Rollback()
 		return err
 	}
-	return nil
+	return tx.Commit().Error
+}
+
+func (s *SQLURLRepository) CreateURLs(ctx context.Context, urls []*URLSchema, all bool) ([]error, error) {
+	tx := s.withContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	// Each URL gets a savepoint, because PostgreSQL refuses every statement of a transaction after a failed one
+	errs := make([]error, len(urls))
+	var failed bool
+	for i, u := range urls {
+		if err := tx.Exec("SAVEPOINT create_url").Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		err := createURL(tx, u)
+		if err == nil {
+			err = tx.Exec("RELEASE SAVEPOINT create_url").Error
+		} else if errors.Is(err, ErrSlugTaken) || errors.Is(err, ErrDuplicateURL) {
+			errs[i], failed = err, true
+			u.ID = 0
+			err = tx.Exec("ROLLBACK TO SAVEPOINT create_url").Error
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if all && failed {
+		for _, u := range urls {
+			u.ID = 0
+		}
+		return errs, tx.Rollback().Error
+	}
+	return errs, tx.Commit().Error
+}
+
+// createURL stores u and its tags in the transaction tx
+func createURL(tx *gorm.DB, u *URLSchema) error {
+	u.LongUrlHash = hashLongURL(u.LongUrl)
+	u.LongUrlHost = longURLHost(u.LongUrl)
+	// SQLite compares times as text, which only orders them correctly in a single time zone
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+	}
+	u.CreatedAt = u.CreatedAt.UTC()
+	u.ExpiresAt = utcTime(u.ExpiresAt)
+	u.NotBefore = utcTime(u.NotBefore)
+	u.NotAfter = utcTime(u.NotAfter)
+
+	if err := tx.Create(u).Error; err != nil {
+		return translateError(err)
+	}
+	return insertTags(tx, u.ID, u.Tags)
 }
 
-func (s *SQLURLRepository) ReadURL(longURL string) (*URLSchema, error) {
+func (s *SQLURLRepository) ReadURL(ctx context.Context, domain, longURL string) (*URLSchema, error) {
+	db := s.withContext(ctx)
+	return readURL(db, byLongURL(db, domain, longURL))
+}
+
+func (s *SQLURLRepository) ReadURLBySlug(ctx context.Context, domain, slug string) (*URLSchema, error) {
+	db := s.withContext(ctx)
+	return readURL(db, db.Where("domain = ? AND slug = ?", domain, slug))
+}
+
+// byLongURL selects the URLs stored for longURL in the order ReadURL prefers them: the one without
+// a click limit or an expiry, then the newest
+func byLongURL(db *gorm.DB, domain, longURL string) *gorm.DB {
+	return db.Where("domain = ? AND long_url_hash = ?", domain, hashLongURL(longURL)).
+		Order("(clicks_left IS NULL AND expires_at IS NULL) DESC").Order("id DESC")
+}
+
+// readURL returns the first live URL the query selects with its tags, or nil if there is none
+func readURL(db, query *gorm.DB) (*URLSchema, error) {
 	var url URLSchema
-	if err := s.db.Where("long_url = ?", longURL).First(&url).Error; err != nil {
+	if err := query.First(&url).Error; err != nil {
 		if gorm.IsRecordNotFoundError(err) {
 			return nil, nil // no error, just no record found
 		}
 		return nil, err
 	}
-	return &url, nil
+
+	urls := []URLSchema{url}
+	if err := loadTags(db, urls); err != nil {
+		return nil, err
+	}
+	return &urls[0], nil
 }
 
-func (s *SQLURLRepository) ReadURLBySlug(slug string) (*URLSchema, error) {
+func (s *SQLURLRepository) UpdateURL(ctx context.Context, domain, longURL, newLongURL string) error {
+	db := s.withContext(ctx)
+
 	var url URLSchema
-	if err := s.db.Where("slug = ?", slug).First(&url).Error; err != nil {
+	if err := byLongURL(db, domain, longURL).Select("id").First(&url).Error; err != nil {
 		if gorm.IsRecordNotFoundError(err) {
-			return nil, nil // no error, just no record found
+			return nil
 		}
-		return nil, err
+		return err
 	}
-	return &url, nil
+
+	if err := db.Model(&url).Updates(map[string]interface{}{
+		"long_url":      newLongURL,
+		"long_url_hash": hashLongURL(newLongURL),
+		"long_url_host": longURLHost(newLongURL),
+		"original_url":  newLongURL,
+	}).Error; err != nil {
+		return translateError(err)
+	}
+
+	return nil
 }
 
-func (s *SQLURLRepository) UpdateURL(longURL string, newLongURL string) error {
+func (s *SQLURLRepository) UpdateLink(ctx context.Context, domain, slug string, update LinkUpdate) error {
+	tx := s.withContext(ctx).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
 	var url URLSchema
-	if err := s.db.Model(&url).Where("long_url = ?", longURL).Update("long_url", newLongURL).Error; err != nil {
+	if err := tx.Select("id").Where("domain = ? AND slug = ?", domain, slug).First(&url).Error; err != nil {
+		tx.Rollback()
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
 		return err
 	}
 
-	return nil
+	fields := make(map[string]interface{})
+	if update.LongURL != nil {
+		fields["long_url"] = *update.LongURL
+		fields["long_url_hash"] = hashLongURL(*update.LongURL)
+		fields["long_url_host"] = longURLHost(*update.LongURL)
+		fields["original_url"] = update.OriginalURL
+	}
+	if update.Window != nil {
+		fields["not_before"] = utcTime(update.Window.NotBefore)
+		fields["not_after"] = utcTime(update.Window.NotAfter)
+	}
+	if len(fields) > 0 {
+		if err := tx.Model(&url).Updates(fields).Error; err != nil {
+			tx.Rollback()
+			return translateError(err)
+		}
+	}
+
+	if update.Tags != nil {
+		if err := tx.Exec("DELETE FROM url_tags WHERE url_id = ?", url.ID).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := insertTags(tx, url.ID, *update.Tags); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
 }
 
-func (s *SQLURLRepository) DeleteURL(longURL string) error {
+func (s *SQLURLRepository) DeleteURL(ctx context.Context, domain, longURL string) error {
+	db := s.withContext(ctx)
+
 	var url URLSchema
-	if err := s.db.Where("long_url = ?", longURL).Delete(&url).Error; err != nil {
+	if err := byLongURL(db, domain, longURL).Select("id").First(&url).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
 		return err
 	}
+
+	if err := db.Delete(&url).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SQLURLRepository) DeleteURLBySlug(ctx context.Context, domain, slug string) error {
+	var url URLSchema
+	return s.withContext(ctx).Where("domain = ? AND slug = ?", domain, slug).Delete(&url).Error
+}
+
+func (s *SQLURLRepository) ConsumeClick(ctx context.Context, domain, slug string) (bool, error) {
+	// The condition and the decrement are one statement, so the database serializes competing clicks
+	result := s.withContext(ctx).Model(&URLSchema{}).Where("domain = ? AND slug = ? AND clicks_left > 0", domain, slug).
+		UpdateColumn("clicks_left", gorm.Expr("clicks_left - 1"))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (s *SQLURLRepository) DeleteExpiredURLs(ctx context.Context, before time.Time, purge bool) (int64, error) {
+	db := s.withContext(ctx).Where("expires_at <= ? OR clicks_left <= 0", before.UTC())
+	if purge {
+		db = db.Unscoped()
+	}
+
+	result := db.Delete(&URLSchema{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+func (s *SQLURLRepository) RecordClicks(ctx context.Context, events []ClickEvent) error {
+	tx := s.withContext(ctx).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	counts := make(map[uint]int64)
+	for i := range events {
+		event := events[i]
+		event.ClickedAt = event.ClickedAt.UTC()
+		if err := tx.Create(&event).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		counts[event.URLID]++
+	}
+
+	for id, n := range counts {
+		if err := tx.Exec("UPDATE url_schemas SET clicks = clicks + ? WHERE id = ?", n, id).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+func (s *SQLURLRepository) ClickStats(ctx context.Context, urlID uint, since time.Time, bucket time.Duration) (*ClickStats, error) {
+	db := s.withContext(ctx)
+
+	var stats ClickStats
+	if err := db.Model(&ClickEvent{}).Where("url_id = ?", urlID).Count(&stats.Total).Error; err != nil {
+		return nil, err
+	}
+
+	// The clicks are grouped by the start of their bucket in UTC, which both dialects format as bucketLayout
+	expr, err := clickBucketExpr(db.Dialect().GetName(), bucket)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Model(&ClickEvent{}).Select(expr+" AS bucket, COUNT(*)").
+		Where("url_id = ? AND clicked_at >= ?", urlID, since.UTC()).Group("bucket").Order("bucket").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats.Buckets = []ClickBucket{}
+	for rows.Next() {
+		var start string
+		var b ClickBucket
+		if err := rows.Scan(&start, &b.Clicks); err != nil {
+			return nil, err
+		}
+		if b.Start, err = time.Parse(bucketLayout, start); err != nil {
+			return nil, err
+		}
+		stats.Buckets = append(stats.Buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// bucketLayout is the format of the bucket starts returned by clickBucketExpr
+const bucketLayout = "2006-01-02 15:04:05"
+
+// clickBucketExpr returns the SQL expression that truncates clicked_at to the start of its hour or day
+// bucket in UTC, formatted as bucketLayout
+func clickBucketExpr(dialect string, bucket time.Duration) (string, error) {
+	var unit, format string
+	switch bucket {
+	case time.Hour:
+		unit, format = "hour", "%Y-%m-%d %H:00:00"
+	case 24 * time.Hour:
+		unit, format = "day", "%Y-%m-%d 00:00:00"
+	default:
+		return "", fmt.Errorf("buckets of %s are not supported", bucket)
+	}
+
+	if dialect == DriverPostgres {
+		return "to_char(date_trunc('" + unit + "', clicked_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD HH24:MI:SS')", nil
+	}
+	return "strftime('" + format + "', clicked_at)", nil
+}
+
+func (s *SQLURLRepository) ListURLs(ctx context.Context, filter URLFilter) ([]URLSchema, error) {
+	db := s.withContext(ctx)
+
+	query := db.Where("domain = ?", filter.Domain)
+	if filter.Host != "" {
+		query = query.Where("long_url_host = ?", strings.ToLower(filter.Host))
+	}
+	if filter.Contains != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(filter.Contains)) + "%"
+		query = query.Where(`LOWER(long_url) LIKE ? ESCAPE '\' OR LOWER(slug) LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+	if filter.Tag != "" {
+		query = query.Where("id IN (SELECT url_id FROM url_tags WHERE tag = ?)", filter.Tag)
+	}
+	if filter.CreatedSince != nil {
+		query = query.Where("created_at >= ?", filter.CreatedSince.UTC())
+	}
+	if filter.CreatedBefore != nil {
+		query = query.Where("created_at < ?", filter.CreatedBefore.UTC())
+	}
+
+	// Pages are read with a seek on the sort column and the ID, which the domain indexes serve
+	column := "created_at"
+	if filter.Sort == SortClicks {
+		column = "clicks"
+	}
+	op, dir := "<", "DESC"
+	if filter.Ascending {
+		op, dir = ">", "ASC"
+	}
+	if after := filter.After; after != nil {
+		var value interface{} = after.CreatedAt.UTC()
+		if filter.Sort == SortClicks {
+			value = after.Clicks
+		}
+		query = query.Where(fmt.Sprintf("%s %s ? OR (%s = ? AND id %s ?)", column, op, column, op), value, value, after.ID)
+	}
+
+	var urls []URLSchema
+	if err := query.Order(fmt.Sprintf("%s %s, id %s", column, dir, dir)).Limit(filter.Limit).Find(&urls).Error; err != nil {
+		return nil, err
+	}
+	if err := loadTags(db, urls); err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+func (s *SQLURLRepository) ExportURLs(ctx context.Context, afterID uint, limit int, deleted bool) ([]URLSchema, error) {
+	db := s.withContext(ctx)
+
+	query := db
+	if deleted {
+		query = query.Unscoped()
+	}
+	var urls []URLSchema
+	if err := query.Where("id > ?", afterID).Order("id").Limit(limit).Find(&urls).Error; err != nil {
+		return nil, err
+	}
+	if err := loadTags(db, urls); err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, with \ as the escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// insertTags stores tags for the URL with the given ID
+func insertTags(db *gorm.DB, urlID uint, tags []string) error {
+	for _, tag := range tags {
+		if err := db.Create(&URLTag{URLID: urlID, Tag: tag}).Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// loadTags fills in the tags of urls, reading them with a single query
+func loadTags(db *gorm.DB, urls []URLSchema) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	byID := make(map[uint]*URLSchema, len(urls))
+	ids := make([]uint, len(urls))
+	for i := range urls {
+		byID[urls[i].ID] = &urls[i]
+		ids[i] = urls[i].ID
+	}
+
+	rows, err := db.Raw("SELECT url_id, tag FROM url_tags WHERE url_id IN (?) ORDER BY tag", ids).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		byID[id].Tags = append(byID[id].Tags, tag)
+	}
+	return rows.Err()
+}
+
+func (s *SQLURLRepository) NextSequence(ctx context.Context, name string) (uint64, error) {
+	// Two instances can both find the counter missing and race to insert it;
+	// the loser retries and takes the update path
+	for attempt := 0; ; attempt++ {
+		value, err := s.nextSequence(ctx, name)
+		if errors.Is(err, ErrDuplicateURL) && attempt == 0 {
+			continue
+		}
+		return value, err
+	}
+}
+
+func (s *SQLURLRepository) nextSequence(ctx context.Context, name string) (uint64, error) {
+	tx := s.withContext(ctx).Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	// The update locks the row until commit, so concurrent callers queue up behind it
+	result := tx.Exec("UPDATE sequences SET value = value + 1 WHERE name = ?", name)
+	if result.Error != nil {
+		tx.Rollback()
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		if err := tx.Exec("INSERT INTO sequences (name, value) VALUES (?, 1)", name).Error; err != nil {
+			tx.Rollback()
+			return 0, translateError(err)
+		}
+	}
+
+	var value uint64
+	if err := tx.Raw("SELECT value FROM sequences WHERE name = ?", name).Row().Scan(&value); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return value, tx.Commit().Error
+}
+
+// utcTime returns t in UTC, or nil if t is nil
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// hashLongURL returns the value stored in URLSchema.LongUrlHash for a long URL
+func hashLongURL(longURL string) string {
+	sum := sha256.Sum256([]byte(longURL))
+	return hex.EncodeToString(sum[:])
+}
+
+// longURLHost returns the value stored in URLSchema.LongUrlHost for a long URL
+func longURLHost(longURL string) string {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// withContext returns a handle on the database whose statements are all bound to ctx.
+// gorm v1 has no context support of its own, so the handle wraps the connection pool in
+// a SQLCommon that forwards every call to the context-aware database/sql methods.
+func (s *SQLURLRepository) withContext(ctx context.Context) *gorm.DB {
+	db, err := gorm.Open(s.db.Dialect().GetName(), &contextDB{db: s.db.DB(), ctx: ctx})
+	if err != nil {
+		// gorm.Open cannot fail for an existing connection, but keep the error on the handle
+		db = s.db.New()
+		db.AddError(err)
+	}
+	return db
+}
+
+// contextDB is a gorm.SQLCommon that runs every statement and transaction under ctx
+type contextDB struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+func (c *contextDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.db.ExecContext(c.ctx, query, args...)
+}
+
+func (c *contextDB) Prepare(query string) (*sql.Stmt, error) {
+	return c.db.PrepareContext(c.ctx, query)
+}
+
+func (c *contextDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.QueryContext(c.ctx, query, args...)
+}
+
+func (c *contextDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+// Begin is used by gorm to wrap creates in a transaction
+func (c *contextDB) Begin() (*sql.Tx, error) {
+	return c.db.BeginTx(c.ctx, nil)
+}
+
+// BeginTx is used by gorm's Begin, which always passes context.Background, so the
+// transaction is bound to ctx instead; it is rolled back if ctx is done before commit
+func (c *contextDB) BeginTx(_ context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return c.db.BeginTx(c.ctx, opts)
+}
+
+// translateError maps unique constraint violations of the supported drivers to ErrSlugTaken
+// when they concern the slug or short URL, and to ErrDuplicateURL otherwise
+func translateError(err error) error {
+	var constraint string
+
+	var sqliteErr sqlite3.Error
+	var pqErr *pq.Error
+	switch {
+	case errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique:
+		// The message names the columns, e.g. "UNIQUE constraint failed: url_schemas.domain, url_schemas.slug"
+		constraint = sqliteErr.Error()
+	case errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation":
+		// The constraint is the index name, e.g. "uix_url_schemas_domain_slug"
+		constraint = pqErr.Constraint
+	default:
+		return err
+	}
+
+	// The driver's message names tables and columns, so it is logged rather than passed on to clients
+	log.Printf("Unique constraint violated: %v", err)
+	if strings.Contains(constraint, "slug") || strings.Contains(constraint, "short_url") {
+		return ErrSlugTaken
+	}
+	return ErrDuplicateURL
+}
@@ -1,27 +1,35 @@
 package urlshortener
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
 	"testing"
 
-	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestCreateURL(t *testing.T) {
-	// Initialize the database
-	db, err := gorm.Open("sqlite3", ":memory:")
+// newMigratedRepository returns a repository on an in-memory SQLite database with the migrations applied
+func newMigratedRepository(t *testing.T) *SQLURLRepository {
+	t.Helper()
+	repo, err := NewSQLURLRepository(DriverSQLite, ":memory:")
 	if err != nil {
-		t.Fatalf("Failed to open database: %v", err)
+		t.Fatalf("Failed to create repository: %v", err)
 	}
-	defer db.Close()
-
-	// Auto-migrate the schema
-	db.AutoMigrate(&URLSchema{})
+	t.Cleanup(func() { repo.Close() })
 
-	// Create the repository
-	repo := &SQLURLRepository{
-		db: db,
+	if err := repo.MigrateUp(context.Background()); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
 	}
+	return repo
+}
+
+func TestCreateURL(t *testing.T) {
+	ctx := context.Background()
+
+	// Create the repository on a migrated database
+	repo := newMigratedRepository(t)
 
 	// Create the URL schema
 	url := &URLSchema{
@@ -31,12 +39,12 @@ func TestCreateURL(t *testing.T) {
 	}
 
 	// Call CreateURL
-	err = repo.CreateURL(url)
+	err := repo.CreateURL(ctx, url)
 	assert.NoError(t, err)
 
 	// Retrieve the URL from the database
 	var retrievedURL URLSchema
-	db.Where("slug = ?", "testslug").First(&retrievedURL)
+	repo.db.Where("slug = ?", "testslug").First(&retrievedURL)
 
 	// Check that the retrieved URL matches the created URL
 	assert.Equal(t, url.Slug, retrievedURL.Slug)
@@ -45,21 +53,10 @@ func TestCreateURL(t *testing.T) {
 }
 
 func TestReadURL(t *testing.T) {
-	// Initialize the database
-	db, err := gorm.Open("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatalf("Failed to open database: %v", err)
-	}
-
-	defer db.Close()
+	ctx := context.Background()
 
-	// Create the repository
-	repo := &SQLURLRepository{
-		db: db,
-	}
-
-	// Auto-migrate the schema
-	db.AutoMigrate(&URLSchema{})
+	// Create the repository on a migrated database
+	repo := newMigratedRepository(t)
 
 	// Create the URL schema
 	url := &URLSchema{
@@ -69,11 +66,11 @@ func TestReadURL(t *testing.T) {
 	}
 
 	// Call CreateURL
-	err = repo.CreateURL(url)
+	err := repo.CreateURL(ctx, url)
 	assert.NoError(t, err)
 
 	// Call ReadURL
-	url, err = repo.ReadURL("http://example.com")
+	url, err = repo.ReadURL(ctx, "", "http://example.com")
 	assert.NoError(t, err)
 
 	// Check that the URL matches the expected URL
@@ -83,20 +80,10 @@ func TestReadURL(t *testing.T) {
 }
 
 func TestReadURLBySlug(t *testing.T) {
-	// Initialize the database
-	db, err := gorm.Open("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatalf("Failed to open database: %v", err)
-	}
-	defer db.Close()
+	ctx := context.Background()
 
-	// Create the repository
-	repo := &SQLURLRepository{
-		db: db,
-	}
-
-	// Auto-migrate the schema
-	db.AutoMigrate(&URLSchema{})
+	// Create the repository on a migrated database
+	repo := newMigratedRepository(t)
 
 	// Create the URL schema
 	url := &URLSchema{
@@ -106,11 +93,11 @@ func TestReadURLBySlug(t *testing.T) {
 	}
 
 	// Call CreateURL
-	err = repo.CreateURL(url)
+	err := repo.CreateURL(ctx, url)
 	assert.NoError(t, err)
 
 	// Call ReadURLBySlug
-	url, err = repo.ReadURLBySlug("abc123")
+	url, err = repo.ReadURLBySlug(ctx, "", "abc123")
 	assert.NoError(t, err)
 
 	// Check that the URL matches the expected URL
@@ -120,20 +107,10 @@ func TestReadURLBySlug(t *testing.T) {
 }
 
 func TestUpdateURL(t *testing.T) {
-	// Initialize the database
-	db, err := gorm.Open("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatalf("Failed to open database: %v", err)
-	}
-	defer db.Close()
-
-	// Create the repository
-	repo := &SQLURLRepository{
-		db: db,
-	}
+	ctx := context.Background()
 
-	// Auto-migrate the schema
-	db.AutoMigrate(&URLSchema{})
+	// Create the repository on a migrated database
+	repo := newMigratedRepository(t)
 
 	// Create the URL schema
 	url := &URLSchema{
@@ -143,16 +120,16 @@ func TestUpdateURL(t *testing.T) {
 	}
 
 	// Call CreateURL
-	err = repo.CreateURL(url)
+	err := repo.CreateURL(ctx, url)
 	assert.NoError(t, err)
 
 	// Call UpdateURL
-	err = repo.UpdateURL("http://example.com", "http://example.org")
+	err = repo.UpdateURL(ctx, "", "http://example.com", "http://example.org")
 	assert.NoError(t, err)
 
 	// Retrieve the URL from the database
 	var retrievedURL URLSchema
-	db.Where("slug = ?", "abc123").First(&retrievedURL)
+	repo.db.Where("slug = ?", "abc123").First(&retrievedURL)
 
 	// Check that the retrieved URL matches the updated URL
 	assert.Equal(t, "abc123", retrievedURL.Slug)
@@ -161,20 +138,10 @@ func TestUpdateURL(t *testing.T) {
 }
 
 func TestDeleteURL(t *testing.T) {
-	// Initialize the database
-	db, err := gorm.Open("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatalf("Failed to open database: %v", err)
-	}
-	defer db.Close()
-
-	// Create the repository
-	repo := &SQLURLRepository{
-		db: db,
-	}
+	ctx := context.Background()
 
-	// Auto-migrate the schema
-	db.AutoMigrate(&URLSchema{})
+	// Create the repository on a migrated database
+	repo := newMigratedRepository(t)
 
 	// Create the URL schema
 	url := &URLSchema{
@@ -184,17 +151,132 @@ func TestDeleteURL(t *testing.T) {
 	}
 
 	// Call CreateURL
-	err = repo.CreateURL(url)
+	err := repo.CreateURL(ctx, url)
 	assert.NoError(t, err)
 
 	// Call DeleteURL
-	err = repo.DeleteURL("http://example.com")
+	err = repo.DeleteURL(ctx, "", "http://example.com")
 	assert.NoError(t, err)
 
 	// Retrieve the URL from the database
 	var retrievedURL URLSchema
-	db.Where("slug = ?", "abc123").First(&retrievedURL)
+	repo.db.Where("slug = ?", "abc123").First(&retrievedURL)
 
 	// Check that the retrieved URL is nil
 	assert.Equal(t, URLSchema{}, retrievedURL)
 }
+
+func TestNewSQLURLRepository(t *testing.T) {
+	// An unknown driver is rejected before opening anything
+	_, err := NewSQLURLRepository("mysql", "")
+	assert.Error(t, err)
+
+	// Postgres needs an explicit DSN
+	_, err = NewSQLURLRepository(DriverPostgres, "")
+	assert.Error(t, err)
+
+	// SQLite creates the database file but leaves the schema to the migrations
+	repo, err := NewSQLURLRepository(DriverSQLite, t.TempDir()+"/url.db")
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	defer repo.Close()
+
+	assert.False(t, repo.db.HasTable(&URLSchema{}))
+
+	// NewURLRepository applies the migrations unless they are manual
+	dsn := t.TempDir() + "/url.db"
+	migrated, err := NewURLRepository(DatabaseConfig{Driver: DriverSQLite, DSN: dsn})
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	defer migrated.(*SQLURLRepository).Close()
+
+	assert.True(t, migrated.(*SQLURLRepository).db.HasTable(&URLSchema{}))
+
+	_, err = NewURLRepository(DatabaseConfig{Driver: DriverSQLite, DSN: dsn, Migrations: "sometimes"})
+	assert.Error(t, err)
+}
+
+func TestPostgresURLRepository(t *testing.T) {
+	ctx := context.Background()
+
+	// The test runs against a real server, e.g.
+	// docker run -e POSTGRES_PASSWORD=postgres -p 5432:5432 postgres
+	// POSTGRES_DSN="host=localhost user=postgres password=postgres sslmode=disable" go test ./...
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN is not set")
+	}
+
+	repo, err := NewSQLURLRepository(DriverPostgres, dsn)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	defer repo.Close()
+
+	err = repo.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	// Keys unique to this run leave the rows of earlier runs and other tests sharing the database alone
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("Failed to generate key prefix: %v", err)
+	}
+	prefix := hex.EncodeToString(b)
+	slug := prefix + "abc"
+	shortURL := "http://localhost:8080/" + slug
+	longURL := "http://example.com/" + prefix
+	newLongURL := "http://example.org/" + prefix
+
+	url := &URLSchema{
+		Slug:     slug,
+		ShortUrl: shortURL,
+		LongUrl:  longURL,
+	}
+
+	// Call CreateURL
+	err = repo.CreateURL(ctx, url)
+	assert.NoError(t, err)
+
+	// A second URL with the same slug violates the unique index
+	err = repo.CreateURL(ctx, &URLSchema{
+		Slug:     slug,
+		ShortUrl: shortURL,
+		LongUrl:  "http://example.net/" + prefix,
+	})
+	assert.Error(t, err)
+
+	// Call ReadURL
+	url, err = repo.ReadURL(ctx, "", longURL)
+	assert.NoError(t, err)
+	assert.Equal(t, slug, url.Slug)
+
+	// Call ReadURLBySlug
+	url, err = repo.ReadURLBySlug(ctx, "", slug)
+	assert.NoError(t, err)
+	assert.Equal(t, longURL, url.LongUrl)
+
+	// Unknown slugs are not an error
+	url, err = repo.ReadURLBySlug(ctx, "", prefix+"missing")
+	assert.NoError(t, err)
+	assert.Nil(t, url)
+
+	// Call UpdateURL
+	err = repo.UpdateURL(ctx, "", longURL, newLongURL)
+	assert.NoError(t, err)
+
+	url, err = repo.ReadURLBySlug(ctx, "", slug)
+	assert.NoError(t, err)
+	assert.Equal(t, newLongURL, url.LongUrl)
+
+	// Call DeleteURL
+	err = repo.DeleteURL(ctx, "", newLongURL)
+	assert.NoError(t, err)
+
+	url, err = repo.ReadURLBySlug(ctx, "", slug)
+	assert.NoError(t, err)
+	assert.Nil(t, url)
+}
@@ -0,0 +1,1064 @@
+// Package repotest provides a conformance test suite for urlshortener.URLRepository implementations.
+//
+// A backend passes the suite when it behaves like the SQL repository:
+//
+//   - Short URLs are unique, and slugs are unique per domain. A taken slug or short URL is
+//     reported as ErrSlugTaken, so callers can retry with another.
+//   - Long URLs are unique per domain among the live URLs without a click limit or an expiry.
+//   - Lookups that find nothing return (nil, nil).
+//   - Updates and deletes only touch live rows.
+//   - Expired URLs are read until they are swept.
+//   - Clicks are handed out once.
+//   - Listings page through the live URLs of one domain in a stable order.
+//   - Exports return every URL by ID.
+//   - Sequences never hand out a value twice.
+//   - Every method is safe for concurrent use.
+//   - No method does any work once its context is done.
+//
+// Run the suite from the backend's tests:
+//
+//	func TestMyRepository(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) urlshortener.URLRepository {
+//			return NewMyRepository()
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	urlshortener "github.com/kuhlman-labs/url-shortener/url-shortener"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory returns the repository under test. It is called once per subtest and should register any
+// cleanup with t.Cleanup. The suite only uses keys unique to the subtest, so backends that share one
+// store between calls, such as a PostgreSQL database, do not need to be emptied first.
+type Factory func(t *testing.T) urlshortener.URLRepository
+
+// Run runs the conformance suite against the repositories returned by newRepo
+func Run(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo urlshortener.URLRepository, f *fixture)
+	}{
+		{"CreateAndRead", testCreateAndRead},
+		{"NotFound", testNotFound},
+		{"UniqueSlug", testUniqueSlug},
+		{"UniqueShortURL", testUniqueShortURL},
+		{"UniqueLongURL", testUniqueLongURL},
+		{"CreateURLs", testCreateURLs},
+		{"CreateURLsAll", testCreateURLsAll},
+		{"LongURLs", testLongURLs},
+		{"Update", testUpdate},
+		{"UpdateConflict", testUpdateConflict},
+		{"Delete", testDelete},
+		{"Expiry", testExpiry},
+		{"ClickLimit", testClickLimit},
+		{"ConcurrentClicks", testConcurrentClicks},
+		{"Window", testWindow},
+		{"Clicks", testClicks},
+		{"Tags", testTags},
+		{"List", testList},
+		{"ListPages", testListPages},
+		{"Export", testExport},
+		{"ConcurrentCreate", testConcurrentCreate},
+		{"ConcurrentSameSlug", testConcurrentSameSlug},
+		{"ConcurrentReadWrite", testConcurrentReadWrite},
+		{"Domains", testDomains},
+		{"Sequence", testSequence},
+		{"ConcurrentSequence", testConcurrentSequence},
+		{"CancelledContext", testCancelledContext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepo(t), newFixture(t))
+		})
+	}
+}
+
+// fixture builds URLs whose slugs and addresses are unique to one subtest
+type fixture struct {
+	prefix string
+}
+
+func newFixture(t *testing.T) *fixture {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("error generating fixture prefix: %v", err)
+	}
+	return &fixture{prefix: hex.EncodeToString(b)}
+}
+
+func (f *fixture) slug(name string) string {
+	return f.prefix + name
+}
+
+func (f *fixture) longURL(name string) string {
+	return "http://example.com/" + f.prefix + "/" + name
+}
+
+func (f *fixture) url(name string) *urlshortener.URLSchema {
+	return &urlshortener.URLSchema{
+		Slug:     f.slug(name),
+		ShortUrl: "http://localhost:8080/" + f.slug(name),
+		LongUrl:  f.longURL(name),
+	}
+}
+
+// domainURL is like url, but minted under a branded domain
+func (f *fixture) domainURL(domain, name string) *urlshortener.URLSchema {
+	return &urlshortener.URLSchema{
+		Domain:   domain,
+		Slug:     f.slug(name),
+		ShortUrl: "https://" + domain + "/" + f.slug(name),
+		LongUrl:  f.longURL(name),
+	}
+}
+
+func testCreateAndRead(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	url := f.url("a")
+	url.RedirectStatus = 308
+	require.NoError(t, repo.CreateURL(ctx, url))
+	assert.NotZero(t, url.ID, "CreateURL should assign an ID")
+
+	got, err := repo.ReadURL(ctx, "", f.longURL("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got, "ReadURL should find the created URL")
+	assert.Equal(t, url.Slug, got.Slug)
+	assert.Equal(t, url.ShortUrl, got.ShortUrl)
+	assert.Equal(t, url.LongUrl, got.LongUrl)
+	assert.Equal(t, url.RedirectStatus, got.RedirectStatus)
+
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got, "ReadURLBySlug should find the created URL")
+	assert.Equal(t, url.LongUrl, got.LongUrl)
+}
+
+func testNotFound(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	got, err := repo.ReadURL(ctx, "", f.longURL("missing"))
+	assert.NoError(t, err, "ReadURL should not fail for unknown URLs")
+	assert.Nil(t, got)
+
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("missing"))
+	assert.NoError(t, err, "ReadURLBySlug should not fail for unknown slugs")
+	assert.Nil(t, got)
+
+	assert.NoError(t, repo.UpdateURL(ctx, "", f.longURL("missing"), f.longURL("other")), "UpdateURL should not fail for unknown URLs")
+	assert.NoError(t, repo.DeleteURL(ctx, "", f.longURL("missing")), "DeleteURL should not fail for unknown URLs")
+}
+
+func testUniqueSlug(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateURL(ctx, f.url("a")))
+
+	dup := f.url("b")
+	dup.Slug = f.slug("a")
+	assert.ErrorIs(t, repo.CreateURL(ctx, dup), urlshortener.ErrSlugTaken)
+
+	got, err := repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, f.longURL("a"), got.LongUrl, "a rejected create should not replace the existing URL")
+}
+
+func testUniqueShortURL(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateURL(ctx, f.url("a")))
+
+	dup := f.url("b")
+	dup.ShortUrl = f.url("a").ShortUrl
+	assert.ErrorIs(t, repo.CreateURL(ctx, dup), urlshortener.ErrSlugTaken)
+}
+
+func testUniqueLongURL(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateURL(ctx, f.url("a")))
+
+	dup := f.url("b")
+	dup.LongUrl = f.longURL("a")
+	err := repo.CreateURL(ctx, dup)
+	assert.ErrorIs(t, err, urlshortener.ErrDuplicateURL)
+	assert.NotErrorIs(t, err, urlshortener.ErrSlugTaken, "a duplicate long URL is not a slug collision")
+
+	// URLs with a click limit or an expiry do not claim their long URL, so they can share it
+	limited := f.limitedURL("limited", 1)
+	limited.LongUrl = f.longURL("a")
+	require.NoError(t, repo.CreateURL(ctx, limited))
+	later := time.Now().Add(time.Hour).UTC()
+	expiring := f.url("expiring")
+	expiring.LongUrl = f.longURL("a")
+	expiring.ExpiresAt = &later
+	require.NoError(t, repo.CreateURL(ctx, expiring))
+
+	got, err := repo.ReadURL(ctx, "", f.longURL("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, f.slug("a"), got.Slug, "ReadURL should prefer the URL that claims the long URL")
+
+	// Without it, the newest URL is read
+	require.NoError(t, repo.DeleteURLBySlug(ctx, "", f.slug("a")))
+	got, err = repo.ReadURL(ctx, "", f.longURL("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, f.slug("expiring"), got.Slug)
+
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("limited"))
+	require.NoError(t, err)
+	assert.NotNil(t, got, "DeleteURLBySlug should only delete the given URL")
+
+	// A limited URL can also move to a long URL that is claimed
+	require.NoError(t, repo.CreateURL(ctx, f.url("b")))
+	moved := f.longURL("b")
+	assert.NoError(t, repo.UpdateLink(ctx, "", f.slug("limited"), urlshortener.LinkUpdate{LongURL: &moved}))
+}
+
+func testCreateURLs(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateURL(ctx, f.url("a")))
+
+	// The URLs that conflict with stored ones, or with each other, are left out
+	taken := f.url("b")
+	taken.Slug, taken.ShortUrl = f.slug("a"), f.url("a").ShortUrl
+	dup := f.url("d")
+	dup.LongUrl = f.longURL("c")
+	tagged := f.url("e")
+	tagged.Tags = []string{"docs"}
+	urls := []*urlshortener.URLSchema{taken, f.url("c"), dup, tagged}
+
+	errs, err := repo.CreateURLs(ctx, urls, false)
+	require.NoError(t, err)
+	require.Len(t, errs, len(urls))
+	assert.ErrorIs(t, errs[0], urlshortener.ErrSlugTaken)
+	assert.NoError(t, errs[1])
+	assert.ErrorIs(t, errs[2], urlshortener.ErrDuplicateURL)
+	assert.NoError(t, errs[3])
+
+	assert.Zero(t, taken.ID, "a URL that was not stored should keep a zero ID")
+	assert.Zero(t, dup.ID, "a URL that was not stored should keep a zero ID")
+	assert.NotZero(t, urls[1].ID, "CreateURLs should assign IDs")
+	assert.NotZero(t, tagged.ID, "CreateURLs should assign IDs")
+
+	got, err := repo.ReadURLBySlug(ctx, "", f.slug("c"))
+	require.NoError(t, err)
+	require.NotNil(t, got, "the URLs after a failed one should be stored")
+	assert.Equal(t, f.longURL("c"), got.LongUrl)
+
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("e"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, []string{"docs"}, got.Tags, "CreateURLs should store tags")
+
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("d"))
+	require.NoError(t, err)
+	assert.Nil(t, got, "a duplicate URL should not be stored")
+}
+
+func testCreateURLsAll(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateURL(ctx, f.url("a")))
+
+	// One conflict leaves out every URL
+	dup := f.url("c")
+	dup.LongUrl = f.longURL("a")
+	urls := []*urlshortener.URLSchema{f.url("b"), dup}
+	errs, err := repo.CreateURLs(ctx, urls, true)
+	require.NoError(t, err)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], urlshortener.ErrDuplicateURL)
+	assert.Zero(t, urls[0].ID, "a URL that was rolled back should have a zero ID")
+
+	got, err := repo.ReadURLBySlug(ctx, "", f.slug("b"))
+	require.NoError(t, err)
+	assert.Nil(t, got, "no URL should be stored when one fails")
+
+	// Without conflicts they are all stored
+	urls = []*urlshortener.URLSchema{f.url("b"), f.url("c")}
+	errs, err = repo.CreateURLs(ctx, urls, true)
+	require.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, errs)
+	for _, name := range []string{"b", "c"} {
+		got, err := repo.ReadURLBySlug(ctx, "", f.slug(name))
+		require.NoError(t, err)
+		assert.NotNil(t, got, "every URL should be stored")
+	}
+}
+
+func testLongURLs(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	// Destinations of several kilobytes that only differ at the very end
+	long := f.url("long")
+	long.LongUrl += "?sig=" + strings.Repeat("x", 4096) + "1"
+	require.NoError(t, repo.CreateURL(ctx, long))
+
+	other := f.url("other")
+	other.LongUrl = long.LongUrl[:len(long.LongUrl)-1] + "2"
+	require.NoError(t, repo.CreateURL(ctx, other), "long URLs that share a prefix should not collide")
+
+	got, err := repo.ReadURL(ctx, "", long.LongUrl)
+	require.NoError(t, err)
+	require.NotNil(t, got, "ReadURL should find a long URL")
+	assert.Equal(t, long.Slug, got.Slug)
+	assert.Equal(t, long.LongUrl, got.LongUrl, "the long URL should be stored in full")
+
+	dup := f.url("dup")
+	dup.LongUrl = long.LongUrl
+	assert.ErrorIs(t, repo.CreateURL(ctx, dup), urlshortener.ErrDuplicateURL)
+}
+
+func testUpdate(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateURL(ctx, f.url("a")))
+	require.NoError(t, repo.UpdateURL(ctx, "", f.longURL("a"), f.longURL("new")))
+
+	got, err := repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, f.longURL("new"), got.LongUrl, "UpdateURL should change the long URL")
+	assert.Equal(t, f.longURL("new"), got.OriginalUrl, "UpdateURL should change the original URL")
+
+	got, err = repo.ReadURL(ctx, "", f.longURL("new"))
+	require.NoError(t, err)
+	require.NotNil(t, got, "ReadURL should find the URL under its new long URL")
+	assert.Equal(t, f.slug("a"), got.Slug)
+
+	got, err = repo.ReadURL(ctx, "", f.longURL("a"))
+	require.NoError(t, err)
+	assert.Nil(t, got, "ReadURL should not find the URL under its old long URL")
+}
+
+func testUpdateConflict(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateURL(ctx, f.url("a")))
+	require.NoError(t, repo.CreateURL(ctx, f.url("b")))
+
+	assert.ErrorIs(t, repo.UpdateURL(ctx, "", f.longURL("a"), f.longURL("b")), urlshortener.ErrDuplicateURL)
+
+	got, err := repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, f.longURL("a"), got.LongUrl, "a rejected update should leave the URL unchanged")
+
+	// A link update is stored all together or not at all
+	end := time.Now().Add(time.Hour).UTC()
+	taken := f.longURL("b")
+	update := urlshortener.LinkUpdate{LongURL: &taken, Window: &urlshortener.Window{NotAfter: &end}, Tags: &[]string{"docs"}}
+	assert.ErrorIs(t, repo.UpdateLink(ctx, "", f.slug("a"), update), urlshortener.ErrDuplicateURL)
+
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, f.longURL("a"), got.LongUrl)
+	assert.Nil(t, got.NotAfter, "a rejected update should not change the window")
+	assert.Empty(t, got.Tags, "a rejected update should not change the tags")
+
+	moved := f.longURL("moved")
+	update.LongURL, update.OriginalURL = &moved, strings.ToUpper(moved)
+	require.NoError(t, repo.UpdateLink(ctx, "", f.slug("a"), update))
+
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, moved, got.LongUrl)
+	assert.Equal(t, strings.ToUpper(moved), got.OriginalUrl, "UpdateLink should store the submitted long URL")
+	if assert.NotNil(t, got.NotAfter) {
+		assert.True(t, end.Equal(*got.NotAfter))
+	}
+	assert.Equal(t, []string{"docs"}, got.Tags)
+}
+
+func testDelete(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateURL(ctx, f.url("a")))
+	require.NoError(t, repo.CreateURL(ctx, f.url("b")))
+	require.NoError(t, repo.DeleteURL(ctx, "", f.longURL("a")))
+
+	got, err := repo.ReadURL(ctx, "", f.longURL("a"))
+	require.NoError(t, err)
+	assert.Nil(t, got, "ReadURL should not find a deleted URL")
+
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	assert.Nil(t, got, "ReadURLBySlug should not find a deleted URL")
+
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("b"))
+	require.NoError(t, err)
+	assert.NotNil(t, got, "DeleteURL should only delete the given URL")
+
+	assert.NoError(t, repo.DeleteURL(ctx, "", f.longURL("a")), "deleting twice should not fail")
+
+	// A deleted URL keeps its slug but frees its long URL
+	again := f.url("again")
+	again.LongUrl = f.longURL("a")
+	require.NoError(t, repo.CreateURL(ctx, again), "a deleted URL should free its long URL")
+	got, err = repo.ReadURL(ctx, "", f.longURL("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, f.slug("again"), got.Slug)
+
+	reused := f.url("a")
+	reused.LongUrl = f.longURL("c")
+	assert.ErrorIs(t, repo.CreateURL(ctx, reused), urlshortener.ErrSlugTaken, "a deleted URL should keep its slug")
+}
+
+func testExpiry(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	expired := f.url("expired")
+	expired.ExpiresAt = &past
+	expiring := f.url("expiring")
+	expiring.ExpiresAt = &future
+	require.NoError(t, repo.CreateURL(ctx, expired))
+	require.NoError(t, repo.CreateURL(ctx, expiring))
+	require.NoError(t, repo.CreateURL(ctx, f.url("forever")))
+
+	// Expired URLs are still read until they are swept, so callers can tell them from missing ones
+	got, err := repo.ReadURLBySlug(ctx, "", f.slug("expired"))
+	require.NoError(t, err)
+	require.NotNil(t, got, "ReadURLBySlug should find an expired URL that was not swept")
+	require.NotNil(t, got.ExpiresAt)
+	assert.WithinDuration(t, past, *got.ExpiresAt, time.Second)
+
+	n, err := repo.DeleteExpiredURLs(ctx, now, false)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, n, int64(1), "DeleteExpiredURLs should count the deleted URLs")
+
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("expired"))
+	require.NoError(t, err)
+	assert.Nil(t, got, "DeleteExpiredURLs should delete expired URLs")
+
+	for _, name := range []string{"expiring", "forever"} {
+		got, err = repo.ReadURLBySlug(ctx, "", f.slug(name))
+		require.NoError(t, err)
+		assert.NotNil(t, got, "DeleteExpiredURLs should keep URL %s", name)
+	}
+
+	// The long URL of a swept URL can be shortened again
+	again := f.url("again")
+	again.LongUrl = f.longURL("expired")
+	assert.NoError(t, repo.CreateURL(ctx, again), "a swept URL should free its long URL")
+
+	// Soft-deleted URLs keep their slug, purged ones free it
+	taken := f.url("other")
+	taken.Slug = f.slug("expired")
+	assert.ErrorIs(t, repo.CreateURL(ctx, taken), urlshortener.ErrSlugTaken)
+
+	_, err = repo.DeleteExpiredURLs(ctx, now, true)
+	require.NoError(t, err)
+	assert.NoError(t, repo.CreateURL(ctx, taken), "a purged URL should free its slug")
+
+	// Sweeping later catches the URLs that expired since
+	_, err = repo.DeleteExpiredURLs(ctx, future, true)
+	require.NoError(t, err)
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("expiring"))
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("forever"))
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+}
+
+// limitedURL is like url, but serves only clicks redirects
+func (f *fixture) limitedURL(name string, clicks int64) *urlshortener.URLSchema {
+	url := f.url(name)
+	url.ClicksLeft = &clicks
+	return url
+}
+
+func testClickLimit(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateURL(ctx, f.limitedURL("a", 2)))
+	require.NoError(t, repo.CreateURL(ctx, f.url("unlimited")))
+
+	for i := 0; i < 2; i++ {
+		ok, err := repo.ConsumeClick(ctx, "", f.slug("a"))
+		require.NoError(t, err)
+		assert.True(t, ok, "click %d should be consumed", i+1)
+	}
+
+	ok, err := repo.ConsumeClick(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	assert.False(t, ok, "ConsumeClick should report a used up link")
+
+	got, err := repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got, "a used up link is read until it is swept")
+	require.NotNil(t, got.ClicksLeft)
+	assert.Equal(t, int64(0), *got.ClicksLeft)
+	assert.True(t, got.Expired(time.Now()))
+
+	ok, err = repo.ConsumeClick(ctx, "", f.slug("unlimited"))
+	require.NoError(t, err)
+	assert.False(t, ok, "ConsumeClick should leave links without a limit alone")
+	ok, err = repo.ConsumeClick(ctx, "", f.slug("missing"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Used up links are swept with the expired ones
+	_, err = repo.DeleteExpiredURLs(ctx, time.Now(), false)
+	require.NoError(t, err)
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	assert.Nil(t, got, "DeleteExpiredURLs should delete used up links")
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("unlimited"))
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+}
+
+func testConcurrentClicks(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	const clicks, n = 5, 20
+	require.NoError(t, repo.CreateURL(ctx, f.limitedURL("a", clicks)))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	consumed := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := repo.ConsumeClick(ctx, "", f.slug("a"))
+			assert.NoError(t, err)
+			if ok {
+				mu.Lock()
+				consumed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, clicks, consumed, "every click should be handed out exactly once")
+}
+
+func testWindow(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+	launch := time.Now().Add(time.Hour).Truncate(time.Second)
+	end := launch.Add(24 * time.Hour)
+
+	url := f.url("a")
+	url.NotBefore = &launch
+	require.NoError(t, repo.CreateURL(ctx, url))
+	require.NoError(t, repo.CreateURL(ctx, f.url("b")))
+
+	got, err := repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.NotNil(t, got.NotBefore)
+	assert.True(t, launch.Equal(*got.NotBefore))
+	assert.Nil(t, got.NotAfter)
+
+	// Windows are replaced as a whole
+	require.NoError(t, repo.UpdateLink(ctx, "", f.slug("a"), urlshortener.LinkUpdate{Window: &urlshortener.Window{NotAfter: &end}}))
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Nil(t, got.NotBefore, "UpdateLink should clear a bound that is not given")
+	require.NotNil(t, got.NotAfter)
+	assert.True(t, end.Equal(*got.NotAfter))
+
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("b"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Nil(t, got.NotAfter, "UpdateLink should only update the given URL")
+
+	require.NoError(t, repo.UpdateLink(ctx, "", f.slug("a"), urlshortener.LinkUpdate{Window: &urlshortener.Window{}}))
+	got, err = repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Nil(t, got.NotAfter)
+
+	assert.NoError(t, repo.UpdateLink(ctx, "", f.slug("missing"), urlshortener.LinkUpdate{Window: &urlshortener.Window{NotBefore: &launch}}), "updating a missing URL should not fail")
+}
+
+func testClicks(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateURL(ctx, f.url("a")))
+	require.NoError(t, repo.CreateURL(ctx, f.url("b")))
+	a, err := repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	b, err := repo.ReadURLBySlug(ctx, "", f.slug("b"))
+	require.NoError(t, err)
+
+	since := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	click := func(id uint, at time.Time) urlshortener.ClickEvent {
+		return urlshortener.ClickEvent{URLID: id, ClickedAt: at, Referrer: "https://example.org/", UserAgent: "test", Country: "NL", IP: "192.0.2.0"}
+	}
+	require.NoError(t, repo.RecordClicks(ctx, []urlshortener.ClickEvent{
+		click(a.ID, since.Add(-48*time.Hour)),
+		click(a.ID, since.Add(time.Minute)),
+		click(a.ID, since.Add(2*time.Minute).In(time.FixedZone("UTC+2", 2*60*60))),
+		click(a.ID, since.Add(61*time.Minute)),
+		click(b.ID, since.Add(time.Minute)),
+	}))
+
+	stats, err := repo.ClickStats(ctx, a.ID, since, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), stats.Total, "ClickStats should count clicks before since in the total")
+	require.Len(t, stats.Buckets, 2, "ClickStats should only return buckets since since")
+	assert.True(t, since.Equal(stats.Buckets[0].Start), "got bucket %s, want %s", stats.Buckets[0].Start, since)
+	assert.Equal(t, int64(2), stats.Buckets[0].Clicks)
+	assert.True(t, since.Add(time.Hour).Equal(stats.Buckets[1].Start), "got bucket %s, want %s", stats.Buckets[1].Start, since.Add(time.Hour))
+	assert.Equal(t, int64(1), stats.Buckets[1].Clicks)
+
+	stats, err = repo.ClickStats(ctx, b.ID, since, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Total)
+
+	// Purging a URL purges its clicks, so a later URL with the same ID starts from zero
+	past := time.Now().Add(-time.Hour)
+	expired := f.url("expired")
+	expired.ExpiresAt = &past
+	require.NoError(t, repo.CreateURL(ctx, expired))
+	expired, err = repo.ReadURLBySlug(ctx, "", f.slug("expired"))
+	require.NoError(t, err)
+	require.NoError(t, repo.RecordClicks(ctx, []urlshortener.ClickEvent{click(expired.ID, since)}))
+
+	_, err = repo.DeleteExpiredURLs(ctx, time.Now(), true)
+	require.NoError(t, err)
+	stats, err = repo.ClickStats(ctx, expired.ID, since, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Total, "purging a URL should purge its clicks")
+	assert.Empty(t, stats.Buckets)
+}
+
+func testTags(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	url := f.url("a")
+	url.Tags = []string{"docs", "news"}
+	require.NoError(t, repo.CreateURL(ctx, url))
+	require.NoError(t, repo.CreateURL(ctx, f.url("b")))
+
+	got, err := repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, []string{"docs", "news"}, got.Tags)
+
+	got, err = repo.ReadURL(ctx, "", f.longURL("b"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Empty(t, got.Tags)
+
+	// Tags are replaced as a whole
+	require.NoError(t, repo.UpdateLink(ctx, "", f.slug("a"), urlshortener.LinkUpdate{Tags: &[]string{"archive"}}))
+	got, err = repo.ReadURL(ctx, "", f.longURL("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, []string{"archive"}, got.Tags)
+
+	require.NoError(t, repo.UpdateLink(ctx, "", f.slug("a"), urlshortener.LinkUpdate{Tags: &[]string{}}))
+	got, err = repo.ReadURL(ctx, "", f.longURL("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Empty(t, got.Tags, "UpdateLink without tags should remove them")
+
+	assert.NoError(t, repo.UpdateLink(ctx, "", f.slug("missing"), urlshortener.LinkUpdate{Tags: &[]string{"docs"}}), "tagging a missing URL should not fail")
+}
+
+// listed returns the slugs of urls, in order
+func listed(urls []urlshortener.URLSchema) []string {
+	slugs := make([]string, len(urls))
+	for i, url := range urls {
+		slugs[i] = url.Slug
+	}
+	return slugs
+}
+
+func testList(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+	brand := f.prefix + ".example.org"
+	created := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+
+	// Links are listed per domain, so other subtests sharing the store do not show up
+	create := func(name, longURL string, age time.Duration, tags ...string) *urlshortener.URLSchema {
+		url := f.domainURL(brand, name)
+		url.LongUrl = longURL
+		url.CreatedAt = created.Add(age)
+		url.Tags = tags
+		require.NoError(t, repo.CreateURL(ctx, url))
+		return url
+	}
+	a := create("a", "https://Docs.Example.NET/guide", 0, "docs")
+	b := create("b", "https://example.org/100%_sure", time.Second)
+	c := create("c", "https://docs.example.net/news", 2*time.Second, "docs", "news")
+	create("deleted", "https://docs.example.net/old", 3*time.Second, "docs")
+	require.NoError(t, repo.DeleteURL(ctx, brand, "https://docs.example.net/old"))
+	require.NoError(t, repo.RecordClicks(ctx, []urlshortener.ClickEvent{
+		{URLID: a.ID, ClickedAt: time.Now()},
+		{URLID: c.ID, ClickedAt: time.Now()},
+		{URLID: c.ID, ClickedAt: time.Now()},
+	}))
+
+	list := func(filter urlshortener.URLFilter) []string {
+		filter.Domain = brand
+		filter.Limit = 10
+		urls, err := repo.ListURLs(ctx, filter)
+		require.NoError(t, err)
+		return listed(urls)
+	}
+
+	assert.Equal(t, []string{c.Slug, b.Slug, a.Slug}, list(urlshortener.URLFilter{}), "ListURLs should list the newest first and leave out deleted URLs")
+	assert.Equal(t, []string{a.Slug, b.Slug, c.Slug}, list(urlshortener.URLFilter{Ascending: true}))
+	assert.Equal(t, []string{c.Slug, a.Slug, b.Slug}, list(urlshortener.URLFilter{Sort: urlshortener.SortClicks}), "ListURLs should sort by recorded clicks")
+	assert.Equal(t, []string{b.Slug, a.Slug, c.Slug}, list(urlshortener.URLFilter{Sort: urlshortener.SortClicks, Ascending: true}))
+
+	assert.Equal(t, []string{c.Slug, a.Slug}, list(urlshortener.URLFilter{Host: "docs.example.NET"}), "hosts should match regardless of case")
+	assert.Equal(t, []string{a.Slug}, list(urlshortener.URLFilter{Contains: "GUIDE"}), "substrings should match regardless of case")
+	assert.Equal(t, []string{c.Slug}, list(urlshortener.URLFilter{Contains: f.slug("c")}), "substrings should match the slug")
+	assert.Equal(t, []string{b.Slug}, list(urlshortener.URLFilter{Contains: "0%_s"}), "wildcards should match literally")
+	assert.Empty(t, list(urlshortener.URLFilter{Contains: "0_"}))
+	assert.Equal(t, []string{c.Slug, a.Slug}, list(urlshortener.URLFilter{Tag: "docs"}))
+	assert.Equal(t, []string{c.Slug}, list(urlshortener.URLFilter{Tag: "news"}))
+
+	since, before := created.Add(time.Second), created.Add(2*time.Second)
+	assert.Equal(t, []string{b.Slug}, list(urlshortener.URLFilter{CreatedSince: &since, CreatedBefore: &before}), "the creation range should include its start only")
+
+	urls, err := repo.ListURLs(ctx, urlshortener.URLFilter{Domain: brand, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, urls, 3)
+	assert.Equal(t, int64(2), urls[0].Clicks, "ListURLs should return the recorded clicks")
+	assert.Equal(t, []string{"docs", "news"}, urls[0].Tags, "ListURLs should return the tags")
+	assert.True(t, urls[0].CreatedAt.Equal(c.CreatedAt), "CreateURL should keep a given creation time")
+
+	urls, err = repo.ListURLs(ctx, urlshortener.URLFilter{Domain: "other." + brand, Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, urls, "ListURLs should not list URLs of another domain")
+}
+
+func testListPages(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+	brand := f.prefix + ".example.org"
+
+	// Links created at the same time are told apart by their ID
+	created := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	var want []string
+	for i := 0; i < 5; i++ {
+		url := f.domainURL(brand, fmt.Sprint(i))
+		url.CreatedAt = created
+		if i >= 3 {
+			url.CreatedAt = created.Add(time.Second)
+		}
+		require.NoError(t, repo.CreateURL(ctx, url))
+		want = append([]string{url.Slug}, want...)
+	}
+
+	for _, sort := range []string{urlshortener.SortCreated, urlshortener.SortClicks} {
+		filter := urlshortener.URLFilter{Domain: brand, Sort: sort, Limit: 2}
+		var got []string
+		for page := 0; page < 4; page++ {
+			urls, err := repo.ListURLs(ctx, filter)
+			require.NoError(t, err)
+			if len(urls) == 0 {
+				break
+			}
+			assert.LessOrEqual(t, len(urls), 2, "ListURLs should return at most Limit URLs")
+			got = append(got, listed(urls)...)
+
+			last := urls[len(urls)-1]
+			filter.After = &urlshortener.URLCursor{ID: last.ID, CreatedAt: last.CreatedAt, Clicks: last.Clicks}
+		}
+		assert.Equal(t, want, got, "pages sorted by %s should list every URL once", sort)
+	}
+}
+
+func testExport(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	first := f.url("a")
+	first.Tags = []string{"docs"}
+	require.NoError(t, repo.CreateURL(ctx, first))
+	require.NoError(t, repo.CreateURL(ctx, f.domainURL(f.prefix+".example.org", "b")))
+	require.NoError(t, repo.CreateURL(ctx, f.url("c")))
+	require.NoError(t, repo.DeleteURL(ctx, "", f.longURL("c")))
+
+	// Other subtests may share the store, so only this fixture's URLs are looked at
+	exported := func(deleted bool) []string {
+		t.Helper()
+		urls, err := repo.ExportURLs(ctx, first.ID-1, 1000, deleted)
+		require.NoError(t, err)
+		var slugs []string
+		for _, url := range urls {
+			if strings.HasPrefix(url.Slug, f.prefix) {
+				slugs = append(slugs, url.Slug)
+			}
+		}
+		return slugs
+	}
+	assert.Equal(t, []string{f.slug("a"), f.slug("b")}, exported(false), "ExportURLs should return the URLs of every domain by ID")
+	assert.Equal(t, []string{f.slug("a"), f.slug("b"), f.slug("c")}, exported(true), "ExportURLs should return soft-deleted URLs when asked")
+
+	urls, err := repo.ExportURLs(ctx, first.ID-1, 1, true)
+	require.NoError(t, err)
+	require.Len(t, urls, 1, "ExportURLs should return at most limit URLs")
+	assert.Equal(t, f.slug("a"), urls[0].Slug)
+	assert.Equal(t, []string{"docs"}, urls[0].Tags)
+
+	urls, err = repo.ExportURLs(ctx, first.ID, 1000, true)
+	require.NoError(t, err)
+	for _, url := range urls {
+		assert.NotEqual(t, first.ID, url.ID, "ExportURLs should start after afterID")
+	}
+
+	// Imported URLs keep their timestamps and deleted state
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	deleted := created.Add(time.Hour)
+	imported := f.url("d")
+	imported.CreatedAt, imported.DeletedAt = created, &deleted
+	errs, err := repo.CreateURLs(ctx, []*urlshortener.URLSchema{imported}, false)
+	require.NoError(t, err)
+	require.NoError(t, errs[0])
+
+	got, err := repo.ReadURLBySlug(ctx, "", f.slug("d"))
+	require.NoError(t, err)
+	assert.Nil(t, got, "a URL stored as deleted should not be read")
+
+	urls, err = repo.ExportURLs(ctx, imported.ID-1, 1, true)
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.True(t, created.Equal(urls[0].CreatedAt), "the creation time should be kept")
+	require.NotNil(t, urls[0].DeletedAt)
+	assert.True(t, deleted.Equal(*urls[0].DeletedAt), "the deletion time should be kept")
+}
+
+func testConcurrentCreate(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	const n = 20
+
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = repo.CreateURL(ctx, f.url(fmt.Sprint(i)))
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		assert.NoError(t, err, "creating distinct URL %d concurrently", i)
+	}
+
+	for i := 0; i < n; i++ {
+		got, err := repo.ReadURLBySlug(ctx, "", f.slug(fmt.Sprint(i)))
+		require.NoError(t, err)
+		assert.NotNil(t, got, "URL %d should have been stored", i)
+	}
+}
+
+func testConcurrentSameSlug(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	const n = 20
+
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			url := f.url(fmt.Sprint(i))
+			url.Slug = f.slug("shared")
+			errs[i] = repo.CreateURL(ctx, url)
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+		assert.ErrorIs(t, err, urlshortener.ErrSlugTaken)
+	}
+	assert.Equal(t, 1, created, "exactly one create should claim the slug")
+}
+
+func testConcurrentReadWrite(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	const n = 20
+
+	require.NoError(t, repo.CreateURL(ctx, f.url("read")))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*n)
+	for i := 0; i < n; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repo.CreateURL(ctx, f.url(fmt.Sprint(i)))
+		}(i)
+		go func() {
+			defer wg.Done()
+			got, err := repo.ReadURLBySlug(ctx, "", f.slug("read"))
+			if err == nil && got == nil {
+				err = fmt.Errorf("slug %s disappeared during concurrent writes", f.slug("read"))
+			}
+			errs <- err
+		}()
+		go func(i int) {
+			defer wg.Done()
+			errs <- repo.DeleteURL(ctx, "", f.longURL(fmt.Sprint(i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func testDomains(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+	brand := f.prefix + ".example.org"
+
+	// The same slug and long URL can be used once per domain
+	require.NoError(t, repo.CreateURL(ctx, f.url("a")))
+	require.NoError(t, repo.CreateURL(ctx, f.domainURL(brand, "a")), "slugs and long URLs should be unique per domain")
+
+	dup := f.domainURL(brand, "b")
+	dup.Slug = f.slug("a")
+	err := repo.CreateURL(ctx, dup)
+	assert.ErrorIs(t, err, urlshortener.ErrSlugTaken, "a taken slug should be reported within its domain")
+
+	// Lookups only see their own domain
+	got, err := repo.ReadURLBySlug(ctx, brand, f.slug("a"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, brand, got.Domain)
+	assert.Equal(t, "https://"+brand+"/"+f.slug("a"), got.ShortUrl)
+
+	got, err = repo.ReadURLBySlug(ctx, "other."+brand, f.slug("a"))
+	require.NoError(t, err)
+	assert.Nil(t, got, "ReadURLBySlug should not find URLs of another domain")
+
+	// Updates and deletes only touch their own domain
+	require.NoError(t, repo.UpdateURL(ctx, brand, f.longURL("a"), f.longURL("b")))
+	got, err = repo.ReadURL(ctx, "", f.longURL("a"))
+	require.NoError(t, err)
+	assert.NotNil(t, got, "UpdateURL should not change URLs of another domain")
+
+	require.NoError(t, repo.DeleteURL(ctx, "", f.longURL("a")))
+	got, err = repo.ReadURL(ctx, brand, f.longURL("b"))
+	require.NoError(t, err)
+	assert.NotNil(t, got, "DeleteURL should not delete URLs of another domain")
+}
+
+func testSequence(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx := context.Background()
+
+	for want := uint64(1); want <= 3; want++ {
+		got, err := repo.NextSequence(ctx, f.slug("seq"))
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "sequences should count up from 1")
+	}
+
+	got, err := repo.NextSequence(ctx, f.slug("other"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), got, "sequences should be independent of each other")
+}
+
+func testConcurrentSequence(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	const n = 20
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	values := make([]uint64, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = repo.NextSequence(ctx, f.slug("seq"))
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool)
+	for i := range values {
+		require.NoError(t, errs[i])
+		assert.False(t, seen[values[i]], "value %d was handed out twice", values[i])
+		seen[values[i]] = true
+	}
+	for v := uint64(1); v <= n; v++ {
+		assert.True(t, seen[v], "value %d was skipped", v)
+	}
+}
+
+func testCancelledContext(t *testing.T, repo urlshortener.URLRepository, f *fixture) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.ErrorIs(t, repo.CreateURL(ctx, f.url("a")), context.Canceled, "CreateURL should honor the context")
+
+	_, err := repo.CreateURLs(ctx, []*urlshortener.URLSchema{f.url("b")}, false)
+	assert.ErrorIs(t, err, context.Canceled, "CreateURLs should honor the context")
+
+	_, err = repo.ReadURL(ctx, "", f.longURL("a"))
+	assert.ErrorIs(t, err, context.Canceled, "ReadURL should honor the context")
+
+	_, err = repo.ReadURLBySlug(ctx, "", f.slug("a"))
+	assert.ErrorIs(t, err, context.Canceled, "ReadURLBySlug should honor the context")
+
+	assert.ErrorIs(t, repo.UpdateURL(ctx, "", f.longURL("a"), f.longURL("b")), context.Canceled, "UpdateURL should honor the context")
+	assert.ErrorIs(t, repo.UpdateLink(ctx, "", f.slug("a"), urlshortener.LinkUpdate{Window: &urlshortener.Window{}}), context.Canceled, "UpdateLink should honor the context")
+	assert.ErrorIs(t, repo.DeleteURL(ctx, "", f.longURL("a")), context.Canceled, "DeleteURL should honor the context")
+	assert.ErrorIs(t, repo.DeleteURLBySlug(ctx, "", f.slug("a")), context.Canceled, "DeleteURLBySlug should honor the context")
+
+	_, err = repo.NextSequence(ctx, f.slug("seq"))
+	assert.ErrorIs(t, err, context.Canceled, "NextSequence should honor the context")
+
+	_, err = repo.ConsumeClick(ctx, "", f.slug("a"))
+	assert.ErrorIs(t, err, context.Canceled, "ConsumeClick should honor the context")
+
+	_, err = repo.DeleteExpiredURLs(ctx, time.Now(), false)
+	assert.ErrorIs(t, err, context.Canceled, "DeleteExpiredURLs should honor the context")
+
+	assert.ErrorIs(t, repo.RecordClicks(ctx, []urlshortener.ClickEvent{{URLID: 1, ClickedAt: time.Now()}}), context.Canceled, "RecordClicks should honor the context")
+
+	_, err = repo.ClickStats(ctx, 1, time.Now(), time.Hour)
+	assert.ErrorIs(t, err, context.Canceled, "ClickStats should honor the context")
+
+	_, err = repo.ListURLs(ctx, urlshortener.URLFilter{Limit: 10})
+	assert.ErrorIs(t, err, context.Canceled, "ListURLs should honor the context")
+
+	_, err = repo.ExportURLs(ctx, 0, 10, true)
+	assert.ErrorIs(t, err, context.Canceled, "ExportURLs should honor the context")
+
+	got, err := repo.ReadURLBySlug(context.Background(), "", f.slug("a"))
+	require.NoError(t, err)
+	assert.Nil(t, got, "a cancelled create should not store the URL")
+}
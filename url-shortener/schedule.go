@@ -0,0 +1,43 @@
+package urlshortener
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidSchedule is returned when an activation window ends before it starts or has already ended
+var ErrInvalidSchedule = errors.New("invalid activation window")
+
+// ScheduleConfig is a struct that represents how links outside their activation window are served
+type ScheduleConfig struct {
+	// ComingSoon serves the coming soon page before a link goes live, instead of a plain 404
+	ComingSoon bool `yaml:"coming_soon"`
+}
+
+// Window is a struct that represents when a link redirects. Either bound may be nil for a window that is open on that side.
+type Window struct {
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+}
+
+// validate checks that the window is still to come or open at now and returns it in UTC
+func (w Window) validate(now time.Time) (Window, error) {
+	if w.NotBefore != nil && w.NotAfter != nil && !w.NotAfter.After(*w.NotBefore) {
+		return Window{}, fmt.Errorf("%w: not_after must be after not_before", ErrInvalidSchedule)
+	}
+	if w.NotAfter != nil && !w.NotAfter.After(now) {
+		return Window{}, fmt.Errorf("%w: %s has already passed", ErrInvalidSchedule, w.NotAfter.Format(time.RFC3339))
+	}
+
+	var utc Window
+	if w.NotBefore != nil {
+		t := w.NotBefore.UTC()
+		utc.NotBefore = &t
+	}
+	if w.NotAfter != nil {
+		t := w.NotAfter.UTC()
+		utc.NotAfter = &t
+	}
+	return utc, nil
+}
@@ -0,0 +1,52 @@
+package urlshortener
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowValidate(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name    string
+		window  Window
+		wantErr bool
+	}{
+		{name: "Open", window: Window{}},
+		{name: "Launch in the future", window: Window{NotBefore: at(time.Hour)}},
+		{name: "Launch in the past", window: Window{NotBefore: at(-time.Hour)}},
+		{name: "Closes in the future", window: Window{NotAfter: at(time.Hour)}},
+		{name: "Bounded", window: Window{NotBefore: at(time.Hour), NotAfter: at(2 * time.Hour)}},
+		{name: "Closes before launch", window: Window{NotBefore: at(2 * time.Hour), NotAfter: at(time.Hour)}, wantErr: true},
+		{name: "Empty", window: Window{NotBefore: at(time.Hour), NotAfter: at(time.Hour)}, wantErr: true},
+		{name: "Already closed", window: Window{NotAfter: at(-time.Hour)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.window.validate(now)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidSchedule)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.window, got)
+		})
+	}
+
+	// Bounds are returned in UTC
+	local := time.Date(2024, 1, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	got, err := Window{NotBefore: &local}.validate(now)
+	assert.NoError(t, err)
+	if assert.NotNil(t, got.NotBefore) {
+		assert.Equal(t, time.UTC, got.NotBefore.Location())
+		assert.True(t, local.Equal(*got.NotBefore))
+	}
+}
@@ -1,70 +1,659 @@
 package urlshortener
 
 import (
+	"context"
 	"crypto/rand"
-	"encoding/base64"
 	"errors"
+	"expvar"
 	"fmt"
 	"log"
 	"net/url"
 	"strings"
+	"time"
 )
 
-const domain string = "http://localhost:8080/"
+const (
+	// SelfLinksReject refuses destinations on one of our own short domains
+	SelfLinksReject = "reject"
+	// SelfLinksResolve replaces a destination on one of our own short domains with the URL it redirects to
+	SelfLinksResolve = "resolve"
+)
+
+// maxSelfLinkHops bounds the number of short links followed when resolving a self link
+const maxSelfLinkHops = 10
+
+// DefaultDomain is the base URL of short links when no domain is configured
+const DefaultDomain = "http://localhost:8080/"
+
+// DefaultMaxURLLength is the longest destination URL accepted when no limit is configured, in bytes
+const DefaultMaxURLLength = 8192
+
+// ErrURLTooLong is returned when a destination URL is longer than the configured maximum
+var ErrURLTooLong = errors.New("URL is too long")
+
+// ErrSlugSpaceExhausted is returned when no free slug was found within the configured number of attempts
+var ErrSlugSpaceExhausted = errors.New("no free slug found")
+
+// ErrInvalidURL is returned when a destination URL is malformed, too long or not allowed
+var ErrInvalidURL = errors.New("invalid URL")
+
+// ErrSelfLink is returned when a destination URL points back to one of the configured short domains
+var ErrSelfLink = fmt.Errorf("%w: URL points to this shortener", ErrInvalidURL)
+
+// ErrRedirectCycle is the ErrSelfLink returned when following short links leads back to where it started
+var ErrRedirectCycle = fmt.Errorf("%w: redirect cycle", ErrSelfLink)
+
+// ErrUnknownDomain is returned when a request selects a domain that is not configured
+var ErrUnknownDomain = errors.New("unknown domain")
+
+// ErrInvalidSlug is returned when a custom slug is too short, too long or contains characters other than letters, digits, '-' and '_'
+var ErrInvalidSlug = errors.New("invalid slug")
+
+// ErrSlugReserved is the ErrSlugTaken returned when a custom slug is a reserved word
+var ErrSlugReserved = fmt.Errorf("%w: slug is reserved", ErrSlugTaken)
+
+// metrics is published on /debug/vars of the AdminHandler for monitoring
+var metrics = expvar.NewMap("urlshortener")
+
+// ServiceConfig is a struct that represents the settings of the URL service
+type ServiceConfig struct {
+	// Domain is the public base URL that slugs are appended to, such as https://sho.rt/
+	Domain string `yaml:"domain"`
+	// Domains lists the base URLs of additional branded domains that links can be minted under
+	Domains []string `yaml:"domains"`
+	// MaxURLLength is the longest destination URL accepted, in bytes of its UTF-8 encoding
+	MaxURLLength int               `yaml:"max_url_length"`
+	Slug         SlugConfig        `yaml:"slug"`
+	Destinations DestinationConfig `yaml:"destinations"`
+	// SelfLinks is reject or resolve, what to do with destinations on one of our own domains
+	SelfLinks string          `yaml:"self_links"`
+	Canonical CanonicalConfig `yaml:"canonical"`
+	Expiry    ExpiryConfig    `yaml:"expiry"`
+	Passwords PasswordConfig  `yaml:"passwords"`
+	Schedule  ScheduleConfig  `yaml:"schedule"`
+	Redirects RedirectConfig  `yaml:"redirects"`
+	Clicks    ClickConfig     `yaml:"clicks"`
+	// ClientIPHeader is the header a trusted reverse proxy puts the client address in, such as
+	// X-Forwarded-For. When it is empty the address of the connection is used.
+	ClientIPHeader string `yaml:"client_ip_header"`
+}
+
+// ShortDomain is a struct that represents a public domain short URLs are minted under
+type ShortDomain struct {
+	// Host selects the domain in create requests and is matched against the Host header of redirects
+	Host string
+	// URL is the base URL that slugs are appended to
+	URL string
+	// key is stored in URLSchema.Domain, empty for the primary domain
+	key string
+	// hostname and path split URL for recognizing links to this domain
+	hostname string
+	path     string
+}
+
+// ShortURL returns the public short URL for a slug on this domain
+func (d ShortDomain) ShortURL(slug string) string {
+	return d.URL + slug
+}
+
+// Service is a struct that holds the repository and settings used to shorten and resolve URLs
+type Service struct {
+	repo         URLRepository
+	slugs        SlugGenerator
+	domains      []ShortDomain // the primary domain comes first
+	destinations *DestinationPolicy
+	unlockKey    []byte          // signs the cookies of unlocked links
+	attempts     *attemptLimiter // wrong passwords per link and client
+	clicks       chan ClickEvent // clicks waiting for RunClickRecorder
+	config       ServiceConfig
+}
+
+// NewService returns a Service storing URLs in repo, filling in defaults for unset config values
+func NewService(repo URLRepository, config ServiceConfig) (*Service, error) {
+	switch config.SelfLinks {
+	case "":
+		config.SelfLinks = SelfLinksReject
+	case SelfLinksReject, SelfLinksResolve:
+	default:
+		return nil, fmt.Errorf("unsupported self_links mode: %s", config.SelfLinks)
+	}
+
+	primary, err := newShortDomain(config.Domain, true)
+	if err != nil {
+		return nil, err
+	}
+	config.Domain = primary.URL
+
+	domains := []ShortDomain{primary}
+	for _, d := range config.Domains {
+		domain, err := newShortDomain(d, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range domains {
+			if other.Host == domain.Host {
+				return nil, fmt.Errorf("domain %s is configured twice", domain.Host)
+			}
+		}
+		domains = append(domains, domain)
+	}
+
+	if config.MaxURLLength <= 0 {
+		config.MaxURLLength = DefaultMaxURLLength
+	}
+	if config.Slug.Length <= 0 {
+		config.Slug.Length = 6
+	}
+	if config.Slug.GrowAfter <= 0 {
+		config.Slug.GrowAfter = 3
+	}
+	if config.Slug.MaxAttempts <= 0 {
+		config.Slug.MaxAttempts = 10
+	}
+	if config.Slug.MinCustomLength <= 0 {
+		config.Slug.MinCustomLength = 3
+	}
+	if config.Slug.MaxCustomLength <= 0 {
+		config.Slug.MaxCustomLength = 50
+	}
+	if config.Expiry.SweepInterval <= 0 {
+		config.Expiry.SweepInterval = DefaultSweepInterval
+	}
+	if config.Passwords.CookieTTL <= 0 {
+		config.Passwords.CookieTTL = DefaultUnlockTTL
+	}
+	if config.Passwords.MaxAttempts <= 0 {
+		config.Passwords.MaxAttempts = DefaultMaxPasswordAttempts
+	}
+	if config.Passwords.Lockout <= 0 {
+		config.Passwords.Lockout = DefaultPasswordLockout
+	}
+
+	if config.Redirects.Status == 0 {
+		config.Redirects.Status = DefaultRedirectStatus
+	}
+	if !validRedirectStatus(config.Redirects.Status) {
+		return nil, fmt.Errorf("unsupported redirect status: %d", config.Redirects.Status)
+	}
+	if config.Redirects.MaxAge <= 0 {
+		config.Redirects.MaxAge = DefaultRedirectMaxAge
+	}
+	if config.Clicks.Buffer <= 0 {
+		config.Clicks.Buffer = DefaultClickBuffer
+	}
+	if config.Clicks.BatchSize <= 0 {
+		config.Clicks.BatchSize = DefaultClickBatchSize
+	}
+	if config.Clicks.FlushInterval <= 0 {
+		config.Clicks.FlushInterval = DefaultClickFlushInterval
+	}
+
+	unlockKey := []byte(config.Passwords.CookieSecret)
+	if len(unlockKey) == 0 {
+		// Unlocked links lock again when the process restarts
+		unlockKey = make([]byte, 32)
+		if _, err := rand.Read(unlockKey); err != nil {
+			return nil, fmt.Errorf("error generating cookie secret: %w", err)
+		}
+	}
+
+	slugs, err := NewSlugGenerator(config.Slug, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	destinations, err := NewDestinationPolicy(config.Destinations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Service{
+		repo:         repo,
+		slugs:        slugs,
+		domains:      domains,
+		destinations: destinations,
+		unlockKey:    unlockKey,
+		attempts:     newAttemptLimiter(config.Passwords.MaxAttempts, config.Passwords.Lockout),
+		clicks:       make(chan ClickEvent, config.Clicks.Buffer),
+		config:       config,
+	}, nil
+}
+
+// Domains returns the domains short URLs can be minted under, the primary domain first
+func (s *Service) Domains() []ShortDomain {
+	return s.domains
+}
+
+// resolveDomain returns the domain selected by a create or lookup request, where an empty
+// host selects the primary domain
+func (s *Service) resolveDomain(host string) (ShortDomain, error) {
+	if host == "" {
+		return s.domains[0], nil
+	}
+	for _, d := range s.domains {
+		if strings.EqualFold(d.Host, host) {
+			return d, nil
+		}
+	}
+	return ShortDomain{}, fmt.Errorf("%w: %s", ErrUnknownDomain, host)
+}
+
+// domainForHost returns the domain whose links are served on the Host header of a request.
+// Unknown hosts, such as the address of a load balancer health check, get the primary domain.
+func (s *Service) domainForHost(host string) ShortDomain {
+	for _, d := range s.domains {
+		if strings.EqualFold(d.Host, host) {
+			return d
+		}
+	}
+	return s.domains[0]
+}
 
 type URL struct {
-	LongURL  string
-	ShortURL string
-	Slug     string
+	LongURL   string
+	ShortURL  string
+	Slug      string
+	ExpiresAt *time.Time `json:",omitempty"`
+	MaxClicks *int64     `json:",omitempty"`
+	NotBefore *time.Time `json:",omitempty"`
+	NotAfter  *time.Time `json:",omitempty"`
+	// RedirectStatus is only set when the link chose one
+	RedirectStatus int `json:",omitempty"`
 }
 
-func generateSlug(n int, u *URL) (*URL, error) {
-	b := make([]byte, n)
-	_, err := rand.Read(b)
+// Shorten validates the requested URL and stores it under the requested custom slug, or a new
+// one when none was requested. A taken custom slug is an error, while a taken generated slug is
+// retried with fresh slugs, adding a character after every GrowAfter collisions.
+func (s *Service) Shorten(ctx context.Context, req URLRequest) (*URLSchema, error) {
+	url, domain, err := s.prepareLink(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("error generating random bytes: %w", err)
+		return nil, err
 	}
 
-	u.Slug = base64.RawURLEncoding.EncodeToString(b)[:n] // use only the first n characters
-	return u, nil
+	if req.Slug != "" {
+		if err := createError(s.repo.CreateURL(ctx, url)); err != nil {
+			return nil, err
+		}
+		return url, nil
+	}
+
+	length := s.config.Slug.Length
+	for attempt := 1; ; attempt++ {
+		if err := s.assignSlug(ctx, url, domain, length); err != nil {
+			return nil, err
+		}
+
+		err = s.repo.CreateURL(ctx, url)
+		if err == nil {
+			return url, nil
+		}
+		if !errors.Is(err, ErrSlugTaken) {
+			return nil, createError(err)
+		}
+
+		if length, err = s.slugCollision(url, attempt, length); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// prepareLink validates req and returns the link it asks for, without a slug unless a custom one
+// was requested, and the domain the link is minted under
+func (s *Service) prepareLink(ctx context.Context, req URLRequest) (*URLSchema, ShortDomain, error) {
+	longURL, err := s.prepareDestination(ctx, req.URL, nil)
+	if errors.Is(err, ErrInvalidURL) {
+		return nil, ShortDomain{}, fieldError("url", err)
+	}
+	if err != nil {
+		return nil, ShortDomain{}, err
+	}
+
+	domain, err := s.resolveDomain(req.Domain)
+	if err != nil {
+		return nil, ShortDomain{}, fieldError("domain", err)
+	}
+
+	expires, err := expiresAt(req, time.Now())
+	if err != nil {
+		if req.TTL != "" {
+			return nil, ShortDomain{}, fieldError("ttl", err)
+		}
+		return nil, ShortDomain{}, fieldError("expires_at", err)
+	}
+	clicks, err := clicksLeft(req)
+	if err != nil {
+		return nil, ShortDomain{}, fieldError("max_clicks", err)
+	}
+
+	if err := validateRedirectStatus(req.RedirectStatus); err != nil {
+		return nil, ShortDomain{}, fieldError("redirect_status", err)
+	}
+
+	var window Window
+	if req.Window != nil {
+		if window, err = req.Window.validate(time.Now()); err != nil {
+			return nil, ShortDomain{}, fieldError("window", err)
+		}
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, ShortDomain{}, fieldError("tags", err)
+	}
+
+	url := &URLSchema{
+		Domain:         domain.key,
+		LongUrl:        longURL,
+		OriginalUrl:    req.URL,
+		ExpiresAt:      expires,
+		ClicksLeft:     clicks,
+		NotBefore:      window.NotBefore,
+		NotAfter:       window.NotAfter,
+		RedirectStatus: req.RedirectStatus,
+		Tags:           tags,
+	}
+	if req.Slug != "" {
+		if err := s.validateSlug(req.Slug); err != nil {
+			return nil, ShortDomain{}, fieldError("slug", err)
+		}
+		url.Slug = req.Slug
+		url.ShortUrl = domain.ShortURL(req.Slug)
+	}
+
+	// Hashing is deliberately slow, so it waits until everything else has been checked
+	if req.Password != "" {
+		if url.PasswordHash, err = hashPassword(req.Password); err != nil {
+			return nil, ShortDomain{}, fieldError("password", err)
+		}
+	}
+	return url, domain, nil
+}
+
+// assignSlug gives url a freshly generated slug of the given length on domain
+func (s *Service) assignSlug(ctx context.Context, url *URLSchema, domain ShortDomain, length int) error {
+	slug, err := s.slugs.Generate(ctx, length)
+	if err != nil {
+		return fmt.Errorf("error generating slug: %w", err)
+	}
+	url.Slug = slug
+	url.ShortUrl = domain.ShortURL(slug)
+	return nil
+}
+
+// slugCollision counts that the generated slug of url was taken on the given attempt. It returns
+// the length of the next slug to try, or an error once the attempts are used up.
+func (s *Service) slugCollision(url *URLSchema, attempt, length int) (int, error) {
+	metrics.Add("slug_collisions", 1)
+	log.Printf("Slug collision on %s (attempt %d of %d)", url.Slug, attempt, s.config.Slug.MaxAttempts)
+
+	if attempt >= s.config.Slug.MaxAttempts {
+		metrics.Add("slug_exhausted", 1)
+		return 0, fmt.Errorf("%w after %d attempts", ErrSlugSpaceExhausted, attempt)
+	}
+	if attempt%s.config.Slug.GrowAfter == 0 {
+		length++
+	}
+	return length, nil
+}
+
+// createError returns the error of storing a new link, naming the field that conflicts with an existing link
+func createError(err error) error {
+	if errors.Is(err, ErrSlugTaken) {
+		return fieldError("slug", err)
+	}
+	if errors.Is(err, ErrDuplicateURL) {
+		return fieldError("url", err)
+	}
+	return err
 }
 
-func (*URL) GenerateShortURL(longURL string) (*URL, error) {
-	u := &URL{
-		LongURL: longURL,
+// UpdateLink applies patch to link on domain: it points the link at patch.URL, replaces its
+// activation window with patch.Window and its tags with patch.Tags, leaving out whichever is nil.
+// All of them are checked first and stored together, and link is updated to match.
+func (s *Service) UpdateLink(ctx context.Context, domain ShortDomain, link *URLSchema, patch LinkPatch) error {
+	update := LinkUpdate{}
+	if patch.Window != nil {
+		w, err := patch.Window.validate(time.Now())
+		if err != nil {
+			return fieldError("window", err)
+		}
+		update.Window = &w
 	}
 
-	log.Printf("Generating short URL for: %s", u.LongURL)
+	if patch.Tags != nil {
+		tags, err := normalizeTags(*patch.Tags)
+		if err != nil {
+			return fieldError("tags", err)
+		}
+		update.Tags = &tags
+	}
 
-	err := validateURL(u.LongURL)
+	if patch.URL != nil {
+		// The new destination has to pass the same checks as a new link, and may not lead back to this one
+		longURL, err := s.prepareDestination(ctx, *patch.URL, link)
+		if errors.Is(err, ErrInvalidURL) {
+			return fieldError("url", err)
+		}
+		if err != nil {
+			return err
+		}
+		update.LongURL, update.OriginalURL = &longURL, *patch.URL
+	}
+
+	err := s.repo.UpdateLink(ctx, domain.key, link.Slug, update)
+	if errors.Is(err, ErrDuplicateURL) {
+		return fieldError("url", err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("invalid URL: %w", err)
+		return err
 	}
 
-	slug, err := generateSlug(6, u)
+	if update.LongURL != nil {
+		link.LongUrl, link.OriginalUrl = *update.LongURL, update.OriginalURL
+	}
+	if update.Window != nil {
+		link.NotBefore, link.NotAfter = update.Window.NotBefore, update.Window.NotAfter
+	}
+	if update.Tags != nil {
+		link.Tags = *update.Tags
+	}
+	return nil
+}
+
+// prepareDestination returns the URL a link to u should be stored with, which is its canonical form.
+// Links to one of our own short domains are rejected, or with SelfLinksResolve followed to their
+// final destination, so redirects never chain through the shortener. link is the link being
+// updated, if any, which must not be reached again. The returned URL is validated.
+func (s *Service) prepareDestination(ctx context.Context, u string, link *URLSchema) (string, error) {
+	u, err := s.canonicalURL(u)
 	if err != nil {
-		return nil, fmt.Errorf("error generating slug: %w", err)
+		return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+
+	visited := make(map[string]bool)
+	if link != nil {
+		visited[scoped(link.Domain, link.Slug)] = true
+	}
+
+	for hop := 0; ; hop++ {
+		domain, slug, ok := s.selfLink(u)
+		if !ok {
+			break
+		}
+		if s.config.SelfLinks != SelfLinksResolve {
+			return "", fmt.Errorf("%w: %s", ErrSelfLink, u)
+		}
+		if hop >= maxSelfLinkHops {
+			return "", fmt.Errorf("%w: more than %d short links in a row", ErrRedirectCycle, maxSelfLinkHops)
+		}
+
+		target, err := s.repo.ReadURLBySlug(ctx, domain.key, slug)
+		if err != nil {
+			return "", err
+		}
+		if target == nil {
+			return "", fmt.Errorf("%w: %s is not a short link", ErrSelfLink, u)
+		}
+		if target.Expired(time.Now()) {
+			return "", fmt.Errorf("%w: %s has expired", ErrSelfLink, u)
+		}
+		if target.ClicksLeft != nil {
+			// Copying the destination would let it be followed past the limit
+			return "", fmt.Errorf("%w: %s is click-limited", ErrSelfLink, u)
+		}
+		if target.PasswordHash != "" {
+			// Copying the destination would let it be followed without the password
+			return "", fmt.Errorf("%w: %s is password-protected", ErrSelfLink, u)
+		}
+		if target.NotBefore != nil || target.NotAfter != nil {
+			// Copying the destination would let it be followed outside the window
+			return "", fmt.Errorf("%w: %s is scheduled", ErrSelfLink, u)
+		}
+		if visited[scoped(target.Domain, target.Slug)] {
+			return "", fmt.Errorf("%w: %s leads back to %s", ErrRedirectCycle, u, target.ShortUrl)
+		}
+		visited[scoped(target.Domain, target.Slug)] = true
+		u = target.LongUrl
 	}
 
-	u.ShortURL = domain + slug.Slug
-	u.Slug = slug.Slug
+	if err := s.validateURL(ctx, u); err != nil {
+		return "", err
+	}
 	return u, nil
 }
 
-func validateURL(u string) error {
+// canonicalURL returns the form u is stored and looked up in
+func (s *Service) canonicalURL(u string) (string, error) {
+	return canonicalizeURL(u, s.config.Canonical.StripParams)
+}
+
+// findURL returns the link stored for longURL on domain, whichever of its equivalent forms is given
+func (s *Service) findURL(ctx context.Context, domain ShortDomain, longURL string) (*URLSchema, error) {
+	canonical, err := s.canonicalURL(longURL)
+	if err != nil {
+		// A URL that cannot be canonicalized was never stored
+		return nil, nil
+	}
+	return s.repo.ReadURL(ctx, domain.key, canonical)
+}
+
+// selfLink reports whether u points to one of the configured short domains and which slug it names.
+// Any port counts, since a different port on our own host is no less of a loop.
+func (s *Service) selfLink(u string) (ShortDomain, string, bool) {
+	parsedURL, err := url.Parse(u)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return ShortDomain{}, "", false
+	}
+
+	hostname := strings.TrimSuffix(strings.ToLower(parsedURL.Hostname()), ".")
+	for _, d := range s.domains {
+		if hostname != d.hostname {
+			continue
+		}
+		path := parsedURL.Path
+		if path == "" {
+			path = "/"
+		}
+		if strings.HasPrefix(path, d.path) {
+			return d, strings.TrimPrefix(path, d.path), true
+		}
+	}
+	return ShortDomain{}, "", false
+}
+
+// validateURL checks that u is an absolute http or https URL within the length limit whose
+// destination is allowed by the policy. Errors wrap ErrInvalidURL.
+func (s *Service) validateURL(ctx context.Context, u string) error {
+	if len(u) > s.config.MaxURLLength {
+		return fmt.Errorf("%w: %w: %d bytes, the maximum is %d", ErrInvalidURL, ErrURLTooLong, len(u), s.config.MaxURLLength)
+	}
+
 	parsedURL, err := url.ParseRequestURI(u)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
 	}
 
 	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return errors.New("URL must have http or https scheme")
+		return fmt.Errorf("%w: URL must have http or https scheme", ErrInvalidURL)
 	}
 
 	// Prevent SSRF attacks by disallowing URLs that point to localhost or private network addresses
-	if strings.HasPrefix(parsedURL.Hostname(), "localhost") || strings.HasPrefix(parsedURL.Hostname(), "127.0.0.1") {
-		return errors.New("invalid url host")
+	if err := s.destinations.Check(ctx, parsedURL.Hostname()); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	}
+
+	return nil
+}
+
+// validateSlug checks a custom slug against the allowed characters, the configured length and the reserved words
+func (s *Service) validateSlug(slug string) error {
+	if len(slug) < s.config.Slug.MinCustomLength || len(slug) > s.config.Slug.MaxCustomLength {
+		return fmt.Errorf("%w: must be between %d and %d characters", ErrInvalidSlug, s.config.Slug.MinCustomLength, s.config.Slug.MaxCustomLength)
+	}
+	if !customSlugPattern.MatchString(slug) {
+		return fmt.Errorf("%w: only letters, digits, '-' and '_' are allowed", ErrInvalidSlug)
 	}
 
+	return s.checkReserved(slug)
+}
+
+// checkReserved returns ErrSlugReserved if slug is one of the built-in or configured reserved words
+func (s *Service) checkReserved(slug string) error {
+	// Compare case-insensitively so /API cannot be mistaken for the API either
+	for _, words := range [][]string{reservedSlugs, s.config.Slug.Reserved} {
+		for _, reserved := range words {
+			if strings.EqualFold(slug, reserved) {
+				return fmt.Errorf("%w: %q", ErrSlugReserved, slug)
+			}
+		}
+	}
 	return nil
 }
+
+// newShortDomain parses a configured base URL. The primary domain is stored under an empty key,
+// so existing links keep working when its URL changes.
+func newShortDomain(baseURL string, primary bool) (ShortDomain, error) {
+	baseURL, err := normalizeDomain(baseURL)
+	if err != nil {
+		return ShortDomain{}, err
+	}
+
+	parsedURL, _ := url.Parse(baseURL)
+	domain := ShortDomain{
+		Host:     strings.ToLower(parsedURL.Host),
+		URL:      baseURL,
+		hostname: strings.ToLower(parsedURL.Hostname()),
+		path:     parsedURL.Path,
+	}
+	if domain.path == "" {
+		domain.path = "/"
+	}
+	if !primary {
+		domain.key = domain.Host
+	}
+	return domain, nil
+}
+
+// normalizeDomain checks that the configured domain is an absolute http or https URL and makes
+// sure it ends in a slash, so slugs can be appended to it
+func normalizeDomain(domain string) (string, error) {
+	if domain == "" {
+		return DefaultDomain, nil
+	}
+
+	parsedURL, err := url.Parse(domain)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain %q: %w", domain, err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" || parsedURL.Host == "" {
+		return "", fmt.Errorf("invalid domain %q: must be an http or https URL such as https://sho.rt/", domain)
+	}
+	if parsedURL.RawQuery != "" || parsedURL.Fragment != "" {
+		return "", fmt.Errorf("invalid domain %q: must not have a query or fragment", domain)
+	}
+
+	if !strings.HasSuffix(domain, "/") {
+		domain += "/"
+	}
+	return domain, nil
+}
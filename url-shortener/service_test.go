@@ -1,7 +1,15 @@
 package urlshortener
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestValidateURL(t *testing.T) {
@@ -40,11 +48,41 @@ func TestValidateURL(t *testing.T) {
 			url:     "not a url",
 			wantErr: true,
 		},
+		{
+			name:    "private network url",
+			url:     "http://10.0.0.1/admin",
+			wantErr: true,
+		},
+		{
+			name:    "cloud metadata url",
+			url:     "http://169.254.169.254/latest/meta-data/",
+			wantErr: true,
+		},
+		{
+			name:    "ipv6 loopback url",
+			url:     "http://[::1]:8080/",
+			wantErr: true,
+		},
+		{
+			name:    "decimal-encoded loopback url",
+			url:     "http://2130706433/",
+			wantErr: true,
+		},
+		{
+			name:    "long url within the limit",
+			url:     "https://example.com/?sig=" + strings.Repeat("a", 4000),
+			wantErr: false,
+		},
+		{
+			name:    "url over the limit",
+			url:     "https://example.com/?sig=" + strings.Repeat("a", DefaultMaxURLLength),
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateURL(tt.url)
+			err := newTestService(t, nil, ServiceConfig{}).validateURL(context.Background(), tt.url)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("validateURL() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -52,7 +90,29 @@ func TestValidateURL(t *testing.T) {
 	}
 }
 
-func TestGenerateShortURL(t *testing.T) {
+func TestValidateURLMaxLength(t *testing.T) {
+	svc := newTestService(t, nil, ServiceConfig{MaxURLLength: 30})
+
+	// Exactly at the limit is fine
+	err := svc.validateURL(context.Background(), "https://example.com/"+strings.Repeat("a", 10))
+	if err != nil {
+		t.Errorf("validateURL() error = %v, want nil", err)
+	}
+
+	// One byte more is rejected with ErrURLTooLong
+	err = svc.validateURL(context.Background(), "https://example.com/"+strings.Repeat("a", 11))
+	if !errors.Is(err, ErrURLTooLong) {
+		t.Errorf("validateURL() error = %v, want ErrURLTooLong", err)
+	}
+
+	// The limit counts bytes, so six two-byte characters are over it
+	err = svc.validateURL(context.Background(), "https://example.com/"+strings.Repeat("é", 6))
+	if !errors.Is(err, ErrURLTooLong) {
+		t.Errorf("validateURL() error = %v, want ErrURLTooLong", err)
+	}
+}
+
+func TestShorten(t *testing.T) {
 	tests := []struct {
 		name    string
 		url     string
@@ -92,10 +152,386 @@ func TestGenerateShortURL(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := (&URL{}).GenerateShortURL(tt.url)
+			_, err := newTestService(t, NewMemoryURLRepository(), ServiceConfig{}).Shorten(context.Background(), URLRequest{URL: tt.url})
 			if (err != nil) != tt.wantErr {
-				t.Errorf("GenerateShortURL() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Shorten() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+// newTestService returns a Service for repo, failing the test if the config is invalid
+func newTestService(t *testing.T, repo URLRepository, config ServiceConfig) *Service {
+	t.Helper()
+	svc, err := NewService(repo, config)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the service", err)
+	}
+	return svc
+}
+
+// slugCollisions returns the collision count published on /debug/vars
+func slugCollisions() int64 {
+	if v, ok := metrics.Get("slug_collisions").(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestShortenRetriesSlugCollisions(t *testing.T) {
+	// Create a new mock URL repository whose first four slugs are taken
+	repo := new(MockURLRepository)
+	var slugs []string
+	recordSlug := func(args mock.Arguments) {
+		slugs = append(slugs, args.Get(0).(*URLSchema).Slug)
+	}
+	repo.On("CreateURL", mock.Anything).Return(ErrSlugTaken).Times(4).Run(recordSlug)
+	repo.On("CreateURL", mock.Anything).Return(nil).Once().Run(recordSlug)
+
+	svc := newTestService(t, repo, ServiceConfig{Slug: SlugConfig{Length: 6, GrowAfter: 2, MaxAttempts: 10}})
+	before := slugCollisions()
+
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com"})
+	assert.NoError(t, err)
+
+	// The fifth slug was stored and every collision was counted
+	assert.Len(t, slugs, 5)
+	assert.Equal(t, slugs[4], url.Slug)
+	assert.Equal(t, before+4, slugCollisions())
+
+	// Slugs grow by one character after every two collisions
+	lengths := make([]int, len(slugs))
+	for i, slug := range slugs {
+		lengths[i] = len(slug)
+	}
+	assert.Equal(t, []int{6, 6, 7, 7, 8}, lengths)
+
+	repo.AssertExpectations(t)
+}
+
+func TestShortenGivesUpAfterMaxAttempts(t *testing.T) {
+	// Create a new mock URL repository where every slug is taken
+	repo := new(MockURLRepository)
+	repo.On("CreateURL", mock.Anything).Return(ErrSlugTaken).Times(3)
+
+	svc := newTestService(t, repo, ServiceConfig{Slug: SlugConfig{MaxAttempts: 3}})
+
+	_, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com"})
+	assert.ErrorIs(t, err, ErrSlugSpaceExhausted)
+
+	repo.AssertExpectations(t)
+}
+
+func TestShortenDuplicateLongURL(t *testing.T) {
+	// A long URL that is already stored is not retried as a collision
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{})
+
+	_, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com"})
+	assert.NoError(t, err)
+
+	before := slugCollisions()
+	_, err = svc.Shorten(context.Background(), URLRequest{URL: "http://example.com"})
+	assert.ErrorIs(t, err, ErrDuplicateURL)
+	assert.NotErrorIs(t, err, ErrSlugTaken)
+	assert.Equal(t, before, slugCollisions())
+}
+
+func TestShortenCanonicalURLs(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{Canonical: CanonicalConfig{StripParams: []string{"utm_*"}}})
+
+	// The canonical form is stored, the submitted URL is kept alongside it
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "HTTP://Example.com:80?utm_source=mail"})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/", url.LongUrl)
+	assert.Equal(t, "HTTP://Example.com:80?utm_source=mail", url.OriginalUrl)
+
+	// Equivalent URLs are duplicates
+	for _, u := range []string{"http://example.com", "http://EXAMPLE.com/", "http://example.com/?utm_campaign=x"} {
+		_, err = svc.Shorten(context.Background(), URLRequest{URL: u})
+		assert.ErrorIs(t, err, ErrDuplicateURL, u)
+	}
+
+	// And are found by any of their forms
+	found, err := svc.findURL(context.Background(), svc.domains[0], "http://Example.com")
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, url.Slug, found.Slug)
+	}
+}
+
+func TestValidateSlug(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{Slug: SlugConfig{Reserved: []string{"admin"}}})
+
+	tests := []struct {
+		name    string
+		slug    string
+		wantErr error
+	}{
+		{
+			name: "letters, digits and dashes",
+			slug: "spring-sale_2024",
+		},
+		{
+			name:    "too short",
+			slug:    "ab",
+			wantErr: ErrInvalidSlug,
+		},
+		{
+			name:    "too long",
+			slug:    strings.Repeat("a", 51),
+			wantErr: ErrInvalidSlug,
+		},
+		{
+			name:    "slash",
+			slug:    "spring/sale",
+			wantErr: ErrInvalidSlug,
+		},
+		{
+			name:    "non-ASCII letter",
+			slug:    "café",
+			wantErr: ErrInvalidSlug,
+		},
+		{
+			name:    "built-in route",
+			slug:    "shorten",
+			wantErr: ErrSlugReserved,
+		},
+		{
+			name:    "built-in route in another case",
+			slug:    "API",
+			wantErr: ErrSlugReserved,
+		},
+		{
+			name:    "configured reserved word",
+			slug:    "admin",
+			wantErr: ErrSlugReserved,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.validateSlug(tt.slug)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShortenCustomSlug(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{})
+
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com", Slug: "spring-sale"})
+	assert.NoError(t, err)
+	assert.Equal(t, "spring-sale", url.Slug)
+	assert.True(t, strings.HasSuffix(url.ShortUrl, "/spring-sale"))
+
+	// A taken custom slug is reported instead of being retried
+	before := slugCollisions()
+	_, err = svc.Shorten(context.Background(), URLRequest{URL: "http://example.org", Slug: "spring-sale"})
+	assert.ErrorIs(t, err, ErrSlugTaken)
+	assert.Equal(t, before, slugCollisions())
+
+	// Reserved words count as taken
+	_, err = svc.Shorten(context.Background(), URLRequest{URL: "http://example.org", Slug: "app"})
+	assert.ErrorIs(t, err, ErrSlugTaken)
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		name    string
+		domain  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "default",
+			domain: "",
+			want:   DefaultDomain,
+		},
+		{
+			name:   "trailing slash is added",
+			domain: "https://sho.rt",
+			want:   "https://sho.rt/",
+		},
+		{
+			name:   "path prefix",
+			domain: "https://example.com/s/",
+			want:   "https://example.com/s/",
+		},
+		{
+			name:    "no scheme",
+			domain:  "sho.rt",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported scheme",
+			domain:  "ftp://sho.rt/",
+			wantErr: true,
+		},
+		{
+			name:    "query",
+			domain:  "https://sho.rt/?s=",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeDomain(tt.domain)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("normalizeDomain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestShortenUsesConfiguredDomain(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{Domain: "https://sho.rt"})
+
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com", Slug: "spring-sale"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://sho.rt/spring-sale", url.ShortUrl)
+
+	url, err = svc.Shorten(context.Background(), URLRequest{URL: "http://example.org"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://sho.rt/"+url.Slug, url.ShortUrl)
+
+	// An invalid domain is rejected when the service is created
+	_, err = NewService(NewMemoryURLRepository(), ServiceConfig{Domain: "sho.rt"})
+	assert.Error(t, err)
+}
+
+func TestShortenDomains(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{Domain: "https://sho.rt", Domains: []string{"https://Brand.co/"}})
+
+	// The same slug can be minted once per domain
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com", Slug: "sale"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", url.Domain)
+	assert.Equal(t, "https://sho.rt/sale", url.ShortUrl)
+
+	url, err = svc.Shorten(context.Background(), URLRequest{URL: "http://example.com", Slug: "sale", Domain: "brand.co"})
+	assert.NoError(t, err)
+	assert.Equal(t, "brand.co", url.Domain)
+	assert.Equal(t, "https://Brand.co/sale", url.ShortUrl)
+
+	// The primary domain can also be selected by its host
+	_, err = svc.Shorten(context.Background(), URLRequest{URL: "http://example.org", Domain: "sho.rt"})
+	assert.NoError(t, err)
+
+	// Domains that are not configured are rejected
+	_, err = svc.Shorten(context.Background(), URLRequest{URL: "http://example.org", Domain: "evil.com"})
+	assert.ErrorIs(t, err, ErrUnknownDomain)
+
+	// Every domain is offered, the primary first
+	domains := svc.Domains()
+	if assert.Len(t, domains, 2) {
+		assert.Equal(t, "sho.rt", domains[0].Host)
+		assert.Equal(t, "brand.co", domains[1].Host)
+	}
+
+	// A domain cannot be configured twice
+	_, err = NewService(NewMemoryURLRepository(), ServiceConfig{Domain: "https://sho.rt", Domains: []string{"http://sho.rt/"}})
+	assert.Error(t, err)
+}
+
+func TestShortenSelfLinks(t *testing.T) {
+	config := ServiceConfig{Domain: "https://sho.rt", Domains: []string{"https://example.com/s/"}}
+
+	// By default links to our own domains are rejected
+	svc := newTestService(t, NewMemoryURLRepository(), config)
+	for _, u := range []string{
+		"https://sho.rt/abc123",
+		"http://SHO.RT:8080/abc123",
+		"https://sho.rt/",
+		"https://example.com/s/abc123",
+	} {
+		_, err := svc.Shorten(context.Background(), URLRequest{URL: u})
+		assert.ErrorIs(t, err, ErrSelfLink, u)
+		assert.ErrorIs(t, err, ErrInvalidURL, u)
+	}
+
+	// Other paths on a domain with a path prefix are not ours
+	_, err := svc.Shorten(context.Background(), URLRequest{URL: "https://example.com/about"})
+	assert.NoError(t, err)
+
+	// With resolve, links to our own domains are replaced by their destination
+	config.SelfLinks = SelfLinksResolve
+	svc = newTestService(t, NewMemoryURLRepository(), config)
+
+	first, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.org/target", Slug: "first"})
+	assert.NoError(t, err)
+
+	second, err := svc.Shorten(context.Background(), URLRequest{URL: first.ShortUrl, Slug: "second", Domain: "example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.org/target", second.LongUrl)
+
+	// Short links that do not exist cannot be resolved
+	_, err = svc.Shorten(context.Background(), URLRequest{URL: "https://sho.rt/missing"})
+	assert.ErrorIs(t, err, ErrSelfLink)
+
+	// Unknown modes are rejected
+	_, err = NewService(NewMemoryURLRepository(), ServiceConfig{SelfLinks: "follow"})
+	assert.Error(t, err)
+}
+
+func TestShortenSelfLinkRestricted(t *testing.T) {
+	// Resolving a self link to a click-limited link would lift its limit
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{Domain: "https://sho.rt", SelfLinks: SelfLinksResolve})
+	one := int64(1)
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com", MaxClicks: &one})
+	assert.NoError(t, err)
+
+	_, err = svc.Shorten(context.Background(), URLRequest{URL: url.ShortUrl})
+	assert.ErrorIs(t, err, ErrSelfLink)
+
+	// And so would resolving one to a password-protected link
+	url, err = svc.Shorten(context.Background(), URLRequest{URL: "http://example.org", Password: "s3cret"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, url.PasswordHash)
+
+	_, err = svc.Shorten(context.Background(), URLRequest{URL: url.ShortUrl})
+	assert.ErrorIs(t, err, ErrSelfLink)
+
+	// Or to a scheduled link
+	launch := time.Now().Add(time.Hour)
+	url, err = svc.Shorten(context.Background(), URLRequest{URL: "http://example.net", Window: &Window{NotBefore: &launch}})
+	assert.NoError(t, err)
+	assert.NotNil(t, url.NotBefore)
+
+	_, err = svc.Shorten(context.Background(), URLRequest{URL: url.ShortUrl})
+	assert.ErrorIs(t, err, ErrSelfLink)
+}
+
+func TestPrepareDestinationCycles(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryURLRepository()
+	svc := newTestService(t, repo, ServiceConfig{Domain: "https://sho.rt", SelfLinks: SelfLinksResolve})
+
+	// Links stored before self links were checked can chain: a -> b -> c
+	for _, u := range []*URLSchema{
+		{Slug: "a", ShortUrl: "https://sho.rt/a", LongUrl: "https://sho.rt/b"},
+		{Slug: "b", ShortUrl: "https://sho.rt/b", LongUrl: "https://sho.rt/c"},
+		{Slug: "c", ShortUrl: "https://sho.rt/c", LongUrl: "http://example.com"},
+	} {
+		assert.NoError(t, repo.CreateURL(ctx, u))
+	}
+
+	// A chain is followed to its end
+	got, err := svc.prepareDestination(ctx, "https://sho.rt/a", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com", got)
+
+	// Pointing c at a would close the loop a -> b -> c -> a
+	c, _ := repo.ReadURLBySlug(ctx, "", "c")
+	_, err = svc.prepareDestination(ctx, "https://sho.rt/a", c)
+	assert.ErrorIs(t, err, ErrRedirectCycle)
+
+	// So would pointing a link at itself
+	_, err = svc.prepareDestination(ctx, "https://sho.rt/c", c)
+	assert.ErrorIs(t, err, ErrRedirectCycle)
+}
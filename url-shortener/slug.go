@@ -0,0 +1,235 @@
+package urlshortener
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+const (
+	// SlugRandom draws every character of a slug at random from the alphabet
+	SlugRandom = "random"
+	// SlugSequential encodes a counter shared through the repository, giving the shortest possible slugs
+	SlugSequential = "sequential"
+	// SlugHashids encodes the shared counter like hashids, so consecutive slugs do not look related
+	SlugHashids = "hashids"
+)
+
+// Named alphabets that can be used instead of spelling out the characters
+var slugAlphabets = map[string]string{
+	"base62":    "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+	"base64url": "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_",
+	// base62 without characters that are easily confused when read aloud or printed: 0 O o 1 l I
+	"unambiguous": "23456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz",
+}
+
+// reservedSlugs are the paths served by URLHandler, which can never be claimed as a custom slug
+var reservedSlugs = []string{"api", "app", "shorten", "debug"}
+
+// customSlugPattern matches the characters allowed in a custom slug
+var customSlugPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// slugSequence is the name of the repository sequence that sequential and hashids slugs count with
+const slugSequence = "slugs"
+
+// SlugConfig is a struct that represents how slugs are generated and how collisions are retried
+type SlugConfig struct {
+	// Strategy is one of random, sequential or hashids
+	Strategy string `yaml:"strategy"`
+	// Alphabet is a named alphabet (base62, base64url, unambiguous) or the literal characters to use
+	Alphabet string `yaml:"alphabet"`
+	// Salt makes hashids slugs unique to this deployment
+	Salt string `yaml:"salt"`
+	// Length is the number of characters of a new slug, sequential and hashids slugs are padded to it
+	Length int `yaml:"length"`
+	// GrowAfter is the number of collisions at one length before slugs get one character longer
+	GrowAfter int `yaml:"grow_after"`
+	// MaxAttempts is the number of slugs tried before giving up
+	MaxAttempts int `yaml:"max_attempts"`
+	// MinCustomLength and MaxCustomLength bound the length of slugs picked by users
+	MinCustomLength int `yaml:"min_custom_length"`
+	MaxCustomLength int `yaml:"max_custom_length"`
+	// Reserved lists words that cannot be claimed as custom slugs, on top of the built-in routes
+	Reserved []string `yaml:"reserved"`
+}
+
+// SlugGenerator is an interface that represents a strategy for picking new slugs
+type SlugGenerator interface {
+	// Generate returns a slug of at least length characters
+	Generate(ctx context.Context, length int) (string, error)
+}
+
+// NewSlugGenerator returns the slug strategy selected by the config. Sequential strategies
+// draw their numbers from repo so instances sharing a database never hand out the same one.
+func NewSlugGenerator(config SlugConfig, repo URLRepository) (SlugGenerator, error) {
+	alphabet, err := slugAlphabet(config.Alphabet)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.Strategy {
+	case "", SlugRandom:
+		return &RandomSlugGenerator{alphabet: alphabet}, nil
+	case SlugSequential:
+		return &SequentialSlugGenerator{repo: repo, alphabet: alphabet}, nil
+	case SlugHashids:
+		return NewHashidsSlugGenerator(repo, alphabet, config.Salt), nil
+	default:
+		return nil, fmt.Errorf("unsupported slug strategy: %s", config.Strategy)
+	}
+}
+
+// slugAlphabet resolves a named alphabet, defaulting to base62, and checks custom ones
+func slugAlphabet(name string) (string, error) {
+	if name == "" {
+		name = "base62"
+	}
+	if alphabet, ok := slugAlphabets[name]; ok {
+		return alphabet, nil
+	}
+
+	seen := make(map[rune]bool)
+	for _, c := range name {
+		if seen[c] {
+			return "", fmt.Errorf("slug alphabet repeats the character %q", c)
+		}
+		if c > 127 || strings.ContainsRune("/?#%&=+ \t\n", c) {
+			return "", fmt.Errorf("slug alphabet contains %q, which is not safe in a URL path", c)
+		}
+		seen[c] = true
+	}
+	if len(seen) < 16 {
+		return "", fmt.Errorf("slug alphabet needs at least 16 characters, got %d", len(seen))
+	}
+	return name, nil
+}
+
+// RandomSlugGenerator is a SlugGenerator that draws every character uniformly from an alphabet
+type RandomSlugGenerator struct {
+	alphabet string
+}
+
+func (g *RandomSlugGenerator) Generate(ctx context.Context, length int) (string, error) {
+	max := big.NewInt(int64(len(g.alphabet)))
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("error generating random bytes: %w", err)
+		}
+		b[i] = g.alphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// SequentialSlugGenerator is a SlugGenerator that encodes the next value of a repository sequence
+// in the alphabet, left-padded with its first character
+type SequentialSlugGenerator struct {
+	repo     URLRepository
+	alphabet string
+}
+
+func (g *SequentialSlugGenerator) Generate(ctx context.Context, length int) (string, error) {
+	n, err := g.repo.NextSequence(ctx, slugSequence)
+	if err != nil {
+		return "", fmt.Errorf("error reading slug sequence: %w", err)
+	}
+
+	slug := encodeNumber(n, g.alphabet)
+	if len(slug) < length {
+		slug = strings.Repeat(g.alphabet[:1], length-len(slug)) + slug
+	}
+	return slug, nil
+}
+
+// HashidsSlugGenerator is a SlugGenerator that obfuscates a repository sequence the way hashids
+// does: a lottery character picked from the number reshuffles the salted alphabet, so consecutive
+// numbers produce unrelated slugs. Short slugs are padded after a separator character that never
+// appears in the encoded number, which keeps every padded slug unique.
+type HashidsSlugGenerator struct {
+	repo       URLRepository
+	alphabet   string
+	separators string
+	salt       string
+}
+
+// NewHashidsSlugGenerator returns a HashidsSlugGenerator for the alphabet and salt
+func NewHashidsSlugGenerator(repo URLRepository, alphabet, salt string) *HashidsSlugGenerator {
+	shuffled := consistentShuffle(alphabet, salt)
+
+	// Set aside a few characters as separators, one for every twelve in the alphabet
+	n := len(shuffled) / 12
+	if n < 1 {
+		n = 1
+	}
+	return &HashidsSlugGenerator{
+		repo:       repo,
+		alphabet:   shuffled[n:],
+		separators: shuffled[:n],
+		salt:       salt,
+	}
+}
+
+func (g *HashidsSlugGenerator) Generate(ctx context.Context, length int) (string, error) {
+	n, err := g.repo.NextSequence(ctx, slugSequence)
+	if err != nil {
+		return "", fmt.Errorf("error reading slug sequence: %w", err)
+	}
+	return g.encode(n, length), nil
+}
+
+// encode returns the padded hashids encoding of n
+func (g *HashidsSlugGenerator) encode(n uint64, length int) string {
+	lottery := g.alphabet[n%uint64(len(g.alphabet))]
+	alphabet := consistentShuffle(g.alphabet, string(lottery)+g.salt)
+	slug := string(lottery) + encodeNumber(n, alphabet)
+
+	if len(slug) < length {
+		separator := g.separators[n%uint64(len(g.separators))]
+		padding := consistentShuffle(g.alphabet+g.separators, slug+g.salt)
+		for len(padding) < length-len(slug)-1 {
+			padding += consistentShuffle(padding, g.salt)
+		}
+		slug += string(separator) + padding[:length-len(slug)-1]
+	}
+	return slug
+}
+
+// encodeNumber writes n in the positional system whose digits are the alphabet
+func encodeNumber(n uint64, alphabet string) string {
+	base := uint64(len(alphabet))
+	if n == 0 {
+		return alphabet[:1]
+	}
+
+	var b []byte
+	for n > 0 {
+		b = append(b, alphabet[n%base])
+		n /= base
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// consistentShuffle permutes the alphabet deterministically based on the salt, as hashids does
+func consistentShuffle(alphabet, salt string) string {
+	b := []byte(alphabet)
+	if salt == "" {
+		return alphabet
+	}
+
+	for i, v, p := len(b)-1, 0, 0; i > 0; i-- {
+		v %= len(salt)
+		c := int(salt[v])
+		p += c
+		j := (c + v + p) % i
+		b[i], b[j] = b[j], b[i]
+		v++
+	}
+	return string(b)
+}
@@ -0,0 +1,163 @@
+package urlshortener
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlugAlphabet(t *testing.T) {
+	tests := []struct {
+		name     string
+		alphabet string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "default is base62",
+			alphabet: "",
+			want:     slugAlphabets["base62"],
+		},
+		{
+			name:     "named alphabet",
+			alphabet: "unambiguous",
+			want:     slugAlphabets["unambiguous"],
+		},
+		{
+			name:     "custom alphabet",
+			alphabet: "abcdefghijklmnop",
+			want:     "abcdefghijklmnop",
+		},
+		{
+			name:     "repeated character",
+			alphabet: "abcdefghijklmnopa",
+			wantErr:  true,
+		},
+		{
+			name:     "unsafe character",
+			alphabet: "abcdefghijklmnop/",
+			wantErr:  true,
+		},
+		{
+			name:     "too short",
+			alphabet: "abc",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := slugAlphabet(tt.alphabet)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("slugAlphabet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewSlugGenerator(t *testing.T) {
+	repo := NewMemoryURLRepository()
+
+	for strategy, want := range map[string]SlugGenerator{
+		"":             &RandomSlugGenerator{},
+		SlugRandom:     &RandomSlugGenerator{},
+		SlugSequential: &SequentialSlugGenerator{},
+		SlugHashids:    &HashidsSlugGenerator{},
+	} {
+		g, err := NewSlugGenerator(SlugConfig{Strategy: strategy}, repo)
+		assert.NoError(t, err)
+		assert.IsType(t, want, g)
+	}
+
+	_, err := NewSlugGenerator(SlugConfig{Strategy: "uuid"}, repo)
+	assert.Error(t, err)
+
+	_, err = NewSlugGenerator(SlugConfig{Alphabet: "abc"}, repo)
+	assert.Error(t, err)
+}
+
+func TestRandomSlugGenerator(t *testing.T) {
+	g := &RandomSlugGenerator{alphabet: slugAlphabets["unambiguous"]}
+
+	for i := 0; i < 100; i++ {
+		slug, err := g.Generate(context.Background(), 8)
+		assert.NoError(t, err)
+		assert.Len(t, slug, 8)
+
+		// Only characters from the alphabet, and none of the lookalikes
+		for _, c := range slug {
+			assert.Contains(t, g.alphabet, string(c))
+		}
+		assert.False(t, strings.ContainsAny(slug, "0O1lI"), "slug %s contains a lookalike", slug)
+	}
+}
+
+func TestSequentialSlugGenerator(t *testing.T) {
+	g := &SequentialSlugGenerator{repo: NewMemoryURLRepository(), alphabet: slugAlphabets["base62"]}
+
+	// Numbers count up from 1 and are padded to the requested length
+	for _, want := range []string{"000001", "000002", "000003"} {
+		slug, err := g.Generate(context.Background(), 6)
+		assert.NoError(t, err)
+		assert.Equal(t, want, slug)
+	}
+
+	// Without padding the slug is as short as the number allows
+	slug, err := g.Generate(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "4", slug)
+}
+
+func TestEncodeNumber(t *testing.T) {
+	base62 := slugAlphabets["base62"]
+	assert.Equal(t, "0", encodeNumber(0, base62))
+	assert.Equal(t, "z", encodeNumber(61, base62))
+	assert.Equal(t, "10", encodeNumber(62, base62))
+	assert.Equal(t, "zz", encodeNumber(62*62-1, base62))
+}
+
+func TestHashidsSlugGenerator(t *testing.T) {
+	g := NewHashidsSlugGenerator(nil, slugAlphabets["base62"], "my salt")
+
+	// Every number gets a distinct slug of the requested length
+	seen := make(map[string]uint64)
+	for n := uint64(1); n <= 5000; n++ {
+		slug := g.encode(n, 6)
+		assert.Len(t, slug, 6)
+		if prev, ok := seen[slug]; ok {
+			t.Fatalf("numbers %d and %d both encode to %s", prev, n, slug)
+		}
+		seen[slug] = n
+	}
+
+	// Consecutive numbers do not share a prefix the way sequential slugs do
+	assert.NotEqual(t, g.encode(1, 6)[:3], g.encode(2, 6)[:3])
+
+	// Longer numbers are never truncated
+	assert.GreaterOrEqual(t, len(g.encode(1<<40, 2)), 7)
+
+	// The salt changes the encoding
+	other := NewHashidsSlugGenerator(nil, slugAlphabets["base62"], "another salt")
+	assert.NotEqual(t, g.encode(1, 6), other.encode(1, 6))
+
+	// Slugs are drawn from the repository sequence
+	g = NewHashidsSlugGenerator(NewMemoryURLRepository(), slugAlphabets["base62"], "my salt")
+	slug, err := g.Generate(context.Background(), 6)
+	assert.NoError(t, err)
+	assert.Equal(t, g.encode(1, 6), slug)
+}
+
+func TestShortenWithSequentialSlugs(t *testing.T) {
+	svc := newTestService(t, NewMemoryURLRepository(), ServiceConfig{Slug: SlugConfig{Strategy: SlugSequential, Length: 4}})
+
+	url, err := svc.Shorten(context.Background(), URLRequest{URL: "http://example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "0001", url.Slug)
+
+	url, err = svc.Shorten(context.Background(), URLRequest{URL: "http://example.org"})
+	assert.NoError(t, err)
+	assert.Equal(t, "0002", url.Slug)
+}
@@ -0,0 +1,51 @@
+package urlshortener
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxTags is how many tags one link can have
+	maxTags = 10
+	// maxTagLength is the length of the tag column
+	maxTagLength = 32
+)
+
+// ErrInvalidTag is returned when a link is tagged with a tag that is not allowed, or with too many
+var ErrInvalidTag = errors.New("invalid tag")
+
+// URLTag is a struct that represents one tag of a link
+type URLTag struct {
+	URLID uint   `gorm:"column:url_id;primary_key;auto_increment:false"`
+	Tag   string `gorm:"primary_key"`
+}
+
+// tagPattern matches the tags links can be labeled with, after they are lowercased
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// normalizeTags returns tags lowercased, sorted and without duplicates, so a link is found by a
+// tag however it was spelled. It returns an error wrapping ErrInvalidTag for a tag that is not allowed.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if len(tag) > maxTagLength || !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("%w: %q, tags are letters, digits, - and _ up to %d characters", ErrInvalidTag, tag, maxTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("%w: a link can have at most %d tags", ErrInvalidTag, maxTags)
+	}
+
+	sort.Strings(normalized)
+	return normalized, nil
+}
@@ -0,0 +1,39 @@
+package urlshortener
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "None", tags: nil, want: []string{}},
+		{name: "Sorted", tags: []string{"news", "docs"}, want: []string{"docs", "news"}},
+		{name: "Case and spaces", tags: []string{" Q3-Launch ", "q3-launch", "team_a"}, want: []string{"q3-launch", "team_a"}},
+		{name: "Empty", tags: []string{""}, wantErr: true},
+		{name: "Leading dash", tags: []string{"-docs"}, wantErr: true},
+		{name: "Space inside", tags: []string{"two words"}, wantErr: true},
+		{name: "Too long", tags: []string{strings.Repeat("a", maxTagLength+1)}, wantErr: true},
+		{name: "Too many", tags: strings.Split("a b c d e f g h i j k", " "), wantErr: true},
+		{name: "Duplicates do not count", tags: strings.Split("a b c d e f g h i j j", " "), want: strings.Split("a b c d e f g h i j", " ")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeTags(tt.tags)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTag)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
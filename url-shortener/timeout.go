@@ -0,0 +1,136 @@
+package urlshortener
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// OperationTimeouts is a struct that holds the deadline applied to each kind of repository call.
+// A zero duration leaves the caller's context untouched.
+type OperationTimeouts struct {
+	Create time.Duration `yaml:"create"`
+	Read   time.Duration `yaml:"read"`
+	Update time.Duration `yaml:"update"`
+	Delete time.Duration `yaml:"delete"`
+}
+
+// TimeoutURLRepository is a URLRepository that bounds every call to another repository by a per-operation deadline
+type TimeoutURLRepository struct {
+	repo     URLRepository
+	timeouts OperationTimeouts
+}
+
+// NewTimeoutURLRepository wraps repo so each call is cancelled after the matching timeout
+func NewTimeoutURLRepository(repo URLRepository, timeouts OperationTimeouts) *TimeoutURLRepository {
+	return &TimeoutURLRepository{
+		repo:     repo,
+		timeouts: timeouts,
+	}
+}
+
+// Close closes the wrapped repository, if it has anything to close
+func (t *TimeoutURLRepository) Close() error {
+	if closer, ok := t.repo.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (t *TimeoutURLRepository) CreateURL(ctx context.Context, u *URLSchema) error {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Create)
+	defer cancel()
+	return t.repo.CreateURL(ctx, u)
+}
+
+func (t *TimeoutURLRepository) CreateURLs(ctx context.Context, urls []*URLSchema, all bool) ([]error, error) {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Create)
+	defer cancel()
+	return t.repo.CreateURLs(ctx, urls, all)
+}
+
+func (t *TimeoutURLRepository) ReadURL(ctx context.Context, domain, longURL string) (*URLSchema, error) {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Read)
+	defer cancel()
+	return t.repo.ReadURL(ctx, domain, longURL)
+}
+
+func (t *TimeoutURLRepository) ReadURLBySlug(ctx context.Context, domain, slug string) (*URLSchema, error) {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Read)
+	defer cancel()
+	return t.repo.ReadURLBySlug(ctx, domain, slug)
+}
+
+func (t *TimeoutURLRepository) UpdateURL(ctx context.Context, domain, longURL, newLongURL string) error {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Update)
+	defer cancel()
+	return t.repo.UpdateURL(ctx, domain, longURL, newLongURL)
+}
+
+func (t *TimeoutURLRepository) UpdateLink(ctx context.Context, domain, slug string, update LinkUpdate) error {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Update)
+	defer cancel()
+	return t.repo.UpdateLink(ctx, domain, slug, update)
+}
+
+func (t *TimeoutURLRepository) DeleteURL(ctx context.Context, domain, longURL string) error {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Delete)
+	defer cancel()
+	return t.repo.DeleteURL(ctx, domain, longURL)
+}
+
+func (t *TimeoutURLRepository) DeleteURLBySlug(ctx context.Context, domain, slug string) error {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Delete)
+	defer cancel()
+	return t.repo.DeleteURLBySlug(ctx, domain, slug)
+}
+
+func (t *TimeoutURLRepository) ConsumeClick(ctx context.Context, domain, slug string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Update)
+	defer cancel()
+	return t.repo.ConsumeClick(ctx, domain, slug)
+}
+
+func (t *TimeoutURLRepository) DeleteExpiredURLs(ctx context.Context, before time.Time, purge bool) (int64, error) {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Delete)
+	defer cancel()
+	return t.repo.DeleteExpiredURLs(ctx, before, purge)
+}
+
+func (t *TimeoutURLRepository) RecordClicks(ctx context.Context, events []ClickEvent) error {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Create)
+	defer cancel()
+	return t.repo.RecordClicks(ctx, events)
+}
+
+func (t *TimeoutURLRepository) ClickStats(ctx context.Context, urlID uint, since time.Time, bucket time.Duration) (*ClickStats, error) {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Read)
+	defer cancel()
+	return t.repo.ClickStats(ctx, urlID, since, bucket)
+}
+
+func (t *TimeoutURLRepository) ListURLs(ctx context.Context, filter URLFilter) ([]URLSchema, error) {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Read)
+	defer cancel()
+	return t.repo.ListURLs(ctx, filter)
+}
+
+func (t *TimeoutURLRepository) ExportURLs(ctx context.Context, afterID uint, limit int, deleted bool) ([]URLSchema, error) {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Read)
+	defer cancel()
+	return t.repo.ExportURLs(ctx, afterID, limit, deleted)
+}
+
+func (t *TimeoutURLRepository) NextSequence(ctx context.Context, name string) (uint64, error) {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Update)
+	defer cancel()
+	return t.repo.NextSequence(ctx, name)
+}
+
+// withTimeout derives a context that expires after d, or returns ctx unchanged when d is zero
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
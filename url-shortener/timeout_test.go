@@ -0,0 +1,51 @@
+package urlshortener
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// deadlineRepository records the deadline of the context passed to ReadURLBySlug
+type deadlineRepository struct {
+	MockURLRepository
+	deadline    time.Time
+	hasDeadline bool
+}
+
+func (d *deadlineRepository) ReadURLBySlug(ctx context.Context, domain, slug string) (*URLSchema, error) {
+	d.deadline, d.hasDeadline = ctx.Deadline()
+	return d.MockURLRepository.ReadURLBySlug(ctx, domain, slug)
+}
+
+func TestTimeoutURLRepository(t *testing.T) {
+	// Create a repository that only bounds reads
+	inner := &deadlineRepository{}
+	inner.On("ReadURLBySlug", "", "abc123").Return(nil, nil)
+	inner.On("DeleteURL", "", mock.Anything).Return(nil)
+	repo := NewTimeoutURLRepository(inner, OperationTimeouts{Read: time.Second})
+
+	// Reads get a deadline
+	start := time.Now()
+	_, err := repo.ReadURLBySlug(context.Background(), "", "abc123")
+	assert.NoError(t, err)
+	assert.True(t, inner.hasDeadline)
+	assert.WithinDuration(t, start.Add(time.Second), inner.deadline, 100*time.Millisecond)
+
+	// Operations without a timeout pass the context through
+	err = repo.DeleteURL(context.Background(), "", "http://example.com")
+	assert.NoError(t, err)
+
+	inner.AssertExpectations(t)
+}
+
+func TestTimeoutURLRepositoryExpires(t *testing.T) {
+	// A deadline that has already passed fails the call
+	repo := NewTimeoutURLRepository(NewMemoryURLRepository(), OperationTimeouts{Create: time.Nanosecond})
+
+	err := repo.CreateURL(context.Background(), &URLSchema{Slug: "abc123", LongUrl: "http://example.com"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}